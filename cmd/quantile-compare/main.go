@@ -0,0 +1,96 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+/*
+Command quantile-compare compares the registered estimators of package
+compare over the generated distributions, or recommends one for recorded
+data.
+
+	quantile-compare -n 1000000 -format csv > matrix.csv
+	quantile-compare -recommend 0.001 < latencies.txt
+
+With -recommend the numbers are read one per line from stdin.
+*/
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/streadway/quantile/compare"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run is the command with its arguments and standard streams, returning the
+// exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("quantile-compare", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	n := flags.Int("n", 100000, "values per generated distribution")
+	seed := flags.Int64("seed", 1, "seed of the generated distributions")
+	format := flags.String("format", "text", "output format: text or csv")
+	recommend := flags.Float64("recommend", 0, "read values from stdin and recommend the smallest backend within this rank error")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	write := compare.WriteText
+	switch *format {
+	case "text":
+	case "csv":
+		write = compare.WriteCSV
+	default:
+		fmt.Fprintf(stderr, "quantile-compare: unknown format %q\n", *format)
+		return 2
+	}
+
+	var results []compare.Result
+	if *recommend > 0 {
+		values, err := read(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "quantile-compare: %v\n", err)
+			return 1
+		}
+		best, all, ok := compare.Recommend(compare.Backends(), values, *recommend)
+		if ok {
+			fmt.Fprintf(stderr, "quantile-compare: recommend %s\n", best.Backend)
+		} else {
+			fmt.Fprintf(stderr, "quantile-compare: no backend within rank error %g\n", *recommend)
+		}
+		results = all
+	} else {
+		results = compare.Run(compare.Backends(), compare.Datasets(*n, *seed), compare.Grid)
+	}
+
+	if err := write(stdout, results); err != nil {
+		fmt.Fprintf(stderr, "quantile-compare: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func read(r io.Reader) ([]float64, error) {
+	var values []float64
+	lines := bufio.NewScanner(r)
+	for line := 1; lines.Scan(); line++ {
+		text := strings.TrimSpace(lines.Text())
+		if text == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("stdin:%d: %v", line, err)
+		}
+		values = append(values, v)
+	}
+	return values, lines.Err()
+}
@@ -0,0 +1,55 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func runWith(t *testing.T, stdin string, args ...string) (status int, stdout, stderr string) {
+	t.Helper()
+	var out, errs bytes.Buffer
+	status = run(args, strings.NewReader(stdin), &out, &errs)
+	return status, out.String(), errs.String()
+}
+
+func TestRunMatrix(t *testing.T) {
+	status, out, errs := runWith(t, "", "-n", "1000", "-format", "csv")
+	if status != 0 || errs != "" {
+		t.Fatalf("exit %d: %s", status, errs)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); !strings.HasPrefix(lines[0], "dataset,backend,bytes") || len(lines) < 2 {
+		t.Fatalf("want a csv table, got:\n%s", out)
+	}
+	if !strings.Contains(out, ",p2 grid,") {
+		t.Fatalf("want the P2 backend in the table, got:\n%s", out)
+	}
+}
+
+func TestRunRecommend(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 10000; i++ {
+		input.WriteString(strings.Repeat("1", 1+i%5) + "\n")
+	}
+	status, out, errs := runWith(t, input.String(), "-recommend", "0.01")
+	if status != 0 {
+		t.Fatalf("exit %d: %s", status, errs)
+	}
+	if !strings.Contains(errs, "recommend ") || !strings.Contains(out, "recorded") {
+		t.Fatalf("want a recommendation and the results, got %q and:\n%s", errs, out)
+	}
+
+	if status, _, errs := runWith(t, "1\nx\n", "-recommend", "0.01"); status != 1 || !strings.Contains(errs, "stdin:2") {
+		t.Fatalf("want exit 1 naming the bad line, got %d: %s", status, errs)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	if status, _, _ := runWith(t, "", "-format", "xml"); status != 2 {
+		t.Fatalf("want exit 2 for an unknown format, got %d", status)
+	}
+}
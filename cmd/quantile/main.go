@@ -0,0 +1,264 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+/*
+Command quantile estimates quantiles of the numbers in files or on stdin.
+
+	quantile [flags] [file ...]
+
+Each line holds a number, or with -column the number is taken from that
+whitespace separated field, or comma separated with -csv.  Lines that do not
+parse, or hold NaN, are reported with their line number and skipped, or with
+-strict end the run.  Infinities are numbers that rank below or above all
+others.  Memory stays constant however long the input.
+
+	cut -d' ' -f3 access.log | quantile -q 0.5,0.99
+	quantile -column 3 -format json access.log
+*/
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/streadway/quantile"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type config struct {
+	quantiles []float64
+	column    int
+	csv       bool
+	strict    bool
+}
+
+// run is the command with its arguments and standard streams, returning the
+// exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("quantile", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	targets := flags.String("q", "0.5,0.9,0.99", "comma separated quantiles to report")
+	epsilon := flags.Float64("e", 0.001, "tolerated rank error of every quantile")
+	column := flags.Int("column", 0, "1-based field holding the number, 0 for the whole line")
+	csv := flags.Bool("csv", false, "separate fields by commas instead of whitespace")
+	format := flags.String("format", "plain", "output format: plain, json or histogram")
+	strict := flags.Bool("strict", false, "end the run at the first line that does not parse")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	c := config{column: *column, csv: *csv, strict: *strict}
+	for _, field := range strings.Split(*targets, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || q < 0 || q > 1 {
+			fmt.Fprintf(stderr, "quantile: invalid quantile %q\n", field)
+			return 2
+		}
+		c.quantiles = append(c.quantiles, q)
+	}
+
+	var write func(io.Writer, *quantile.Estimator, []float64) error
+	switch *format {
+	case "plain":
+		write = writePlain
+	case "json":
+		write = writeJSON
+	case "histogram":
+		write = writeHistogram
+	default:
+		fmt.Fprintf(stderr, "quantile: unknown format %q\n", *format)
+		return 2
+	}
+
+	estimates := make([]quantile.Estimate, 0, len(c.quantiles))
+	for _, q := range c.quantiles {
+		estimates = append(estimates, quantile.Known(q, *epsilon))
+	}
+	est, err := quantile.NewChecked(estimates...)
+	if err != nil {
+		fmt.Fprintf(stderr, "quantile: invalid -e %g: %v\n", *epsilon, err)
+		return 2
+	}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	skipped := 0
+	for _, name := range files {
+		n, err := readFile(name, stdin, est, c, stderr)
+		skipped += n
+		if err != nil {
+			fmt.Fprintf(stderr, "quantile: %v\n", err)
+			return 1
+		}
+	}
+	if skipped > 0 {
+		fmt.Fprintf(stderr, "quantile: skipped %d lines\n", skipped)
+	}
+
+	if err := write(stdout, est, c.quantiles); err != nil {
+		fmt.Fprintf(stderr, "quantile: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readFile adds the numbers in the named file, or stdin for "-", returning
+// the number of lines skipped.
+func readFile(name string, stdin io.Reader, est *quantile.Estimator, c config, stderr io.Writer) (int, error) {
+	r := stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		r = f
+	} else {
+		name = "stdin"
+	}
+	return read(name, r, est, c, stderr)
+}
+
+func read(name string, r io.Reader, est *quantile.Estimator, c config, stderr io.Writer) (int, error) {
+	skipped := 0
+	lines := bufio.NewScanner(r)
+	for line := 1; lines.Scan(); line++ {
+		text := strings.TrimSpace(lines.Text())
+		if text == "" {
+			continue
+		}
+
+		v, err := parse(text, c)
+		if err != nil {
+			err = fmt.Errorf("%s:%d: %v", name, line, err)
+			if c.strict {
+				return skipped, err
+			}
+			fmt.Fprintf(stderr, "quantile: %v\n", err)
+			skipped++
+			continue
+		}
+		est.Add(v)
+	}
+	return skipped, lines.Err()
+}
+
+// parse takes the configured column of a line as a number.
+func parse(line string, c config) (float64, error) {
+	field := line
+	if c.column > 0 {
+		var fields []string
+		if c.csv {
+			fields = strings.Split(line, ",")
+		} else {
+			fields = strings.Fields(line)
+		}
+		if c.column > len(fields) {
+			return 0, fmt.Errorf("no column %d in %d fields", c.column, len(fields))
+		}
+		field = strings.TrimSpace(fields[c.column-1])
+	}
+	v, err := strconv.ParseFloat(field, 64)
+	if err == nil && v != v {
+		// the estimator would drop it without a trace
+		return 0, fmt.Errorf("%q has no rank", field)
+	}
+	return v, err
+}
+
+func writePlain(w io.Writer, est *quantile.Estimator, quantiles []float64) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "count\t%d\n", est.Count())
+	for _, q := range quantiles {
+		v, _ := est.GetOK(q)
+		fmt.Fprintf(bw, "%g\t%g\n", q, v)
+	}
+	return bw.Flush()
+}
+
+func writeJSON(w io.Writer, est *quantile.Estimator, quantiles []float64) error {
+	type estimate struct {
+		Quantile float64 `json:"quantile"`
+		Value    *number `json:"value"`
+	}
+	report := struct {
+		Count     int        `json:"count"`
+		Quantiles []estimate `json:"quantiles"`
+	}{Count: int(est.Count())}
+
+	for _, q := range quantiles {
+		e := estimate{Quantile: q}
+		// JSON has no NaN, an empty input reports null
+		if v, ok := est.GetOK(q); ok {
+			n := number(v)
+			e.Value = &n
+		}
+		report.Quantiles = append(report.Quantiles, e)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// number encodes the infinities JSON has no numbers for as the strings
+// "+Inf" and "-Inf".
+type number float64
+
+func (n number) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(n), 0) {
+		return json.Marshal(strconv.FormatFloat(float64(n), 'g', -1, 64))
+	}
+	return json.Marshal(float64(n))
+}
+
+// histogramWidth is the length of the bar of the maximum.
+const histogramWidth = 40
+
+// writeHistogram draws a bar per quantile, its length proportional to the
+// value between the finite minimum and maximum.  Infinities have no place on
+// that scale, -Inf draws no bar and +Inf a full one.
+func writeHistogram(w io.Writer, est *quantile.Estimator, quantiles []float64) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "count %d\n", est.Count())
+	if est.Count() == 0 {
+		return bw.Flush()
+	}
+
+	values := est.GetAll(quantiles...)
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range append([]float64{est.Get(0), est.Get(1)}, values...) {
+		if !math.IsInf(v, 0) {
+			min, max = math.Min(min, v), math.Max(max, v)
+		}
+	}
+
+	for i, q := range quantiles {
+		v := values[i]
+		var bar int
+		switch {
+		case math.IsInf(v, -1):
+			// no bar
+		case math.IsInf(v, 1) || !(max > min):
+			bar = histogramWidth
+		default:
+			bar = int(math.Round((v - min) / (max - min) * histogramWidth))
+		}
+		fmt.Fprintf(bw, "%-6g |%-*s| %g\n", q, histogramWidth, strings.Repeat("#", bar), v)
+	}
+	return bw.Flush()
+}
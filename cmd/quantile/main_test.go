@@ -0,0 +1,193 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runWith(t *testing.T, stdin string, args ...string) (status int, stdout, stderr string) {
+	t.Helper()
+	var out, errs bytes.Buffer
+	status = run(args, strings.NewReader(stdin), &out, &errs)
+	return status, out.String(), errs.String()
+}
+
+func TestRunPlain(t *testing.T) {
+	status, out, errs := runWith(t, "3\n1\n\n2\n", "-q", "0,0.5,1")
+	if status != 0 || errs != "" {
+		t.Fatalf("exit %d: %s", status, errs)
+	}
+	if want := "count\t3\n0\t1\n0.5\t2\n1\t3\n"; out != want {
+		t.Fatalf("want %q, got %q", want, out)
+	}
+}
+
+func TestRunColumns(t *testing.T) {
+	for _, c := range []struct {
+		input string
+		args  []string
+	}{
+		{"a 1 x\nb 2 y\nc 3 z\n", []string{"-column", "2"}},
+		{"a,1,x\nb, 2 ,y\nc,3,z\n", []string{"-column", "2", "-csv"}},
+	} {
+		status, out, errs := runWith(t, c.input, append(c.args, "-q", "0.5")...)
+		if status != 0 || errs != "" || out != "count\t3\n0.5\t2\n" {
+			t.Errorf("%v: exit %d, output %q, errors %q", c.args, status, out, errs)
+		}
+	}
+}
+
+func TestRunParseErrors(t *testing.T) {
+	input := "1\nfast\n2\n3 4\n"
+
+	status, out, errs := runWith(t, input, "-q", "1", "-column", "1")
+	if status != 0 || out != "count\t3\n1\t3\n" {
+		t.Fatalf("exit %d, output %q", status, out)
+	}
+	for _, want := range []string{"stdin:2:", "skipped 1 lines"} {
+		if !strings.Contains(errs, want) {
+			t.Errorf("want %q in %q", want, errs)
+		}
+	}
+
+	status, out, errs = runWith(t, input, "-strict")
+	if status != 1 || out != "" || !strings.Contains(errs, "stdin:2:") {
+		t.Fatalf("strict: exit %d, output %q, errors %q", status, out, errs)
+	}
+
+	status, _, errs = runWith(t, "1 2\n", "-column", "3")
+	if status != 0 || !strings.Contains(errs, "stdin:1: no column 3 in 2 fields") {
+		t.Fatalf("missing column: exit %d, errors %q", status, errs)
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(a, []byte("1\n2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("3\nx\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, out, errs := runWith(t, "", "-q", "1", a, b)
+	if status != 0 || out != "count\t3\n1\t3\n" || !strings.Contains(errs, b+":2:") {
+		t.Fatalf("exit %d, output %q, errors %q", status, out, errs)
+	}
+
+	if status, _, _ := runWith(t, "", filepath.Join(dir, "missing")); status != 1 {
+		t.Fatalf("want exit 1 for a missing file, got %d", status)
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	var report struct {
+		Count     int
+		Quantiles []struct {
+			Quantile float64
+			Value    *float64
+		}
+	}
+
+	_, out, _ := runWith(t, "1\n2\n3\n", "-format", "json", "-q", "0.5,1")
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Count != 3 || len(report.Quantiles) != 2 || *report.Quantiles[0].Value != 2 || *report.Quantiles[1].Value != 3 {
+		t.Fatalf("got %s", out)
+	}
+
+	// no NaN in JSON, empty inputs have no values
+	_, out, _ = runWith(t, "", "-format", "json", "-q", "0.5")
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Count != 0 || report.Quantiles[0].Value != nil {
+		t.Fatalf("empty input: got %s", out)
+	}
+}
+
+func TestRunHistogram(t *testing.T) {
+	_, out, _ := runWith(t, "0\n5\n10\n", "-format", "histogram", "-q", "0,0.5,1")
+	want := "count 3\n" +
+		"0      |" + strings.Repeat(" ", 40) + "| 0\n" +
+		"0.5    |" + strings.Repeat("#", 20) + strings.Repeat(" ", 20) + "| 5\n" +
+		"1      |" + strings.Repeat("#", 40) + "| 10\n"
+	if out != want {
+		t.Fatalf("want\n%s\ngot\n%s", want, out)
+	}
+}
+
+func TestRunNonFinite(t *testing.T) {
+	input := "1\ninf\n2\n-inf\nnan\n3\n"
+
+	// NaN has no rank and is skipped like a parse error
+	status, out, errs := runWith(t, input, "-q", "0,0.5,1")
+	if status != 0 || out != "count\t5\n0\t-Inf\n0.5\t2\n1\t+Inf\n" {
+		t.Fatalf("exit %d, output %q", status, out)
+	}
+	for _, want := range []string{"stdin:5:", "skipped 1 lines"} {
+		if !strings.Contains(errs, want) {
+			t.Errorf("want %q in %q", want, errs)
+		}
+	}
+	if status, _, _ := runWith(t, input, "-strict"); status != 1 {
+		t.Fatalf("strict: want exit 1 for NaN, got %d", status)
+	}
+
+	// bars scaled between the finite values
+	_, out, _ = runWith(t, input, "-format", "histogram", "-q", "0,0.4,0.6,0.8,1")
+	want := "count 5\n" +
+		"0      |" + strings.Repeat(" ", 40) + "| -Inf\n" +
+		"0.4    |" + strings.Repeat(" ", 40) + "| 1\n" +
+		"0.6    |" + strings.Repeat("#", 20) + strings.Repeat(" ", 20) + "| 2\n" +
+		"0.8    |" + strings.Repeat("#", 40) + "| 3\n" +
+		"1      |" + strings.Repeat("#", 40) + "| +Inf\n"
+	if out != want {
+		t.Fatalf("want\n%s\ngot\n%s", want, out)
+	}
+	_, out, _ = runWith(t, "inf\n", "-format", "histogram", "-q", "0.5")
+	if want := "count 1\n0.5    |" + strings.Repeat("#", 40) + "| +Inf\n"; out != want {
+		t.Fatalf("want\n%s\ngot\n%s", want, out)
+	}
+
+	// JSON has no infinities, they are strings
+	var report struct {
+		Quantiles []struct {
+			Quantile float64
+			Value    interface{}
+		}
+	}
+	status, out, _ = runWith(t, input, "-format", "json", "-q", "0,0.6,1")
+	if err := json.Unmarshal([]byte(out), &report); status != 0 || err != nil {
+		t.Fatalf("exit %d: %v in %s", status, err, out)
+	}
+	if v := report.Quantiles; len(v) != 3 || v[0].Value != "-Inf" || v[1].Value != 2.0 || v[2].Value != "+Inf" {
+		t.Fatalf("got %s", out)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"-q", "1.5"},
+		{"-q", "p99"},
+		{"-format", "xml"},
+		{"-e", "0.5"},
+		{"-e", "-0.01"},
+		{"-e", "NaN"},
+		{"-nope"},
+	} {
+		if status, _, errs := runWith(t, "", args...); status != 2 || errs == "" {
+			t.Errorf("%v: want exit 2 with a message, got %d %q", args, status, errs)
+		}
+	}
+}
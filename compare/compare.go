@@ -0,0 +1,334 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+/*
+Package compare runs quantile estimators side by side over the same streams
+and reports their memory, speed and observed error.
+
+	results := compare.Run(compare.Backends(), compare.Datasets(100000, 1), compare.Grid)
+	compare.WriteText(os.Stdout, results)
+
+To choose an estimator for recorded data, pass it to Recommend with the rank
+error the application can tolerate.
+*/
+package compare
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unsafe"
+
+	"github.com/streadway/quantile"
+	"github.com/streadway/quantile/quantiletest"
+)
+
+// Grid is the default set of quantiles errors are observed at.
+var Grid = []float64{0.01, 0.1, 0.5, 0.9, 0.99, 0.999}
+
+// Estimator is what a backend must implement to be compared.
+type Estimator interface {
+	Add(value float64)
+	Get(quantile float64) float64
+}
+
+// Backend constructs estimators of one kind.
+type Backend struct {
+	Name string
+	New  func() Estimator
+
+	// Bytes returns the memory held by an estimator.  When nil, the growth
+	// of the heap while adding the values is reported instead, which is
+	// noisier.
+	Bytes func(Estimator) int
+}
+
+var (
+	mu       sync.Mutex
+	backends []Backend
+)
+
+// Register adds b to the backends returned by Backends.
+func Register(b Backend) {
+	mu.Lock()
+	defer mu.Unlock()
+	backends = append(backends, b)
+}
+
+// Backends returns the registered backends in the order they were
+// registered.
+func Backends() []Backend {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Backend(nil), backends...)
+}
+
+func estimatorBytes(est Estimator) int {
+	return est.(*quantile.Estimator).Stats().Bytes
+}
+
+func init() {
+	Register(Backend{
+		Name:  "exact",
+		New:   func() Estimator { return &quantiletest.Exact{} },
+		Bytes: func(est Estimator) int { return 8 * est.(*quantiletest.Exact).Samples() },
+	})
+	Register(Backend{
+		Name:  "unknown 0.01",
+		New:   func() Estimator { return quantile.New(quantile.Unknown(0.01)) },
+		Bytes: estimatorBytes,
+	})
+	Register(Backend{
+		Name:  "unknown 0.001",
+		New:   func() Estimator { return quantile.New(quantile.Unknown(0.001)) },
+		Bytes: estimatorBytes,
+	})
+	Register(Backend{
+		Name: "known grid 0.001",
+		New: func() Estimator {
+			invariants := make([]quantile.Estimate, len(Grid))
+			for i, q := range Grid {
+				invariants[i] = quantile.Known(q, 0.001)
+			}
+			return quantile.New(invariants...)
+		},
+		Bytes: estimatorBytes,
+	})
+	Register(Backend{
+		Name: "p2 grid",
+		New: func() Estimator {
+			g := make(p2Grid, len(Grid))
+			for i, q := range Grid {
+				g[i] = quantile.NewP2(q)
+			}
+			return g
+		},
+		Bytes: func(est Estimator) int { return len(est.(p2Grid)) * int(unsafe.Sizeof(quantile.P2{})) },
+	})
+}
+
+// p2Grid estimates every quantile of Grid with a P2 of its own, and other
+// quantiles with the P2 of the nearest one.
+type p2Grid []*quantile.P2
+
+func (g p2Grid) Add(value float64) {
+	for _, e := range g {
+		e.Add(value)
+	}
+}
+
+func (g p2Grid) Get(q float64) float64 {
+	nearest := g[0]
+	for _, e := range g[1:] {
+		if math.Abs(e.Quantile()-q) < math.Abs(nearest.Quantile()-q) {
+			nearest = e
+		}
+	}
+	return nearest.Get(q)
+}
+
+// Dataset is a named stream of values.
+type Dataset struct {
+	Name   string
+	Values []float64
+}
+
+// Datasets returns n values of every distribution of quantiletest drawn from
+// seed.
+func Datasets(n int, seed int64) []Dataset {
+	var all []Dataset
+	for _, d := range quantiletest.Distributions() {
+		all = append(all, Dataset{Name: d.Name, Values: d.Generate(n, seed)})
+	}
+	return all
+}
+
+// Result is the outcome of one backend over one dataset.
+type Result struct {
+	Backend string
+	Dataset string
+
+	Bytes    int
+	AddNanos float64
+	GetNanos float64
+
+	// Quantiles holds the quantiles the errors were observed at.
+	Quantiles []float64
+
+	// RankError is the rank error of the estimate of each quantile as a
+	// fraction of the values.
+	RankError []float64
+
+	// ValueError is the distance of each estimate from the exact quantile,
+	// relative to the exact quantile unless it is zero.
+	ValueError []float64
+}
+
+// MaxRankError returns the largest rank error over all quantiles.
+func (r Result) MaxRankError() float64 {
+	return maxOf(r.RankError)
+}
+
+// MaxValueError returns the largest value error over all quantiles.
+func (r Result) MaxValueError() float64 {
+	return maxOf(r.ValueError)
+}
+
+func maxOf(xs []float64) float64 {
+	max := 0.0
+	for _, x := range xs {
+		max = math.Max(max, x)
+	}
+	return max
+}
+
+// getRounds is how often every quantile is queried to time Get.
+const getRounds = 100
+
+// Run adds the values of every dataset to a new estimator of every backend
+// and observes the estimates at quantiles.
+func Run(backends []Backend, datasets []Dataset, quantiles []float64) []Result {
+	var results []Result
+	for _, data := range datasets {
+		exact := quantiletest.NewExact(append([]float64(nil), data.Values...))
+		for _, b := range backends {
+			results = append(results, measure(b, data, exact, quantiles))
+		}
+	}
+	return results
+}
+
+func measure(b Backend, data Dataset, exact *quantiletest.Exact, quantiles []float64) Result {
+	r := Result{
+		Backend:    b.Name,
+		Dataset:    data.Name,
+		Quantiles:  quantiles,
+		RankError:  make([]float64, len(quantiles)),
+		ValueError: make([]float64, len(quantiles)),
+	}
+
+	var before runtime.MemStats
+	if b.Bytes == nil {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+	}
+
+	est := b.New()
+	start := time.Now()
+	for _, v := range data.Values {
+		est.Add(v)
+	}
+	if len(data.Values) > 0 {
+		r.AddNanos = float64(time.Since(start).Nanoseconds()) / float64(len(data.Values))
+	}
+
+	for i, q := range quantiles {
+		v, want := est.Get(q), exact.Get(q)
+		r.RankError[i] = exact.RankError(q, v)
+		r.ValueError[i] = math.Abs(v - want)
+		if want != 0 {
+			r.ValueError[i] /= math.Abs(want)
+		}
+	}
+
+	if b.Bytes != nil {
+		r.Bytes = b.Bytes(est)
+	} else {
+		var after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		r.Bytes = int(after.HeapAlloc) - int(before.HeapAlloc)
+		runtime.KeepAlive(est)
+	}
+
+	start = time.Now()
+	for i := 0; i < getRounds; i++ {
+		for _, q := range quantiles {
+			est.Get(q)
+		}
+	}
+	if len(quantiles) > 0 {
+		r.GetNanos = float64(time.Since(start).Nanoseconds()) / float64(getRounds*len(quantiles))
+	}
+
+	return r
+}
+
+// Recommend runs every backend over values and returns the result of the one
+// using the least memory whose rank error stays within maxRankError at every
+// quantile of Grid, along with all results.  It returns false if no backend
+// is accurate enough.
+func Recommend(backends []Backend, values []float64, maxRankError float64) (Result, []Result, bool) {
+	results := Run(backends, []Dataset{{Name: "recorded", Values: values}}, Grid)
+
+	var best Result
+	found := false
+	for _, r := range results {
+		if r.MaxRankError() > maxRankError {
+			continue
+		}
+		if !found || r.Bytes < best.Bytes || r.Bytes == best.Bytes && r.AddNanos < best.AddNanos {
+			best, found = r, true
+		}
+	}
+	return best, results, found
+}
+
+func sortResults(results []Result) []Result {
+	sorted := append([]Result(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Dataset < sorted[j].Dataset })
+	return sorted
+}
+
+// WriteText writes results as an aligned table grouped by dataset.
+func WriteText(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "dataset\tbackend\tbytes\tns/add\tns/get\tmax rank err\tmax value err\t\n")
+	for _, r := range sortResults(results) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f\t%.1f\t%.5f\t%.4g\t\n",
+			r.Dataset, r.Backend, r.Bytes, r.AddNanos, r.GetNanos, r.MaxRankError(), r.MaxValueError())
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes results with one row per backend and dataset and a rank
+// and value error column per quantile.  All results must share the same
+// quantiles.
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	header := []string{"dataset", "backend", "bytes", "ns_per_add", "ns_per_get"}
+	if len(results) > 0 {
+		for _, q := range results[0].Quantiles {
+			header = append(header, "rank_error_"+format(q))
+		}
+		for _, q := range results[0].Quantiles {
+			header = append(header, "value_error_"+format(q))
+		}
+	}
+	cw.Write(header)
+
+	for _, r := range sortResults(results) {
+		row := []string{r.Dataset, r.Backend, strconv.Itoa(r.Bytes), format(r.AddNanos), format(r.GetNanos)}
+		for _, e := range r.RankError {
+			row = append(row, format(e))
+		}
+		for _, e := range r.ValueError {
+			row = append(row, format(e))
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func format(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
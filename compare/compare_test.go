@@ -0,0 +1,118 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package compare
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+func TestRun(t *testing.T) {
+	datasets := Datasets(20000, 1)
+	results := Run(Backends(), datasets, Grid)
+	if want := len(datasets) * len(Backends()); len(results) != want {
+		t.Fatalf("want %d results, got %d", want, len(results))
+	}
+
+	tolerance := map[string]float64{
+		"exact":            0,
+		"unknown 0.01":     0.01,
+		"unknown 0.001":    0.001,
+		"known grid 0.001": 0.001,
+		// P2 guarantees no error, this is what it observes on smooth data
+		"p2 grid": 0.01,
+	}
+	for _, r := range results {
+		bound := tolerance[r.Backend]
+		if r.Backend == "p2 grid" && (r.Dataset == "sorted normal" || r.Dataset == "sawtooth" || r.Dataset == "low cardinality 10") {
+			// ordered input and ties pull its markers off
+			bound = 0.1
+		}
+		if e := r.MaxRankError(); e > bound+quantiletest.RankSlack(20000) {
+			t.Errorf("%s on %s: rank error %f exceeds %f", r.Backend, r.Dataset, e, bound)
+		}
+		if r.Backend == "exact" && r.MaxValueError() != 0 {
+			t.Errorf("exact on %s: value error %f", r.Dataset, r.MaxValueError())
+		}
+		if r.Bytes <= 0 || r.AddNanos <= 0 || r.GetNanos <= 0 {
+			t.Errorf("%s on %s: want positive measurements, got %+v", r.Backend, r.Dataset, r)
+		}
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	values := quantiletest.LogNormal(0, 2).Generate(50000, 1)
+
+	best, results, ok := Recommend(Backends(), values, 0.01)
+	if !ok {
+		t.Fatal("want a recommendation")
+	}
+	if best.MaxRankError() > 0.01 {
+		t.Fatalf("recommended %s with rank error %f", best.Backend, best.MaxRankError())
+	}
+	for _, r := range results {
+		if r.MaxRankError() <= 0.01 && r.Bytes < best.Bytes {
+			t.Fatalf("recommended %s of %d bytes over %s of %d", best.Backend, best.Bytes, r.Backend, r.Bytes)
+		}
+	}
+	if best.Backend == "exact" {
+		t.Fatal("want an estimator smaller than keeping every value")
+	}
+
+	if _, _, ok := Recommend(Backends()[1:], values, 0); ok {
+		t.Fatal("want no estimator to be exact over 50000 values")
+	}
+}
+
+type last struct{ v float64 }
+
+func (l *last) Add(v float64)       { l.v = v }
+func (l *last) Get(float64) float64 { return l.v }
+
+func TestCustomBackend(t *testing.T) {
+	b := Backend{Name: "last", New: func() Estimator { return &last{} }}
+	results := Run([]Backend{b}, []Dataset{{Name: "ramp", Values: []float64{1, 2, 3, 4}}}, []float64{0.25, 1})
+	if len(results) != 1 {
+		t.Fatalf("want one result, got %d", len(results))
+	}
+	r := results[0]
+	if r.RankError[0] != 0.75 || r.RankError[1] != 0 {
+		t.Fatalf("want rank errors [0.75 0], got %v", r.RankError)
+	}
+	if r.ValueError[0] != 3 {
+		t.Fatalf("want a value error of 3 times the exact 1, got %f", r.ValueError[0])
+	}
+}
+
+func TestWrite(t *testing.T) {
+	results := Run(Backends()[:2], Datasets(1000, 1)[:2], []float64{0.5, 0.9})
+
+	var text bytes.Buffer
+	if err := WriteText(&text, results); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(text.String()), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[0], "max rank err") {
+		t.Fatalf("want a header and 4 rows, got:\n%s", text.String())
+	}
+
+	var out bytes.Buffer
+	if err := WriteCSV(&out, results); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"dataset", "backend", "bytes", "ns_per_add", "ns_per_get",
+		"rank_error_0.5", "rank_error_0.9", "value_error_0.5", "value_error_0.9"}
+	if len(records) != 5 || strings.Join(records[0], ",") != strings.Join(want, ",") {
+		t.Fatalf("want header %v and 4 rows, got %v", want, records)
+	}
+}
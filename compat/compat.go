@@ -0,0 +1,145 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+/*
+Package compat provides the Stream API of github.com/beorn7/perks/quantile
+backed by a quantile.Estimator, so existing callers can switch estimators by
+changing an import:
+
+	import quantile "github.com/streadway/quantile/compat"
+
+Behavior differs from perks in these ways:
+
+Query answers exactly from the buffer until the first flush, as perks does
+until its buffer fills or a Merge.  Here Samples flushes the stream as well.
+Later queries answer from the compressed summary within the requested
+tolerance.
+
+Samples returns the compressed summary of the Estimator.  It retains other
+values than perks would for the same stream, but Width and Delta mean the
+same.  The summary is compressed from the first value, where perks returns
+each value of a stream it has not flushed yet as a sample of width 1.
+
+Merge combines the samples with the stream's summary like Estimator.Merge,
+proportional to the number of samples.  The merged stream is accurate within
+the tolerance of the targets when the samples come from a stream with the same
+targets observing alike values, as shards of one stream do.  perks documents
+its own Merge as not yielding correct results.
+
+Query on an empty stream returns 0, as in perks.
+*/
+package compat
+
+import (
+	"sort"
+
+	"github.com/streadway/quantile"
+)
+
+// Sample holds an observed value and meta information for compression.
+type Sample struct {
+	Value float64 `json:",string"`
+	Width float64 `json:",string"`
+	Delta float64 `json:",string"`
+}
+
+// Samples represents a slice of samples. It implements sort.Interface.
+type Samples []Sample
+
+func (a Samples) Len() int           { return len(a) }
+func (a Samples) Less(i, j int) bool { return a[i].Value < a[j].Value }
+func (a Samples) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// Stream computes quantiles for a stream of float64s.  It is not safe for
+// concurrent use by multiple goroutines.
+type Stream struct {
+	est *quantile.Estimator
+}
+
+func newStream(estimates ...quantile.Estimate) *Stream {
+	return &Stream{est: quantile.New(estimates...)}
+}
+
+// NewLowBiased returns a Stream whose error is relative to the rank, making
+// low quantiles the most accurate.
+func NewLowBiased(epsilon float64) *Stream {
+	return newStream(quantile.LowBiased(epsilon))
+}
+
+// NewHighBiased returns a Stream whose error is relative to the distance of
+// the rank from the maximum, making high quantiles the most accurate.
+func NewHighBiased(epsilon float64) *Stream {
+	return newStream(quantile.HighBiased(epsilon))
+}
+
+// NewTargeted returns a Stream accurate for the quantiles in targets, which
+// maps each quantile to its absolute error tolerance.
+//
+// The targets 0 and 1 answer the exact minimum and maximum and constrain no
+// other quantile, as quantile.Known does.  An empty map tolerates the error
+// of quantile.New without estimates, relative to the rank like
+// NewLowBiased(0.1), where perks compresses every sample away.
+func NewTargeted(targets map[float64]float64) *Stream {
+	estimates := make([]quantile.Estimate, 0, len(targets))
+	for q, e := range targets {
+		estimates = append(estimates, quantile.Known(q, e))
+	}
+	return newStream(estimates...)
+}
+
+// Insert inserts v into the stream.
+func (s *Stream) Insert(v float64) {
+	s.est.Add(v)
+}
+
+// Query returns the computed qth percentile value, or 0 if the stream is
+// empty.
+func (s *Stream) Query(q float64) float64 {
+	v, ok := s.est.GetOK(q)
+	if !ok {
+		return 0
+	}
+	return v
+}
+
+// Merge adds the observations summarized by samples, such as the Samples of
+// another stream with the same targets, to the stream.  Samples that do not
+// form a summary, such as ones with uncertain extremes, are inserted value by
+// value, each weighing its width.
+func (s *Stream) Merge(samples Samples) {
+	sorted := make([]quantile.Sample, len(samples))
+	for i, sample := range samples {
+		sorted[i] = quantile.Sample(sample)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+
+	if s.est.MergeSamples(sorted) == nil {
+		return
+	}
+	for _, sample := range sorted {
+		// like perks, samples without a positive width add nothing
+		s.est.AddWeighted(sample.Value, sample.Width)
+	}
+}
+
+// Reset discards all observed values while keeping the configured targets.
+func (s *Stream) Reset() {
+	s.est.Reset()
+}
+
+// Samples returns a copy of the compressed samples in ascending order of
+// value, flushing the stream.  Their widths sum to Count.
+func (s *Stream) Samples() Samples {
+	spine := s.est.Samples()
+	samples := make(Samples, len(spine))
+	for i, sample := range spine {
+		samples[i] = Sample(sample)
+	}
+	return samples
+}
+
+// Count returns the total number of samples observed in the stream.
+func (s *Stream) Count() int {
+	return int(s.est.Count())
+}
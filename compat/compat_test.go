@@ -0,0 +1,303 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package compat
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// The expectations below are re-derived from the perks/quantile test suite.
+
+var (
+	targets = map[float64]float64{
+		0.01: 0.001,
+		0.10: 0.01,
+		0.50: 0.05,
+		0.90: 0.01,
+		0.99: 0.001,
+	}
+	targetsSmallEpsilon = map[float64]float64{
+		0.01: 0.0001,
+		0.10: 0.001,
+		0.50: 0.005,
+		0.90: 0.001,
+		0.99: 0.0001,
+	}
+	lowQuantiles    = []float64{0.01, 0.1, 0.5}
+	highQuantiles   = []float64{0.99, 0.9, 0.5}
+	relativeEpsilon = 0.01
+)
+
+func verifyAbsolute(t *testing.T, a []float64, s *Stream) {
+	sort.Float64s(a)
+	n := float64(len(a))
+	for q, e := range targets {
+		lower := int((q - e) * n)
+		if lower < 1 {
+			lower = 1
+		}
+		upper := int(math.Ceil((q + e) * n))
+		if upper > len(a) {
+			upper = len(a)
+		}
+		min, max := a[lower-1], a[upper-1]
+		if got := s.Query(q); got < min || got > max {
+			t.Errorf("q=%f: want [%f,%f], got %f", q, min, max, got)
+		}
+	}
+}
+
+func verifyLowRelative(t *testing.T, a []float64, s *Stream) {
+	sort.Float64s(a)
+	n := float64(len(a))
+	for _, q := range lowQuantiles {
+		lower := int((1 - relativeEpsilon) * q * n)
+		upper := int(math.Ceil((1 + relativeEpsilon) * q * n))
+		min, max := a[lower-1], a[upper-1]
+		if got := s.Query(q); got < min || got > max {
+			t.Errorf("q=%f: want [%f,%f], got %f", q, min, max, got)
+		}
+	}
+}
+
+func verifyHighRelative(t *testing.T, a []float64, s *Stream) {
+	sort.Float64s(a)
+	n := float64(len(a))
+	for _, q := range highQuantiles {
+		lower := int((1 - (1+relativeEpsilon)*(1-q)) * n)
+		upper := int(math.Ceil((1 - (1-relativeEpsilon)*(1-q)) * n))
+		min, max := a[lower-1], a[upper-1]
+		if got := s.Query(q); got < min || got > max {
+			t.Errorf("q=%f: want [%f,%f], got %f", q, min, max, got)
+		}
+	}
+}
+
+func populateStream(s *Stream, r *rand.Rand) []float64 {
+	a := make([]float64, 0, 1e5+100)
+	for i := 0; i < cap(a); i++ {
+		v := r.NormFloat64()
+		// Add 5% asymmetric outliers.
+		if i%20 == 0 {
+			v = v*v + 1
+		}
+		s.Insert(v)
+		a = append(a, v)
+	}
+	return a
+}
+
+func TestTargetedQuery(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s := NewTargeted(targets)
+	a := populateStream(s, r)
+	verifyAbsolute(t, a, s)
+}
+
+func TestTargetedQuerySmallSampleSize(t *testing.T) {
+	s := NewTargeted(targetsSmallEpsilon)
+	a := []float64{1, 2, 3, 4, 5}
+	for _, v := range a {
+		s.Insert(v)
+	}
+	verifyAbsolute(t, a, s)
+}
+
+func TestLowBiasedQuery(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s := NewLowBiased(relativeEpsilon)
+	a := populateStream(s, r)
+	verifyLowRelative(t, a, s)
+}
+
+func TestHighBiasedQuery(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s := NewHighBiased(relativeEpsilon)
+	a := populateStream(s, r)
+	verifyHighRelative(t, a, s)
+}
+
+func TestTargetedMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s1 := NewTargeted(targets)
+	s2 := NewTargeted(targets)
+	a := populateStream(s1, r)
+	a = append(a, populateStream(s2, r)...)
+	s1.Merge(s2.Samples())
+
+	if got, want := s1.Count(), len(a); got != want {
+		t.Fatalf("want count %d, got %d", want, got)
+	}
+	verifyAbsolute(t, a, s1)
+}
+
+func TestLowBiasedMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s1 := NewLowBiased(relativeEpsilon)
+	s2 := NewLowBiased(relativeEpsilon)
+	a := populateStream(s1, r)
+	a = append(a, populateStream(s2, r)...)
+	s1.Merge(s2.Samples())
+	verifyLowRelative(t, a, s1)
+}
+
+func TestHighBiasedMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s1 := NewHighBiased(relativeEpsilon)
+	s2 := NewHighBiased(relativeEpsilon)
+	a := populateStream(s1, r)
+	a = append(a, populateStream(s2, r)...)
+	s1.Merge(s2.Samples())
+	verifyHighRelative(t, a, s1)
+}
+
+func TestUncompressed(t *testing.T) {
+	q := NewTargeted(targets)
+	for i := 100; i > 0; i-- {
+		q.Insert(float64(i))
+	}
+	if got := q.Count(); got != 100 {
+		t.Errorf("want count 100, got %d", got)
+	}
+	// answered exactly before the first flush, as perks does
+	for k := range targets {
+		if got, want := q.Query(k), math.Round(k*100); got != want {
+			t.Errorf("q=%f: want %f, got %f", k, want, got)
+		}
+	}
+}
+
+func TestUncompressedOne(t *testing.T) {
+	q := NewTargeted(map[float64]float64{0.99: 0.01})
+	q.Insert(3.14)
+	if got := q.Query(0.90); got != 3.14 {
+		t.Errorf("want 3.14, got %v", got)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	if got := NewTargeted(map[float64]float64{0.99: 0.001}).Query(0.99); got != 0 {
+		t.Errorf("want 0, got %f", got)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := NewTargeted(targets)
+	for i := 0; i < 1000; i++ {
+		s.Insert(float64(i))
+	}
+	s.Reset()
+	if got := s.Count(); got != 0 {
+		t.Errorf("want count 0 after reset, got %d", got)
+	}
+	if got := s.Query(0.5); got != 0 {
+		t.Errorf("want 0 after reset, got %f", got)
+	}
+	s.Insert(42)
+	if got := s.Query(0.5); got != 42 {
+		t.Errorf("want 42, got %f", got)
+	}
+}
+
+func TestUncompressedSamples(t *testing.T) {
+	q := NewTargeted(map[float64]float64{0.99: 0.001})
+	for i := 1; i <= 100; i++ {
+		q.Insert(float64(i))
+	}
+	// perks returns the 100 unflushed values, the summary compresses them
+	// within the tolerance of the target
+	samples := q.Samples()
+	if got := samples.Len(); got < 1 || got > 100 {
+		t.Errorf("want at most 100 samples, got %d", got)
+	}
+	width := 0.0
+	for _, sample := range samples {
+		width += sample.Width
+	}
+	if width != 100 || samples[0].Value != 1 || samples[len(samples)-1].Value != 100 {
+		t.Errorf("want the 100 values from 1 to 100, got width %f from %f to %f", width, samples[0].Value, samples[len(samples)-1].Value)
+	}
+}
+
+func TestSamplesWidth(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s := NewTargeted(targets)
+	populateStream(s, r)
+
+	samples := s.Samples()
+	if !sort.IsSorted(samples) {
+		t.Fatal("want samples in ascending order")
+	}
+	width := 0.0
+	for _, sample := range samples {
+		width += sample.Width
+	}
+	if got, want := width, float64(s.Count()); got != want {
+		t.Fatalf("want widths summing to %f, got %f", want, got)
+	}
+}
+
+func TestMergeUnsorted(t *testing.T) {
+	s := NewTargeted(targets)
+	s.Merge(Samples{{Value: 3, Width: 1}, {Value: 1, Width: 2}, {Value: 2, Width: 1}})
+	if got := s.Count(); got != 4 {
+		t.Fatalf("want count 4, got %d", got)
+	}
+	if got := s.Query(0.5); got != 1 {
+		t.Fatalf("want the median 1, got %f", got)
+	}
+
+	// uncertain extremes are no summary, so the values are inserted
+	s.Merge(Samples{{Value: 0, Width: 2, Delta: 1}, {Value: 4, Width: 1, Delta: 1}})
+	if got := s.Count(); got != 7 {
+		t.Fatalf("want count 7, got %d", got)
+	}
+	if got := s.Query(0); got != 0 {
+		t.Fatalf("want the minimum 0, got %f", got)
+	}
+}
+
+func TestTargetedEmpty(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s := NewTargeted(map[float64]float64{})
+	a := populateStream(s, r)
+
+	// the default of NewLowBiased(0.1)
+	sort.Float64s(a)
+	n := float64(len(a))
+	for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99} {
+		lower := int((1 - 0.1) * q * n)
+		upper := int(math.Ceil((1 + 0.1) * q * n))
+		if upper > len(a) {
+			upper = len(a)
+		}
+		min, max := a[lower-1], a[upper-1]
+		if got := s.Query(q); got < min || got > max {
+			t.Errorf("q=%f: want [%f,%f], got %f", q, min, max, got)
+		}
+	}
+}
+
+func TestTargetedExtremes(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	s := NewTargeted(map[float64]float64{0: 0.01, 0.5: 0.05, 1: 0.01})
+	a := populateStream(s, r)
+
+	sort.Float64s(a)
+	if got, want := s.Query(0), a[0]; got != want {
+		t.Errorf("want the minimum %f, got %f", want, got)
+	}
+	if got, want := s.Query(1), a[len(a)-1]; got != want {
+		t.Errorf("want the maximum %f, got %f", want, got)
+	}
+	n := float64(len(a))
+	min, max := a[int((0.5-0.05)*n)-1], a[int(math.Ceil((0.5+0.05)*n))-1]
+	if got := s.Query(0.5); got < min || got > max {
+		t.Errorf("q=0.5: want [%f,%f], got %f", min, max, got)
+	}
+}
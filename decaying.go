@@ -0,0 +1,153 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"time"
+)
+
+// decaySteps is how often per half-life a Decaying scales its observations.
+const decaySteps = 8
+
+// Decaying estimates quantiles of the values added so far, each weighing
+// half as much every half-life, so the estimates follow recent values
+// without forgetting older ones abruptly like Windowed.  It is not safe for
+// concurrent use.
+//
+// The weights are scaled eight times per half-life, so values added within
+// an eighth of a half-life weigh the same.  Count, Sum and Mean report the
+// decayed weights.  The minimum and maximum are retained until the items
+// holding them weigh less than one observation, then the next smallest or
+// largest retained value takes their place.
+type Decaying struct {
+	est  *Estimator
+	step time.Duration
+	last time.Time
+
+	now func() time.Time
+}
+
+// NewDecaying allocates an estimator whose observations lose half their
+// weight every halfLife, tolerating the minimum of the invariants like New.
+func NewDecaying(halfLife time.Duration, invariants ...Estimate) *Decaying {
+	d := &Decaying{
+		est:  New(invariants...),
+		step: halfLife / decaySteps,
+		now:  time.Now,
+	}
+	if d.step <= 0 {
+		d.step = 1
+	}
+	d.last = d.now()
+	return d
+}
+
+// age scales the weights for the steps elapsed since the last scaling.
+func (d *Decaying) age() {
+	elapsed := d.now().Sub(d.last)
+	if elapsed < d.step {
+		return
+	}
+	steps := elapsed / d.step
+	d.last = d.last.Add(steps * d.step)
+	d.est.decay(math.Exp2(-float64(steps) / decaySteps))
+}
+
+// Add adds value with the weight of one observation.
+func (d *Decaying) Add(value float64) {
+	d.age()
+	d.est.Add(value)
+}
+
+// Get estimates quantile of the decayed observations, or returns NaN if they
+// weigh less than one observation.
+func (d *Decaying) Get(quantile float64) float64 {
+	d.age()
+	return d.est.Get(quantile)
+}
+
+// Snapshot copies the decayed summary as it is now.
+func (d *Decaying) Snapshot() *Snapshot {
+	d.age()
+	return d.est.Snapshot()
+}
+
+// Count returns the decayed weight of the observations, rounded down.
+func (d *Decaying) Count() int64 {
+	d.age()
+	return d.est.Count()
+}
+
+// Reset discards all observations.
+func (d *Decaying) Reset() {
+	d.est.Reset()
+	d.last = d.now()
+}
+
+// decay scales the weight of every observation by factor, below 1.  Items
+// left weighing less than one observation merge into a neighbor, which keeps
+// the bounds of the summary: an item merges into its successor, the minimum
+// passing its delta of 0 on, and the maximum into its predecessor, whose
+// delta is below the maximum's width.  A summary weighing less than one
+// observation in total is discarded.
+func (est *Estimator) decay(factor float64) {
+	est.flush()
+	est.sum *= factor
+	items := est.items
+	if len(items) == 0 {
+		return
+	}
+
+	for i := range items {
+		items[i].rank *= factor
+		items[i].delta *= factor
+		items[i].copies *= factor
+	}
+
+	// items[:kept] are kept, compacted to the front
+	kept := 0
+	for i := 0; i < len(items)-1; i++ {
+		cur, next := &items[i], &items[i+1]
+		switch {
+		case cur.rank >= 1:
+			items[kept] = *cur
+			kept++
+		case kept == 0:
+			next.rank += cur.rank
+			next.delta = 0
+		default:
+			next.rank += cur.rank
+		}
+	}
+
+	tail := items[len(items)-1]
+	if tail.rank < 1 && kept > 0 {
+		items[kept-1].rank += tail.rank
+		items[kept-1].delta = 0
+	} else {
+		items[kept] = tail
+		kept++
+	}
+	items = items[:kept]
+
+	est.observations = 0
+	for i := range items {
+		est.observations += items[i].rank
+	}
+	if est.observations < 1 {
+		est.items = items[:0]
+		est.observations = 0
+		return
+	}
+	est.items = items
+	est.min, est.max = items[0].v, items[len(items)-1].v
+
+	if debug {
+		if err := est.DebugValidate(); err != nil {
+			panic(err)
+		}
+	}
+}
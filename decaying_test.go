@@ -0,0 +1,165 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+// newFakeDecaying returns a Decaying whose clock the tests advance instead of
+// waiting.
+func newFakeDecaying(halfLife time.Duration, invariants ...Estimate) (*Decaying, *time.Time) {
+	clock := time.Unix(1e9, 0)
+	d := NewDecaying(halfLife, invariants...)
+	d.now = func() time.Time { return clock }
+	d.last = clock
+	return d, &clock
+}
+
+// weighted is a value and its decayed weight.
+type weighted struct {
+	v, w float64
+}
+
+// weightedQuantile returns the smallest value whose cumulative weight is at
+// least quantile of the total.
+func weightedQuantile(values []weighted, quantile float64) float64 {
+	sorted := append([]weighted(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].v < sorted[j].v })
+	total := 0.0
+	for _, x := range sorted {
+		total += x.w
+	}
+	sum := 0.0
+	for _, x := range sorted {
+		sum += x.w
+		if sum >= quantile*total {
+			return x.v
+		}
+	}
+	return sorted[len(sorted)-1].v
+}
+
+func TestDecayingFollowsShift(t *testing.T) {
+	const halfLife = time.Minute
+	d, clock := newFakeDecaying(halfLife, Known(0.1, 0.01), Known(0.5, 0.01), Known(0.9, 0.01))
+
+	// ten half-lives around 0, then two around 10, a batch every eighth
+	var values []weighted
+	for step := 0; step < 12*decaySteps; step++ {
+		mean := 0.0
+		if step >= 10*decaySteps {
+			mean = 10
+		}
+		for _, v := range quantiletest.Normal(mean, 1).Generate(200, int64(step)) {
+			d.Add(v)
+			values = append(values, weighted{v: v})
+		}
+		*clock = clock.Add(halfLife / decaySteps)
+	}
+	for i := range values {
+		batch := i / 200
+		values[i].w = math.Exp2(-float64(12*decaySteps-batch) / decaySteps)
+	}
+
+	// a quarter of the weight is left from before the shift
+	for _, q := range []float64{0.1, 0.5, 0.9} {
+		want := weightedQuantile(values, q)
+		if got := d.Get(q); math.Abs(got-want) > 0.2 {
+			t.Errorf("q=%f: want about %f, got %f", q, want, got)
+		}
+	}
+	if got := d.Get(0.5); got < 9 {
+		t.Errorf("want the median to follow the shift to 10, got %f", got)
+	}
+	if got := d.Get(0.1); got > 2 {
+		t.Errorf("want the 10th percentile before the shift, got %f", got)
+	}
+
+	// the weight of a steady stream decayed once more approaches
+	// 200·r/(1-r) with r = 2^(-1/8)
+	r := math.Exp2(-1.0 / decaySteps)
+	want := 200 * r / (1 - r)
+	if got := float64(d.Count()); math.Abs(got-want) > 0.01*want {
+		t.Errorf("want a weight of about %f, got %f", want, got)
+	}
+	if err := d.est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecayingForgets(t *testing.T) {
+	d, clock := newFakeDecaying(time.Second, Unknown(0.01))
+	for i := 0; i < 10000; i++ {
+		d.Add(float64(i))
+	}
+	if got := d.Get(0); got != 0 {
+		t.Fatalf("want the minimum 0, got %f", got)
+	}
+
+	// the items holding the extremes weigh less than an observation after
+	// ten half-lives, and the extremes move inwards
+	*clock = clock.Add(10 * time.Second)
+	if got, want := d.Count(), int64(10000/1024); got != want {
+		t.Fatalf("want a weight of %d after ten half-lives, got %d", want, got)
+	}
+	if min, max := d.Get(0), d.Get(1); min <= 0 || max >= 9999 {
+		t.Fatalf("want the extremes to move inwards, got %f and %f", min, max)
+	}
+	if err := d.est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// new values outweigh the old
+	d.Add(-1)
+	if got := d.Get(0); got != -1 {
+		t.Fatalf("want the new minimum -1, got %f", got)
+	}
+
+	// after a long idle time nothing weighs an observation
+	*clock = clock.Add(time.Minute)
+	if got := d.Count(); got != 0 {
+		t.Fatalf("want no weight after an idle minute, got %d", got)
+	}
+	if got := d.Get(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN after an idle minute, got %f", got)
+	}
+	d.Add(7)
+	if got := d.Get(0.5); got != 7 {
+		t.Fatalf("want 7, got %f", got)
+	}
+
+	d.Reset()
+	if got := d.Count(); got != 0 {
+		t.Fatalf("want no weight after Reset, got %d", got)
+	}
+}
+
+func TestDecayValidates(t *testing.T) {
+	for _, invariants := range [][]Estimate{{Unknown(0.01)}, {Known(0.5, 0.05), Known(0.99, 0.001)}} {
+		est := New(invariants...)
+		stream := quantiletest.LowCardinality(50).Generate(5000, 1)
+		for i, v := range stream {
+			est.Add(v)
+			if i%700 == 0 {
+				est.decay(0.7)
+			}
+		}
+		for f := 0.9; est.Count() > 0; f *= 0.9 {
+			est.decay(f)
+			if err := est.DebugValidate(); err != nil {
+				t.Fatalf("%v: decaying by %f: %v", invariants, f, err)
+			}
+			if est.Count() > 0 && (est.Min() != est.Get(0) || est.Max() != est.Get(1)) {
+				t.Fatalf("%v: want extremes %f and %f from Get, got %f and %f", invariants, est.Get(0), est.Get(1), est.Min(), est.Max())
+			}
+		}
+	}
+}
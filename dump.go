@@ -0,0 +1,102 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// WriteTo flushes the buffer, then writes the state of the summary to w in
+// a stable, line-oriented format meant for diffing dumps: the observations,
+// one line per invariant, and one line per item in order with its value,
+// width, delta and the cumulative width, its lower rank bound.
+//
+//	observations 12
+//	target Unknown(0.1)
+//	value width delta rank
+//	1 1 0 1
+//	4 3 0 4
+//
+// It implements io.WriterTo.
+func (est *Estimator) WriteTo(w io.Writer) (int64, error) {
+	est.flush()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "observations %g\n", est.observations)
+	for _, f := range est.estimates() {
+		fmt.Fprintf(&buf, "target %s\n", describe(f))
+	}
+	fmt.Fprintf(&buf, "value width delta rank\n")
+	rank := 0.0
+	for _, cur := range est.items {
+		rank += cur.rank
+		fmt.Fprintf(&buf, "%g %g %g %g\n", cur.v, cur.rank, cur.delta, rank)
+	}
+	return buf.WriteTo(w)
+}
+
+// describe formats f as the call constructing it.
+func describe(f Estimate) string {
+	switch f := f.(type) {
+	case bias:
+		return fmt.Sprintf("Unknown(%g)", f.tolerance)
+	case highBias:
+		return fmt.Sprintf("HighBiased(%g)", f.tolerance)
+	case target:
+		return fmt.Sprintf("Known(%g, %g)", f.q, f.tolerance)
+	}
+	return fmt.Sprintf("%T", f)
+}
+
+// DumpDebug flushes the buffer, then writes a table of the summary to w: per
+// item its value, width, delta, copies of the value, lower rank bound and ƒ
+// there, and the ranks a merge into its successor would span, the invariant
+// over them and whether compression would merge.  Flushing compresses, so
+// only summaries changed since, as by Merge, show merges.  The minimum and
+// maximum are never merged and show "-".
+func (est *Estimator) DumpDebug(w io.Writer) error {
+	est.flush()
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "n=%g items=%d\n", est.observations, len(est.items))
+	fmt.Fprintf(tw, "item\tvalue\twidth\tdelta\tcopies\trank\tƒ\tspan\tallowed\tmerge\t\n")
+
+	rank := 0.0
+	for i := range est.items {
+		cur := &est.items[i]
+		span, allowed, merge := "-", "-", "-"
+		if i > 0 && i+1 < len(est.items) {
+			next := &est.items[i+1]
+			width := cur.rank + next.rank + next.delta
+			f := est.invariantOver(rank, width, est.observations)
+			span, allowed, merge = fmt.Sprint(width), fmt.Sprint(f), fmt.Sprint(width <= f)
+		}
+		rank += cur.rank
+		fmt.Fprintf(tw, "%d\t%g\t%g\t%g\t%g\t%g\t%g\t%s\t%s\t%s\t\n",
+			i, cur.v, cur.rank, cur.delta, cur.copies, rank, est.invariant(rank, est.observations), span, allowed, merge)
+	}
+	return tw.Flush()
+}
+
+// DumpDot flushes the buffer, then writes the items, in order, to w as a
+// Graphviz digraph.
+func (est *Estimator) DumpDot(w io.Writer) error {
+	est.flush()
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph quantile {\n\trankdir=LR;\n\tnode [shape=record];\n")
+	for i, cur := range est.items {
+		fmt.Fprintf(bw, "\tn%d [label=\"{v=%g|g=%g|Δ=%g}\"];\n", i, cur.v, cur.rank, cur.delta)
+		if i+1 < len(est.items) {
+			fmt.Fprintf(bw, "\tn%d -> n%d;\n", i, i+1)
+		}
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
@@ -0,0 +1,116 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"bytes"
+	"testing"
+)
+
+// dumpStream is a small summary, left uncompressed by updating it directly
+func dumpStream() *Estimator {
+	est := New(Unknown(0.1))
+	est.update([]float64{1, 2, 3, 4, 4, 4, 5, 6, 7, 8, 9, 10}, nil)
+	return est
+}
+
+const dumpDebugGolden = `n=12 items=10
+ item value width delta copies rank ƒ span allowed merge
+    0     1     1     0      1    1 0    -       -     -
+    1     2     1     0      1    2 0    2       0 false
+    2     3     1     0      1    3 0    4       0 false
+    3     4     3     0      3    6 1    4       0 false
+    4     5     1     0      1    7 1    2       1 false
+    5     6     1     0      1    8 1    2       1 false
+    6     7     1     0      1    9 1    2       1 false
+    7     8     1     0      1   10 2    2       1 false
+    8     9     1     0      1   11 2    2       2  true
+    9    10     1     0      1   12 2    -       -     -
+`
+
+func TestDumpDebug(t *testing.T) {
+	est := dumpStream()
+	var buf bytes.Buffer
+	if err := est.DumpDebug(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != dumpDebugGolden {
+		t.Fatalf("want:\n%s\ngot:\n%s", dumpDebugGolden, got)
+	}
+
+	// the dump only flushes, which finds nothing to do
+	if err := est.DebugValidate(); err != nil || len(est.items) != 10 {
+		t.Fatalf("dump changed the summary to %d items: %v", len(est.items), err)
+	}
+}
+
+const writeToGolden = `observations 12
+target Unknown(0.1)
+value width delta rank
+1 1 0 1
+2 1 0 2
+3 1 0 3
+4 3 0 6
+5 1 0 7
+6 1 0 8
+7 1 0 9
+8 1 0 10
+9 1 0 11
+10 1 0 12
+`
+
+func TestWriteTo(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := dumpStream().WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != writeToGolden || n != int64(len(got)) {
+		t.Fatalf("want %d bytes:\n%s\ngot %d:\n%s", len(writeToGolden), writeToGolden, n, got)
+	}
+
+	buf.Reset()
+	est := New(HighBiased(0.01), Known(0.5, 0.01))
+	est.Add(1)
+	est.WriteTo(&buf)
+	if want := "observations 1\ntarget HighBiased(0.01)\ntarget Known(0.5, 0.01)\nvalue width delta rank\n1 1 0 1\n"; buf.String() != want {
+		t.Fatalf("want the buffer flushed and every target:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
+
+const dumpDotGolden = `digraph quantile {
+	rankdir=LR;
+	node [shape=record];
+	n0 [label="{v=1|g=1|Δ=0}"];
+	n0 -> n1;
+	n1 [label="{v=2|g=1|Δ=0}"];
+	n1 -> n2;
+	n2 [label="{v=3|g=1|Δ=0}"];
+	n2 -> n3;
+	n3 [label="{v=4|g=3|Δ=0}"];
+	n3 -> n4;
+	n4 [label="{v=5|g=1|Δ=0}"];
+	n4 -> n5;
+	n5 [label="{v=6|g=1|Δ=0}"];
+	n5 -> n6;
+	n6 [label="{v=7|g=1|Δ=0}"];
+	n6 -> n7;
+	n7 [label="{v=8|g=1|Δ=0}"];
+	n7 -> n8;
+	n8 [label="{v=9|g=1|Δ=0}"];
+	n8 -> n9;
+	n9 [label="{v=10|g=1|Δ=0}"];
+}
+`
+
+func TestDumpDot(t *testing.T) {
+	var buf bytes.Buffer
+	if err := dumpStream().DumpDot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != dumpDotGolden {
+		t.Fatalf("want:\n%s\ngot:\n%s", dumpDotGolden, got)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import "time"
+
+// DurationEstimator estimates quantiles of durations, such as the latency of
+// requests.  Durations are kept in nanoseconds, the unit of time.Duration,
+// so they are exact up to 104 days.  It is an EstimatorOf[time.Duration]
+// with methods to observe the time since a start, typically deferred:
+//
+//	defer est.ObserveSince(time.Now())
+type DurationEstimator struct {
+	*EstimatorOf[time.Duration]
+}
+
+// NewDuration allocates a DurationEstimator tolerating the minimum of the
+// invariants like New.
+func NewDuration(invariants ...Estimate) *DurationEstimator {
+	return &DurationEstimator{NewOf[time.Duration](invariants...)}
+}
+
+// NewDurationWithOptions allocates a DurationEstimator like NewWithOptions.
+func NewDurationWithOptions(options []Option, invariants ...Estimate) *DurationEstimator {
+	return &DurationEstimator{NewOfWithOptions[time.Duration](options, invariants...)}
+}
+
+// Observe adds d.
+func (est *DurationEstimator) Observe(d time.Duration) {
+	est.Add(d)
+}
+
+// ObserveSince adds the time elapsed since start.
+func (est *DurationEstimator) ObserveSince(start time.Time) {
+	est.Add(time.Since(start))
+}
@@ -0,0 +1,34 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationEstimator(t *testing.T) {
+	est := NewDuration(Known(0.5, 0.01))
+	for i := 1; i <= 99; i++ {
+		est.Observe(time.Duration(i) * time.Millisecond)
+	}
+	if got := est.Get(0.5); got != 50*time.Millisecond {
+		t.Fatalf("want a median of 50ms, got %v", got)
+	}
+
+	if est.Count() != 99 || est.Inexact() != 0 {
+		t.Fatalf("want 99 exact durations, got %d of which %d inexact", est.Count(), est.Inexact())
+	}
+}
+
+func TestDurationEstimatorObserveSince(t *testing.T) {
+	est := NewDuration()
+	start := time.Now()
+	time.Sleep(time.Millisecond)
+	est.ObserveSince(start)
+	if got, since := est.Get(0.5), time.Since(start); got < time.Millisecond || got > since {
+		t.Fatalf("want the time since start within [1ms, %v], got %v", since, got)
+	}
+}
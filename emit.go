@@ -0,0 +1,274 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Summary is a point-in-time view of an Estimator.
+type Summary struct {
+	// Count is the number of values observed.
+	Count int
+
+	// Quantiles maps each requested quantile, or without any the quantile
+	// of each Known target, to its estimate, or to NaN when Count is 0.
+	Quantiles map[float64]float64
+}
+
+func summarize(est *Estimator, quantiles []float64) Summary {
+	if len(quantiles) == 0 {
+		return Summary{Count: int(est.Count()), Quantiles: est.Estimates()}
+	}
+	s := Summary{
+		Count:     int(est.Count()),
+		Quantiles: make(map[float64]float64, len(quantiles)),
+	}
+	if s.Count == 0 {
+		for _, q := range quantiles {
+			s.Quantiles[q] = math.NaN()
+		}
+		return s
+	}
+	for i, v := range est.GetAll(quantiles...) {
+		s.Quantiles[quantiles[i]] = v
+	}
+	return s
+}
+
+// Backpressure decides what happens to ticks that arrive while an emit
+// callback is still running.
+type Backpressure int
+
+const (
+	// SkipTick drops every tick that arrives while the callback runs.  The
+	// next callback runs on the first tick after the slow one returns.
+	SkipTick Backpressure = iota
+
+	// RunLate runs the callback once more as soon as the slow one returns if
+	// any ticks arrived in the meantime.  Additional missed ticks are dropped.
+	RunLate
+)
+
+// emitter holds what Emitter and Group share: the run loop policy and its
+// counters.
+type emitter struct {
+	// Rotate resets the estimator after every snapshot, so each Summary
+	// covers only the values added since the previous one.  It must be set
+	// before Emit is called.
+	Rotate bool
+
+	// Backpressure selects the policy for ticks missed by a slow callback.
+	// It must be set before Emit is called.
+	Backpressure Backpressure
+
+	panics  int64
+	skipped int64
+
+	// replaced by tests with a fake clock
+	now       func() time.Time
+	newTicker func(time.Duration) (<-chan time.Time, func())
+	idle      func()
+}
+
+func newEmitter() emitter {
+	return emitter{
+		now:       time.Now,
+		newTicker: newTicker,
+	}
+}
+
+func newTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// Panics returns the number of callback panics that were recovered.
+func (e *emitter) Panics() int64 {
+	return atomic.LoadInt64(&e.panics)
+}
+
+// Skipped returns the number of ticks dropped because a callback was still
+// running.
+func (e *emitter) Skipped() int64 {
+	return atomic.LoadInt64(&e.skipped)
+}
+
+func (e *emitter) call(fn func()) {
+	defer func() {
+		if recover() != nil {
+			atomic.AddInt64(&e.panics, 1)
+		}
+	}()
+	fn()
+}
+
+// run calls tick on every tick of interval until ctx is done.
+//
+// Tickers coalesce ticks for slow receivers, so missed ticks are derived from
+// how many intervals passed since the tick that started the callback rather
+// than from what is pending on the channel.  A pending tick from before the
+// callback returned is one of the missed ones, a later one is on time.
+func (e *emitter) run(ctx context.Context, interval time.Duration, tick func()) error {
+	ticks, stop := e.newTicker(interval)
+	defer stop()
+
+	var pending *time.Time
+	for {
+		var start time.Time
+		if pending != nil {
+			start, pending = *pending, nil
+		} else {
+			if e.idle != nil {
+				e.idle()
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case start = <-ticks:
+			}
+		}
+
+		for {
+			e.call(tick)
+			end := e.now()
+			missed := int64(end.Sub(start) / interval)
+
+			select {
+			case t := <-ticks:
+				if t.After(end) {
+					pending = &t
+				}
+			default:
+			}
+
+			if missed > 0 && e.Backpressure == RunLate && ctx.Err() == nil {
+				atomic.AddInt64(&e.skipped, missed-1)
+				start = start.Add(time.Duration(missed) * interval)
+				continue
+			}
+			atomic.AddInt64(&e.skipped, missed)
+			break
+		}
+	}
+}
+
+// Emitter guards an Estimator for concurrent Adds while periodically handing
+// snapshots of it to a callback.
+type Emitter struct {
+	emitter
+
+	mu        sync.Mutex
+	est       *Estimator
+	quantiles []float64
+}
+
+// NewEmitter wraps est, reporting the given quantiles in every Summary, or
+// without any those of its Known targets.  The estimator must not be used
+// directly afterwards.
+func NewEmitter(est *Estimator, quantiles ...float64) *Emitter {
+	return &Emitter{
+		emitter:   newEmitter(),
+		est:       est,
+		quantiles: quantiles,
+	}
+}
+
+// Add adds value to the wrapped estimator.  It is safe to call concurrently
+// with Emit.
+func (e *Emitter) Add(value float64) {
+	e.mu.Lock()
+	e.est.Add(value)
+	e.mu.Unlock()
+}
+
+func (e *Emitter) snapshot() Summary {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s := summarize(e.est, e.quantiles)
+	if e.Rotate {
+		// Reset keeps the options, such as hooks, of the wrapped estimator
+		e.est.Reset()
+	}
+	return s
+}
+
+// Emit calls fn with a Summary every interval until ctx is done, returning
+// the context's error.  The snapshot is taken under the lock, fn is called
+// without it so a slow callback does not block Add.  Panics in fn are
+// recovered and counted by Panics.
+func (e *Emitter) Emit(ctx context.Context, interval time.Duration, fn func(Summary)) error {
+	return e.run(ctx, interval, func() {
+		fn(e.snapshot())
+	})
+}
+
+// Group keeps one Estimator per label, created on first use, and emits all of
+// them on every tick.
+type Group struct {
+	emitter
+
+	mu         sync.Mutex
+	invariants []Estimate
+	quantiles  []float64
+	series     map[string]*Estimator
+}
+
+// NewGroup creates a group whose estimators are constructed with invariants
+// and report the given quantiles, or when nil those of the Known
+// invariants.
+func NewGroup(quantiles []float64, invariants ...Estimate) *Group {
+	return &Group{
+		emitter:    newEmitter(),
+		invariants: invariants,
+		quantiles:  quantiles,
+		series:     make(map[string]*Estimator),
+	}
+}
+
+// Add adds value to the estimator for label.  It is safe to call
+// concurrently with Emit.
+func (g *Group) Add(label string, value float64) {
+	g.mu.Lock()
+	est, ok := g.series[label]
+	if !ok {
+		est = New(g.invariants...)
+		g.series[label] = est
+	}
+	est.Add(value)
+	g.mu.Unlock()
+}
+
+func (g *Group) snapshot() map[string]Summary {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	all := make(map[string]Summary, len(g.series))
+	for label, est := range g.series {
+		all[label] = summarize(est, g.quantiles)
+		if g.Rotate {
+			g.series[label] = New(g.invariants...)
+		}
+	}
+	return all
+}
+
+// Emit calls fn for every label with its Summary every interval until ctx is
+// done, returning the context's error.  All summaries of one tick come from
+// the same snapshot.  A panic in fn is recovered and counted by Panics
+// without affecting the calls for the other labels.
+func (g *Group) Emit(ctx context.Context, interval time.Duration, fn func(label string, s Summary)) error {
+	return g.run(ctx, interval, func() {
+		for label, s := range g.snapshot() {
+			label, s := label, s
+			g.call(func() { fn(label, s) })
+		}
+	})
+}
@@ -0,0 +1,439 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+const tick = time.Second
+
+// fakeClock models a time.Ticker: ticks are delivered on a channel holding at
+// most one pending tick, later ticks are dropped while one is pending.  The
+// run loop acknowledges every time it goes back to waiting on idle.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	ticks chan time.Time
+	idle  chan bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		ticks: make(chan time.Time, 1),
+		idle:  make(chan bool),
+	}
+}
+
+func (c *fakeClock) install(e *emitter) {
+	e.now = c.Now
+	e.newTicker = func(time.Duration) (<-chan time.Time, func()) { return c.ticks, func() {} }
+	e.idle = func() { c.idle <- true }
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// advance moves the clock forward by n ticks, delivering each unless one is
+// already pending.
+func (c *fakeClock) advance(n int) {
+	for i := 0; i < n; i++ {
+		c.mu.Lock()
+		c.now = c.now.Add(tick)
+		now := c.now
+		c.mu.Unlock()
+
+		select {
+		case c.ticks <- now:
+		default:
+		}
+	}
+}
+
+// blockingEmit runs e.Emit with a callback that reports each summary on
+// started and then waits for a value on release.
+func blockingEmit(e *Emitter) (started chan Summary, release chan bool, cancel func()) {
+	started = make(chan Summary)
+	release = make(chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go e.Emit(ctx, tick, func(s Summary) {
+		started <- s
+		<-release
+	})
+
+	return started, release, cancel
+}
+
+// expectIdle fails if the run loop calls back again before waiting for the
+// next tick.
+func expectIdle(t *testing.T, clock *fakeClock, started chan Summary) {
+	select {
+	case <-clock.idle:
+	case <-started:
+		t.Fatalf("want the emitter to wait for the next tick, got a callback")
+	}
+}
+
+func TestEmitSummaries(t *testing.T) {
+	clock := newFakeClock()
+	e := NewEmitter(New(Known(0.5, 0.01)), 0.5)
+	clock.install(&e.emitter)
+
+	started, release, cancel := blockingEmit(e)
+	defer cancel()
+	<-clock.idle
+
+	want := New(Known(0.5, 0.01))
+	for i := 1; i <= 3; i++ {
+		e.Add(float64(i))
+		want.Add(float64(i))
+	}
+
+	clock.advance(1)
+	s := <-started
+	release <- true
+	<-clock.idle
+
+	if got, want := s.Count, 3; got != want {
+		t.Fatalf("want count %d, got %d", want, got)
+	}
+	if got, want := s.Quantiles[0.5], want.Get(0.5); got != want {
+		t.Fatalf("want median %f, got %f", want, got)
+	}
+
+	e.Add(4)
+	clock.advance(1)
+	s = <-started
+	release <- true
+	<-clock.idle
+
+	if got, want := s.Count, 4; got != want {
+		t.Fatalf("want cumulative count %d, got %d", want, got)
+	}
+}
+
+func TestEmitRotate(t *testing.T) {
+	clock := newFakeClock()
+	// the quantile of the target, by default
+	e := NewEmitter(New(Known(0.5, 0.01)))
+	clock.install(&e.emitter)
+	e.Rotate = true
+
+	started, release, cancel := blockingEmit(e)
+	defer cancel()
+	<-clock.idle
+
+	e.Add(1)
+	e.Add(2)
+	clock.advance(1)
+	if s := <-started; s.Count != 2 {
+		t.Fatalf("want count 2, got %d", s.Count)
+	}
+	release <- true
+	<-clock.idle
+
+	e.Add(10)
+	clock.advance(1)
+	s := <-started
+	release <- true
+	<-clock.idle
+
+	if s.Count != 1 || len(s.Quantiles) != 1 || s.Quantiles[0.5] != 10 {
+		t.Fatalf("want only the value added since the last tick, got %+v", s)
+	}
+}
+
+func TestEmitRotateKeepsOptions(t *testing.T) {
+	clock := newFakeClock()
+	var flushes int
+	e := NewEmitter(NewWithOptions([]Option{
+		WithInfPolicy(DropInf),
+		// every value flushes, rather than answering exactly from the buffer
+		WithBufferSize(1),
+		WithOnFlush(func(int) { flushes++ }),
+	}), 0.5)
+	clock.install(&e.emitter)
+	e.Rotate = true
+
+	started, release, cancel := blockingEmit(e)
+	defer cancel()
+	<-clock.idle
+
+	for tick := 0; tick < 2; tick++ {
+		e.Add(math.Inf(1))
+		e.Add(1)
+		clock.advance(1)
+		s := <-started
+		release <- true
+		<-clock.idle
+
+		if s.Count != 1 {
+			t.Fatalf("tick %d: want the infinity dropped, got count %d", tick, s.Count)
+		}
+	}
+	if flushes != 2 {
+		t.Fatalf("want the flush hook called on both ticks, got %d", flushes)
+	}
+}
+
+func TestEmitEmptySummary(t *testing.T) {
+	clock := newFakeClock()
+	e := NewEmitter(New(Known(0.5, 0.01)), 0.5, 0.99)
+	clock.install(&e.emitter)
+	e.Rotate = true
+
+	started, release, cancel := blockingEmit(e)
+	defer cancel()
+	<-clock.idle
+
+	// a fresh estimator, then one with data, then the rotated one
+	for i, want := range []int{0, 1, 0} {
+		if want > 0 {
+			e.Add(0)
+		}
+		clock.advance(1)
+		s := <-started
+		release <- true
+		<-clock.idle
+
+		if s.Count != want {
+			t.Fatalf("tick %d: want count %d, got %d", i, want, s.Count)
+		}
+		for q, v := range s.Quantiles {
+			if empty := math.IsNaN(v); empty != (want == 0) {
+				t.Fatalf("tick %d: want NaN only when empty, got q=%f %f", i, q, v)
+			}
+		}
+	}
+}
+
+func TestEmitRecoversPanics(t *testing.T) {
+	clock := newFakeClock()
+	e := NewEmitter(New(), 0.5)
+	clock.install(&e.emitter)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go e.Emit(ctx, tick, func(Summary) {
+		panic("boom")
+	})
+	<-clock.idle
+
+	for i := 0; i < 3; i++ {
+		clock.advance(1)
+		<-clock.idle
+	}
+
+	if got, want := e.Panics(), int64(3); got != want {
+		t.Fatalf("want %d recovered panics, got %d", want, got)
+	}
+}
+
+func TestEmitFastCallbackKeepsTicks(t *testing.T) {
+	clock := newFakeClock()
+	e := NewEmitter(New(), 0.5)
+	clock.install(&e.emitter)
+	e.Backpressure = SkipTick
+
+	started, release, cancel := blockingEmit(e)
+	defer cancel()
+	<-clock.idle
+
+	for i := 0; i < 5; i++ {
+		clock.advance(1)
+		<-started
+		release <- true
+		<-clock.idle
+	}
+
+	if got := e.Skipped(); got != 0 {
+		t.Fatalf("want no skipped ticks, got %d", got)
+	}
+}
+
+func TestEmitOnTimeTickAfterCallback(t *testing.T) {
+	clock := newFakeClock()
+	e := NewEmitter(New(), 0.5)
+	clock.install(&e.emitter)
+	e.Backpressure = SkipTick
+
+	// the next tick arrives after the callback returned but before the loop
+	// looked at the channel again
+	calls := 0
+	e.now = func() time.Time {
+		now := clock.Now()
+		if calls == 1 {
+			clock.advance(1)
+		}
+		return now
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go e.Emit(ctx, tick, func(Summary) {
+		calls++
+	})
+	<-clock.idle
+
+	clock.advance(1)
+	<-clock.idle
+
+	if calls != 2 {
+		t.Fatalf("want the on-time tick to run the callback, got %d calls", calls)
+	}
+	if got := e.Skipped(); got != 0 {
+		t.Fatalf("want no skipped ticks, got %d", got)
+	}
+}
+
+func TestEmitSkipTick(t *testing.T) {
+	clock := newFakeClock()
+	e := NewEmitter(New(), 0.5)
+	clock.install(&e.emitter)
+	e.Backpressure = SkipTick
+
+	started, release, cancel := blockingEmit(e)
+	defer cancel()
+	<-clock.idle
+
+	clock.advance(1)
+	<-started
+
+	// slow callback misses three ticks
+	clock.advance(3)
+	release <- true
+	expectIdle(t, clock, started)
+
+	clock.advance(1)
+	<-started
+	release <- true
+	<-clock.idle
+
+	if got, want := e.Skipped(), int64(3); got != want {
+		t.Fatalf("want %d skipped ticks, got %d", want, got)
+	}
+}
+
+func TestEmitRunLate(t *testing.T) {
+	clock := newFakeClock()
+	e := NewEmitter(New(), 0.5)
+	clock.install(&e.emitter)
+	e.Backpressure = RunLate
+
+	started, release, cancel := blockingEmit(e)
+	defer cancel()
+	<-clock.idle
+
+	clock.advance(1)
+	<-started
+
+	// slow callback misses three ticks, one late run makes up for them
+	clock.advance(3)
+	release <- true
+
+	<-started
+	release <- true
+	expectIdle(t, clock, started)
+
+	if got, want := e.Skipped(), int64(2); got != want {
+		t.Fatalf("want %d skipped ticks, got %d", want, got)
+	}
+}
+
+func TestGroupEmitLabels(t *testing.T) {
+	clock := newFakeClock()
+	g := NewGroup(nil, Known(0.5, 0.01))
+	clock.install(&g.emitter)
+	g.Rotate = true
+
+	summaries := map[string]Summary{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go g.Emit(ctx, tick, func(label string, s Summary) {
+		summaries[label] = s
+	})
+	<-clock.idle
+
+	g.Add("get", 1)
+	g.Add("get", 3)
+	g.Add("put", 7)
+
+	clock.advance(1)
+	<-clock.idle
+
+	if s := summaries["get"]; s.Count != 2 {
+		t.Fatalf("want 2 gets, got %+v", s)
+	}
+	if s := summaries["put"]; s.Count != 1 || s.Quantiles[0.5] != 7 {
+		t.Fatalf("want 1 put of 7, got %+v", s)
+	}
+
+	clock.advance(1)
+	<-clock.idle
+
+	for label, s := range summaries {
+		if s.Count != 0 || !math.IsNaN(s.Quantiles[0.5]) {
+			t.Fatalf("want rotated %s to be empty, got %+v", label, s)
+		}
+	}
+}
+
+func TestGroupEmitPanicKeepsOtherLabels(t *testing.T) {
+	clock := newFakeClock()
+	g := NewGroup([]float64{0.5}, Known(0.5, 0.01))
+	clock.install(&g.emitter)
+	g.Rotate = true
+
+	seen := map[string]int{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go g.Emit(ctx, tick, func(label string, s Summary) {
+		if label == "bad" {
+			panic("boom")
+		}
+		seen[label] = s.Count
+	})
+	<-clock.idle
+
+	g.Add("bad", 1)
+	g.Add("a", 1)
+	g.Add("b", 1)
+	g.Add("b", 2)
+
+	clock.advance(1)
+	<-clock.idle
+
+	if seen["a"] != 1 || seen["b"] != 2 {
+		t.Fatalf("want every other label emitted, got %v", seen)
+	}
+	if got, want := g.Panics(), int64(1); got != want {
+		t.Fatalf("want %d recovered panic, got %d", want, got)
+	}
+}
+
+func TestEmitStopsWithContext(t *testing.T) {
+	e := NewEmitter(New(), 0.5)
+	newFakeClock().install(&e.emitter)
+	e.idle = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := e.Emit(ctx, tick, func(Summary) {}); err != context.Canceled {
+		t.Fatalf("want %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,415 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// encodingVersion is the version of the encoded state, incremented when the
+// meaning of its fields changes.
+const encodingVersion = 1
+
+// state is what the encodings capture of an estimator: its invariants and
+// the flushed summary.  Options, such as hooks and the holdout sample, are
+// configuration of the receiving estimator and are not part of it.
+type state struct {
+	Version      int            `json:"version"`
+	Targets      []targetState  `json:"targets"`
+	Observations float64        `json:"observations"`
+	Min          jsonFloat      `json:"min"`
+	Max          jsonFloat      `json:"max"`
+	Sum          jsonFloat      `json:"sum"`
+	NaNs         int            `json:"nans"`
+	Infs         int            `json:"infs"`
+	Samples      [][4]jsonFloat `json:"samples"` // value, width, delta, copies
+}
+
+type targetState struct {
+	Kind      string  `json:"kind"` // "unknown", "known" or "high"
+	Quantile  float64 `json:"quantile,omitempty"`
+	Tolerance float64 `json:"tolerance"`
+}
+
+// jsonFloat encodes the infinities JSON numbers cannot hold as strings.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(f), 0) {
+		return []byte(strconv.Quote(strconv.FormatFloat(float64(f), 'g', -1, 64))), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	var v float64
+	if len(data) > 0 && data[0] == '"' {
+		s, err := strconv.Unquote(string(data))
+		if err != nil {
+			return err
+		}
+		if v, err = strconv.ParseFloat(s, 64); err != nil || !math.IsInf(v, 0) {
+			return wrapf(ErrCorruptData, "float %s", data)
+		}
+	} else if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*f = jsonFloat(v)
+	return nil
+}
+
+// state flushes the estimator and captures it.
+func (est *Estimator) state() (*state, error) {
+	est.flush()
+
+	s := &state{
+		Version:      encodingVersion,
+		Observations: est.observations,
+		Sum:          jsonFloat(est.sum),
+		NaNs:         est.nans,
+		Infs:         est.infs,
+		Samples:      make([][4]jsonFloat, 0, len(est.items)),
+	}
+	if est.Count() > 0 {
+		s.Min, s.Max = jsonFloat(est.min), jsonFloat(est.max)
+	}
+
+	for _, f := range est.estimates() {
+		switch f := f.(type) {
+		case bias:
+			s.Targets = append(s.Targets, targetState{Kind: "unknown", Tolerance: f.tolerance})
+		case highBias:
+			s.Targets = append(s.Targets, targetState{Kind: "high", Tolerance: f.tolerance})
+		case target:
+			tolerance := f.tolerance
+			if f.requested != 0 {
+				// Known clamps it again on decoding, with the same warning
+				tolerance = f.requested
+			}
+			s.Targets = append(s.Targets, targetState{Kind: "known", Quantile: f.q, Tolerance: tolerance})
+		default:
+			return nil, wrapf(ErrUnsupportedEstimate, "%T", f)
+		}
+	}
+
+	for _, cur := range est.items {
+		s.Samples = append(s.Samples, [4]jsonFloat{
+			jsonFloat(cur.v), jsonFloat(cur.rank), jsonFloat(cur.delta), jsonFloat(cur.copies),
+		})
+	}
+	return s, nil
+}
+
+// restore replaces the summary and invariants of the empty estimator with
+// those of s, keeping its options.  Nothing changes unless s is valid: its
+// estimates pass NewChecked, its counts are finite and not negative, and its
+// summary passes DebugValidate.
+func (est *Estimator) restore(s *state) error {
+	if est.Count() > 0 {
+		return wrapf(ErrNotEmpty, "decoding into an estimator of %d values", est.Count())
+	}
+	if s.Version != encodingVersion {
+		return wrapf(ErrUnsupportedVersion, "version %d", s.Version)
+	}
+
+	invariants := make([]Estimate, 0, len(s.Targets))
+	for i, t := range s.Targets {
+		var f Estimate
+		switch t.Kind {
+		case "unknown":
+			f = Unknown(t.Tolerance)
+		case "known":
+			f = Known(t.Quantile, t.Tolerance)
+		case "high":
+			f = HighBiased(t.Tolerance)
+		default:
+			return wrapf(ErrCorruptData, "estimate of kind %q", t.Kind)
+		}
+		// no encoder writes estimates NewChecked rejects
+		if err := checkEstimate(f); err != nil {
+			return wrapf(ErrCorruptData, "target %d: %v", i, err)
+		}
+		invariants = append(invariants, f)
+	}
+	if s.NaNs < 0 || s.Infs < 0 {
+		return wrapf(ErrCorruptData, "negative counts of %d NaNs and %d infinities", s.NaNs, s.Infs)
+	}
+	if s.Observations > 0 && (math.IsNaN(float64(s.Min)) || math.IsNaN(float64(s.Max))) {
+		return wrapf(ErrCorruptData, "NaN extremes of %g observations", s.Observations)
+	}
+
+	decoded := &Estimator{
+		invariants:   invariants,
+		observations: s.Observations,
+		min:          float64(s.Min),
+		max:          float64(s.Max),
+		sum:          float64(s.Sum),
+		nans:         s.NaNs,
+		infs:         s.Infs,
+		items:        make([]item, 0, len(s.Samples)),
+	}
+	for i, sample := range s.Samples {
+		it := item{
+			v:      float64(sample[0]),
+			rank:   float64(sample[1]),
+			delta:  float64(sample[2]),
+			copies: float64(sample[3]),
+		}
+		if math.IsNaN(it.v) {
+			return wrapf(ErrCorruptData, "item %d: NaN value", i)
+		}
+		decoded.items = append(decoded.items, it)
+	}
+	if err := decoded.DebugValidate(); err != nil {
+		return err
+	}
+
+	est.invariants = decoded.invariants
+	est.items = decoded.items
+	est.observations = decoded.observations
+	est.min, est.max, est.sum = decoded.min, decoded.max, decoded.sum
+	est.nans, est.infs = decoded.nans, decoded.infs
+	if est.buffer == nil {
+		est.buffer = make([]float64, 0, bufferSize)
+	}
+	return nil
+}
+
+// MarshalJSON flushes the estimator and encodes its invariants and summary.
+// Estimates defined outside the package cannot be encoded.
+func (est *Estimator) MarshalJSON() ([]byte, error) {
+	s, err := est.state()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON restores an estimator encoded by MarshalJSON into an empty
+// estimator, keeping its options but replacing its invariants.  Decoding
+// into an estimator with observations returns ErrNotEmpty, use Merge to
+// combine them.
+func (est *Estimator) UnmarshalJSON(data []byte) error {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return wrapf(ErrCorruptData, "%v", err)
+	}
+	return est.restore(&s)
+}
+
+// GobEncode flushes the estimator and encodes its invariants and summary
+// like MarshalJSON.
+func (est *Estimator) GobEncode() ([]byte, error) {
+	s, err := est.state()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode restores an estimator encoded by GobEncode like UnmarshalJSON.
+func (est *Estimator) GobDecode(data []byte) error {
+	var s state
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return wrapf(ErrCorruptData, "%v", err)
+	}
+	return est.restore(&s)
+}
+
+// binaryVersion is the first byte of MarshalBinary's layout, and
+// binaryWeighted that of summaries with fractional widths, deltas or copies,
+// such as from AddWeighted or decay.
+const (
+	binaryVersion  = 1
+	binaryWeighted = 2
+)
+
+// MarshalBinary flushes the estimator and encodes its invariants and summary
+// in a compact little-endian layout:
+//
+//	version                   byte
+//	targets                   uvarint
+//	kind, quantile, tolerance byte, float64, float64 per target
+//	observations, min, max, sum float64
+//	nans, infs                uvarint
+//	items                     uvarint
+//	value                     float64 per item,
+//	width, delta, copies      uvarint, whole numbers of observations
+//
+// The kind of a target is 0 for Unknown, 1 for Known and 2 for HighBiased.
+// Summaries with fractional widths, deltas or copies are written as version
+// 2 with float64 instead.  The size is proportional to the number of items,
+// not observations.
+func (est *Estimator) MarshalBinary() ([]byte, error) {
+	s, err := est.state()
+	if err != nil {
+		return nil, err
+	}
+
+	version := byte(binaryVersion)
+	for _, sample := range s.Samples {
+		for _, f := range sample[1:] {
+			if f != jsonFloat(math.Trunc(float64(f))) {
+				version = binaryWeighted
+			}
+		}
+	}
+
+	data := make([]byte, 0, 64+17*len(s.Targets)+12*len(s.Samples))
+	data = append(data, version)
+	data = binary.AppendUvarint(data, uint64(len(s.Targets)))
+	for _, t := range s.Targets {
+		kind := byte(0)
+		switch t.Kind {
+		case "known":
+			kind = 1
+		case "high":
+			kind = 2
+		}
+		data = append(data, kind)
+		data = appendFloat(data, t.Quantile)
+		data = appendFloat(data, t.Tolerance)
+	}
+	for _, f := range []float64{s.Observations, float64(s.Min), float64(s.Max), float64(s.Sum)} {
+		data = appendFloat(data, f)
+	}
+	data = binary.AppendUvarint(data, uint64(s.NaNs))
+	data = binary.AppendUvarint(data, uint64(s.Infs))
+	data = binary.AppendUvarint(data, uint64(len(s.Samples)))
+	for _, sample := range s.Samples {
+		data = appendFloat(data, float64(sample[0]))
+		for _, f := range sample[1:] {
+			if version == binaryWeighted {
+				data = appendFloat(data, float64(f))
+			} else {
+				data = binary.AppendUvarint(data, uint64(f))
+			}
+		}
+	}
+	return data, nil
+}
+
+func appendFloat(data []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(data, math.Float64bits(f))
+}
+
+// UnmarshalBinary restores an estimator encoded by MarshalBinary like
+// UnmarshalJSON.  Truncated or malformed input returns ErrCorruptData.
+func (est *Estimator) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return wrapf(ErrCorruptData, "no version byte")
+	}
+	version := data[0]
+	if version != binaryVersion && version != binaryWeighted {
+		return wrapf(ErrUnsupportedVersion, "binary version %d", version)
+	}
+	r := binaryReader{data: data[1:]}
+
+	s := state{Version: encodingVersion}
+	targets := r.count(17)
+	for i := 0; i < targets && r.err == nil; i++ {
+		t := targetState{Kind: "unknown"}
+		switch kind := r.byte(); kind {
+		case 0:
+		case 1:
+			t.Kind = "known"
+		case 2:
+			t.Kind = "high"
+		default:
+			r.fail("estimate of kind %d", kind)
+		}
+		t.Quantile, t.Tolerance = r.float(), r.float()
+		s.Targets = append(s.Targets, t)
+	}
+	s.Observations = r.float()
+	s.Min, s.Max, s.Sum = jsonFloat(r.float()), jsonFloat(r.float()), jsonFloat(r.float())
+	s.NaNs, s.Infs = r.count(0), r.count(0)
+	field, size := r.uvarint, 11
+	if version == binaryWeighted {
+		field, size = r.float, 32
+	}
+	items := r.count(size)
+	for i := 0; i < items && r.err == nil; i++ {
+		s.Samples = append(s.Samples, [4]jsonFloat{
+			jsonFloat(r.float()), jsonFloat(field()), jsonFloat(field()), jsonFloat(field()),
+		})
+	}
+	if r.err == nil && len(r.data) > 0 {
+		r.fail("%d bytes after the last item", len(r.data))
+	}
+	if r.err != nil {
+		return r.err
+	}
+	return est.restore(&s)
+}
+
+// binaryReader consumes the layout of MarshalBinary, keeping the first
+// error and returning zeros after it.
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = wrapf(ErrCorruptData, format, args...)
+	}
+}
+
+func (r *binaryReader) byte() byte {
+	if r.err != nil || len(r.data) < 1 {
+		r.fail("truncated")
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *binaryReader) float() float64 {
+	if r.err != nil || len(r.data) < 8 {
+		r.fail("truncated")
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(r.data))
+	r.data = r.data[8:]
+	return f
+}
+
+func (r *binaryReader) uvarint() float64 {
+	if r.err != nil {
+		return 0
+	}
+	n, read := binary.Uvarint(r.data)
+	if read <= 0 {
+		r.fail("truncated")
+		return 0
+	}
+	r.data = r.data[read:]
+	return float64(n)
+}
+
+// count reads a uvarint of elements taking at least size bytes each, which
+// must fit in the rest of the input.
+func (r *binaryReader) count(size int) int {
+	n := r.uvarint()
+	if r.err != nil {
+		return 0
+	}
+	if n > math.MaxInt32 || size > 0 && n > float64(len(r.data)/size) {
+		r.fail("%g elements of %d bytes in %d bytes", n, size, len(r.data))
+		return 0
+	}
+	return int(n)
+}
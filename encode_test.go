@@ -0,0 +1,389 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+// sameEstimates fails t unless a and b answer every percentile alike.
+func sameEstimates(t *testing.T, a, b *Estimator) {
+	t.Helper()
+	same := func(x, y float64) bool { return x == y || math.IsNaN(x) && math.IsNaN(y) }
+	for q := 0; q <= 100; q++ {
+		if x, y := a.Get(float64(q)/100), b.Get(float64(q)/100); !same(x, y) {
+			t.Fatalf("q=%f: want %f, got %f", float64(q)/100, x, y)
+		}
+	}
+	if a.Count() != b.Count() || !same(a.Min(), b.Min()) || !same(a.Max(), b.Max()) || a.Sum() != b.Sum() {
+		t.Fatalf("want count %d min %f max %f sum %f, got %d %f %f %f",
+			a.Count(), a.Min(), a.Max(), a.Sum(), b.Count(), b.Min(), b.Max(), b.Sum())
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	targets := map[float64]float64{0.5: 0.01, 0.9: 0.005, 0.99: 0.001}
+	est := New(Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001))
+	values := quantiletest.LogNormal(0, 1).Generate(20000, 1)
+	for _, v := range values[:10000] {
+		est.Add(v)
+	}
+	est.Add(math.NaN())
+
+	data, err := json.Marshal(est)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Estimator
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	sameEstimates(t, est, &decoded)
+	if decoded.NaNCount() != 1 || len(decoded.buffer) != 0 || cap(decoded.buffer) != bufferSize {
+		t.Fatalf("want 1 NaN and an empty buffer of %d, got %d and %d of %d", bufferSize, decoded.NaNCount(), len(decoded.buffer), cap(decoded.buffer))
+	}
+
+	// continuing after the round trip is the same as never having stopped
+	for _, v := range values[10000:] {
+		est.Add(v)
+		decoded.Add(v)
+	}
+	sameEstimates(t, est, &decoded)
+	exact := quantiletest.NewExact(values)
+	for q, e := range targets {
+		quantiletest.AssertWithinRankError(t, exact, &decoded, q, e)
+	}
+}
+
+func TestJSONFixture(t *testing.T) {
+	const fixture = `{"version":1,"targets":[{"kind":"known","quantile":0.5,"tolerance":0.1},{"kind":"unknown","tolerance":0.2}],` +
+		`"observations":10,"min":"-Inf","max":9,"sum":"-Inf","nans":0,"infs":1,` +
+		`"samples":[["-Inf",1,0,1],[1,1,0,1],[2,1,0,1],[3,1,0,1],[4,1,0,1],[6,2,0,1],[8,2,0,1],[9,1,0,1]]}`
+
+	est := New(Known(0.5, 0.1), Unknown(0.2))
+	est.Add(math.Inf(-1))
+	for i := 1; i < 10; i++ {
+		est.Add(float64(i))
+	}
+	data, err := json.Marshal(est)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != fixture {
+		t.Fatalf("the encoding changed, want:\n%s\ngot:\n%s", fixture, data)
+	}
+
+	decoded := New()
+	if err := json.Unmarshal([]byte(fixture), decoded); err != nil {
+		t.Fatal(err)
+	}
+	sameEstimates(t, est, decoded)
+	if !math.IsInf(decoded.Get(0), -1) || decoded.InfCount() != 1 {
+		t.Fatalf("want the -Inf minimum back, got %f", decoded.Get(0))
+	}
+}
+
+func TestJSONKeepsOptions(t *testing.T) {
+	flushes := 0
+	decoded := NewWithOptions([]Option{WithOnFlush(func(int) { flushes++ })}, Unknown(0.5))
+
+	src := New(Known(0.01, 0.05))
+	src.Add(1)
+	data, err := json.Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	decoded.Add(2)
+	decoded.Get(0.5)
+	if flushes != 1 {
+		t.Fatalf("want the flush hook kept, got %d flushes", flushes)
+	}
+	// the clamped tolerance decodes with its warning
+	if len(decoded.Warnings()) != 1 {
+		t.Fatalf("want the clamping warning back, got %v", decoded.Warnings())
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	full := New()
+	full.Add(1)
+	if err := json.Unmarshal([]byte(`{"version":1}`), full); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("want ErrNotEmpty decoding into an estimator with values, got %v", err)
+	}
+	if full.Count() != 1 {
+		t.Fatal("the failed decoding changed the estimator")
+	}
+
+	if _, err := json.Marshal(New(highBiasedEstimate{})); !errors.Is(err, ErrUnsupportedEstimate) {
+		t.Fatalf("want ErrUnsupportedEstimate for a foreign Estimate, got %v", err)
+	}
+
+	for blob, want := range map[string]error{
+		`{"version":2}`: ErrUnsupportedVersion,
+		`{"version":1,"targets":[{"kind":"other"}]}`:                                 ErrCorruptData,
+		`{"version":1,"observations":2,"samples":[[2,1,0,1],[1,1,0,1]]}`:             ErrCorruptData,
+		`{"version":1,"observations":3,"samples":[[1,1,0,1],[2,1,0,1]]}`:             ErrCorruptData,
+		`{"version":1,"observations":1,"samples":[["NaN",1,0,1]]}`:                   ErrCorruptData,
+		`{"version":1,"observations":2,"samples":[[1,1,0,1],[2,1,5,1]]}`:             ErrCorruptData,
+		`{"version":1,"observations":2,"samples":[[1,1,0,1],[2,1`:                    ErrCorruptData,
+		`{"version":1,"observations":2,"samples":[[1,1,0,1],[2,1,0,1]],"min":"big"}`: ErrCorruptData,
+		`{"version":1,"targets":[{"kind":"known","quantile":7,"tolerance":0.01}]}`:   ErrCorruptData,
+		`{"version":1,"targets":[{"kind":"unknown","tolerance":-0.1}]}`:              ErrCorruptData,
+		`{"version":1,"targets":[{"kind":"high","tolerance":0.5}]}`:                  ErrCorruptData,
+		`{"version":1,"observations":-1}`:                                            ErrCorruptData,
+		`{"version":1,"observations":-2,"samples":[[1,-1,0,1],[2,-1,0,1]]}`:          ErrCorruptData,
+		`{"version":1,"observations":1e19,"samples":[[1,1,0,1],[2,1e19,0,1]]}`:       ErrCorruptData,
+		`{"version":1,"nans":-1}`:                                                    ErrCorruptData,
+		`{"version":1,"infs":-3}`:                                                    ErrCorruptData,
+	} {
+		est := New()
+		if err := est.UnmarshalJSON([]byte(blob)); !errors.Is(err, want) {
+			t.Errorf("%s: want %v, got %v", blob, want, err)
+		}
+		if est.Count() != 0 || len(est.items) != 0 {
+			t.Errorf("%s: the failed decoding changed the estimator", blob)
+		}
+	}
+}
+
+func TestRestoreNonFinite(t *testing.T) {
+	// counts the binary and gob encodings can hold and JSON cannot
+	samples := [][4]jsonFloat{{1, 1, 0, 1}, {2, 1, 0, 1}}
+	for name, s := range map[string]state{
+		"NaN observations":      {Version: 1, Observations: math.NaN()},
+		"infinite observations": {Version: 1, Observations: math.Inf(1), Samples: [][4]jsonFloat{{1, jsonFloat(math.Inf(1)), 0, 1}}},
+		"NaN width":             {Version: 1, Observations: math.NaN(), Samples: [][4]jsonFloat{{1, jsonFloat(math.NaN()), 0, 1}}},
+		"NaN extremes":          {Version: 1, Observations: 2, Samples: samples, Min: jsonFloat(math.NaN()), Max: 2},
+	} {
+		est := New()
+		if err := est.restore(&s); !errors.Is(err, ErrCorruptData) {
+			t.Errorf("%s: want ErrCorruptData, got %v", name, err)
+		}
+		if est.Count() != 0 || len(est.items) != 0 {
+			t.Errorf("%s: the failed decoding changed the estimator", name)
+		}
+	}
+
+	// and through the binary encoding
+	est := New()
+	data, err := (&Estimator{invariants: defaultInvariants, observations: math.NaN()}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := est.UnmarshalBinary(data); !errors.Is(err, ErrCorruptData) || est.Count() != 0 {
+		t.Fatalf("want ErrCorruptData decoding NaN observations, got %v and %d values", err, est.Count())
+	}
+}
+
+// highBiasedEstimate is an Estimate the package does not know how to encode.
+type highBiasedEstimate struct{}
+
+func (highBiasedEstimate) Delta(rank, observations float64) float64 {
+	return 0.02 * (observations - rank)
+}
+
+func TestGobRoundTrip(t *testing.T) {
+	type report struct {
+		Name    string
+		Latency *Estimator
+		Size    *Estimator
+	}
+	values := quantiletest.Pareto(1.5).Generate(20000, 1)
+	sent := report{Name: "api", Latency: New(Known(0.5, 0.01), Known(0.99, 0.001)), Size: New()}
+	for _, v := range values {
+		sent.Latency.Add(v)
+	}
+	sent.Latency.Add(math.Inf(1))
+
+	var wire bytes.Buffer
+	if err := gob.NewEncoder(&wire).Encode(&sent); err != nil {
+		t.Fatal(err)
+	}
+
+	// a decoder sharing nothing with the encoder, as in another process
+	var received report
+	if err := gob.NewDecoder(bytes.NewReader(wire.Bytes())).Decode(&received); err != nil {
+		t.Fatal(err)
+	}
+	if received.Name != "api" || received.Latency == nil || received.Size == nil {
+		t.Fatalf("want the report back, got %+v", received)
+	}
+	sameEstimates(t, sent.Latency, received.Latency)
+	sameEstimates(t, sent.Size, received.Size)
+
+	exact := quantiletest.NewExact(append(values, math.Inf(1)))
+	for q, e := range map[float64]float64{0.5: 0.01, 0.99: 0.001} {
+		quantiletest.AssertWithinRankError(t, exact, received.Latency, q, e)
+	}
+	received.Size.Add(1)
+	if received.Size.Get(0.5) != 1 {
+		t.Fatal("want a usable estimator decoded from an empty one")
+	}
+
+	if err := New().GobDecode([]byte("not gob")); !errors.Is(err, ErrCorruptData) {
+		t.Fatalf("want ErrCorruptData, got %v", err)
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	est := New(Known(0.5, 0.01), Known(0.99, 0.001), Unknown(0.05), HighBiased(0.01))
+	for _, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		est.Add(v)
+	}
+	est.Add(math.Inf(-1))
+
+	data, err := est.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// proportional to the items, not the observations
+	if max := 1 + 1 + 4*17 + 4*8 + 1 + 1 + 2 + 8*len(est.items) + 3*binary.MaxVarintLen32*len(est.items); len(data) > max {
+		t.Fatalf("want at most %d bytes for %d items, got %d", max, len(est.items), len(data))
+	}
+	if js, _ := json.Marshal(est); len(data) >= len(js) {
+		t.Fatalf("want less than the %d bytes of JSON, got %d", len(js), len(data))
+	}
+
+	decoded := New()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	sameEstimates(t, est, decoded)
+	if !sameInvariants(est.invariants, decoded.invariants) {
+		t.Fatalf("want the invariants %v back, got %v", est.invariants, decoded.invariants)
+	}
+	if decoded.InfCount() != 1 {
+		t.Fatalf("want 1 Inf, got %d", decoded.InfCount())
+	}
+}
+
+func TestBinaryFixture(t *testing.T) {
+	// version 1, Known(0.5, 0.01), 3 observations of 1, 2, 3 summing to 6
+	const fixture = "01" + "01" + "01" + "000000000000e03f" + "7b14ae47e17a843f" +
+		"0000000000000840" + "000000000000f03f" + "0000000000000840" + "0000000000001840" +
+		"00" + "00" + "03" +
+		"000000000000f03f" + "010001" +
+		"0000000000000040" + "010001" +
+		"0000000000000840" + "010001"
+
+	est := New(Known(0.5, 0.01))
+	for _, v := range []float64{3, 1, 2} {
+		est.Add(v)
+	}
+	data, err := est.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(data); got != fixture {
+		t.Fatalf("the encoding changed, want:\n%s\ngot:\n%s", fixture, got)
+	}
+}
+
+func TestBinaryErrors(t *testing.T) {
+	est := New(Known(0.5, 0.01))
+	for i := 0; i < 1000; i++ {
+		est.Add(float64(i))
+	}
+	data, err := est.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every truncation fails without panicking
+	for n := 0; n < len(data); n++ {
+		if err := New().UnmarshalBinary(data[:n]); !errors.Is(err, ErrCorruptData) {
+			t.Fatalf("truncated to %d of %d bytes: want ErrCorruptData, got %v", n, len(data), err)
+		}
+	}
+
+	cases := map[string]struct {
+		data []byte
+		want error
+	}{
+		"version":  {append([]byte{3}, data[1:]...), ErrUnsupportedVersion},
+		"trailing": {append(append([]byte(nil), data...), 0), ErrCorruptData},
+		"kind":     {append([]byte{1, 1, 7}, data[3:]...), ErrCorruptData},
+		// a count claiming more items than bytes left must not allocate them
+		"huge count": {[]byte{1, 0xff, 0xff, 0xff, 0xff, 0x0f}, ErrCorruptData},
+		"not empty":  {data, ErrNotEmpty},
+	}
+	for name, c := range cases {
+		target := New()
+		if name == "not empty" {
+			target.Add(1)
+		}
+		if err := target.UnmarshalBinary(c.data); !errors.Is(err, c.want) {
+			t.Errorf("%s: want %v, got %v", name, c.want, err)
+		}
+	}
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	est := New(Known(0.5, 0.01), Unknown(0.1))
+	for i := 0; i < 2000; i++ {
+		est.Add(float64(i % 37))
+	}
+	data, _ := est.MarshalBinary()
+	f.Add(data)
+	f.Add([]byte{1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoded := New()
+		if decoded.UnmarshalBinary(data) != nil {
+			return
+		}
+		// whatever decodes is a valid summary that encodes again
+		if err := decoded.DebugValidate(); err != nil {
+			t.Fatal(err)
+		}
+		decoded.Add(1)
+		if _, err := decoded.MarshalBinary(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestBinaryWeighted(t *testing.T) {
+	est := New(Unknown(0.01))
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		est.AddWeighted(r.NormFloat64(), r.ExpFloat64())
+	}
+
+	data, err := est.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != binaryWeighted {
+		t.Fatalf("want version %d for fractional widths, got %d", binaryWeighted, data[0])
+	}
+	decoded := New()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	sameEstimates(t, est, decoded)
+	if got, want := decoded.Samples(), est.Samples(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want the samples restored exactly")
+	}
+	for n := 0; n < len(data); n++ {
+		if err := New().UnmarshalBinary(data[:n]); !errors.Is(err, ErrCorruptData) {
+			t.Fatalf("truncated to %d bytes: want ErrCorruptData, got %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the package wrap one of these, with the offending
+// values in the message.  Test for them with errors.Is.
+var (
+	// ErrNoSamples reports an estimate asked of an estimator without
+	// observations.
+	ErrNoSamples = errors.New("quantile: no samples")
+
+	// ErrInvalidQuantile reports a quantile outside [0, 1].
+	ErrInvalidQuantile = errors.New("quantile: invalid quantile")
+
+	// ErrInvalidEpsilon reports a tolerance that cannot be honored as given.
+	ErrInvalidEpsilon = errors.New("quantile: invalid epsilon")
+
+	// ErrInvalidBounds reports histogram bounds that are empty, NaN or not
+	// increasing.
+	ErrInvalidBounds = errors.New("quantile: invalid bounds")
+
+	// ErrInvalidWeight reports a weight that is not positive and finite.
+	ErrInvalidWeight = errors.New("quantile: invalid weight")
+
+	// ErrCorruptData reports a summary or encoding that breaks the
+	// summary's invariants.
+	ErrCorruptData = errors.New("quantile: corrupt data")
+
+	// ErrUnsupportedVersion reports an encoding of an unknown version.
+	ErrUnsupportedVersion = errors.New("quantile: unsupported version")
+
+	// ErrUnsupportedEstimate reports encoding an Estimate defined outside
+	// the package, which cannot be decoded again.
+	ErrUnsupportedEstimate = errors.New("quantile: unsupported estimate")
+
+	// ErrNotEmpty reports decoding into an estimator that has observations.
+	ErrNotEmpty = errors.New("quantile: estimator is not empty")
+
+	// ErrMergeSelf reports merging an estimator into itself.
+	ErrMergeSelf = errors.New("quantile: cannot merge an estimator into itself")
+
+	// ErrTargetMismatch reports merging estimators with different
+	// invariants.
+	ErrTargetMismatch = errors.New("quantile: estimators have different targets")
+)
+
+// wrapf wraps err with a message formatted from format and args.
+func wrapf(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
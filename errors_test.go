@@ -0,0 +1,107 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	cases := map[string]struct {
+		err      func() error
+		sentinel error
+		context  string
+	}{
+		"merge self": {
+			func() error {
+				est := New()
+				return est.Merge(est)
+			},
+			ErrMergeSelf, "itself",
+		},
+		"merge mismatch": {
+			func() error {
+				return New(Known(0.5, 0.01)).Merge(New(Known(0.5, 0.01), Known(0.99, 0.001)))
+			},
+			ErrTargetMismatch, "2 estimates into 1",
+		},
+		"clamped tolerance": {
+			func() error {
+				return New(Known(0.01, 0.05)).Warnings()[0]
+			},
+			ErrInvalidEpsilon, "Known(0.01, 0.05)",
+		},
+		"corrupt summary": {
+			func() error {
+				est := New()
+				for i := 0; i < 10; i++ {
+					est.Add(float64(i))
+				}
+				est.flush()
+				est.items[1].v = -1
+				return est.DebugValidate()
+			},
+			ErrCorruptData, "item 0 (v=0): followed by smaller v=-1",
+		},
+	}
+
+	for name, c := range cases {
+		err := c.err()
+		if !errors.Is(err, c.sentinel) {
+			t.Errorf("%s: want %v, got %v", name, c.sentinel, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.context) {
+			t.Errorf("%s: want %q in %q", name, c.context, err)
+		}
+	}
+}
+
+func TestNewChecked(t *testing.T) {
+	invalid := map[string]struct {
+		estimate Estimate
+		sentinel error
+	}{
+		"quantile above 1":      {Known(1.5, 0.01), ErrInvalidQuantile},
+		"quantile below 0":      {Known(-0.1, 0.01), ErrInvalidQuantile},
+		"quantile NaN":          {Known(math.NaN(), 0.01), ErrInvalidQuantile},
+		"negative tolerance":    {Known(0.5, -0.2), ErrInvalidEpsilon},
+		"zero tolerance":        {Known(0.5, 0), ErrInvalidEpsilon},
+		"Unknown tolerance 0":   {Unknown(0), ErrInvalidEpsilon},
+		"Unknown tolerance 0.5": {Unknown(0.5), ErrInvalidEpsilon},
+		"HighBiased NaN":        {HighBiased(math.NaN()), ErrInvalidEpsilon},
+		"clamped beyond 0.5":    {Known(0.5, 0.6), ErrInvalidEpsilon},
+	}
+	for name, c := range invalid {
+		est, err := NewChecked(Unknown(0.01), c.estimate)
+		if est != nil || !errors.Is(err, c.sentinel) {
+			t.Errorf("%s: want %v, got %v", name, c.sentinel, err)
+		}
+	}
+
+	valid := map[string][]Estimate{
+		"none":           nil,
+		"minimum":        {Known(0, 0.01)},
+		"maximum":        {Known(1, 0.01)},
+		"clamped":        {Known(0.999, 0.01)},
+		"near the edges": {Known(0.0001, 0.0001), Unknown(0.4999), HighBiased(1e-9)},
+	}
+	for name, invariants := range valid {
+		if est, err := NewChecked(invariants...); est == nil || err != nil {
+			t.Errorf("%s: want an estimator, got %v", name, err)
+		}
+	}
+
+	est, err := NewCheckedWithOptions([]Option{WithMaxItems(10)}, Known(0.5, 0.01))
+	if err != nil || est.maxItems != 10 {
+		t.Fatalf("want the options applied, got %v", err)
+	}
+	if _, err := NewCheckedWithOptions([]Option{WithMaxItems(10)}, Known(2, 0.01)); !errors.Is(err, ErrInvalidQuantile) {
+		t.Fatalf("want ErrInvalidQuantile, got %v", err)
+	}
+}
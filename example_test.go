@@ -2,6 +2,7 @@ package quantile
 
 import (
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -26,7 +27,31 @@ func ExampleEstimator() {
 	Work()
 	Work()
 
-	// Report the percentiles
-	fmt.Println("95th: ", rpcs.Get(0.95))
-	fmt.Println("99th: ", rpcs.Get(0.99))
+	// Report the percentiles, which are NaN until the first observation
+	if p95 := rpcs.Get(0.95); !math.IsNaN(p95) {
+		fmt.Println("95th: ", p95)
+	}
+	if p99, ok := rpcs.GetOK(0.99); ok {
+		fmt.Println("99th: ", p99)
+	}
+}
+
+var latencies = NewDuration(Known(0.95, 0.005), Known(0.99, 0.001))
+
+func Serve() {
+	defer latencies.ObserveSince(time.Now())
+
+	// Dance your cares away,
+	// Worry's for another day.
+	// Let the music play,
+}
+
+func ExampleDurationEstimator() {
+	Serve()
+	Serve()
+
+	// The percentiles are durations, 0 until the first observation
+	if p99, ok := latencies.GetOK(0.99); ok {
+		fmt.Println("99th: ", p99)
+	}
 }
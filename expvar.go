@@ -0,0 +1,75 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// expvarState is the JSON rendered by String, in field order.
+type expvarState struct {
+	Count     int64                 `json:"count"`
+	Sum       jsonFloat             `json:"sum"`
+	Min       *jsonFloat            `json:"min"`
+	Max       *jsonFloat            `json:"max"`
+	Quantiles map[string]*jsonFloat `json:"quantiles"`
+}
+
+// String renders the snapshot as a JSON object, which makes it an
+// expvar.Var:
+//
+//	{"count":10,"sum":55,"min":1,"max":10,"quantiles":{"0.5":5,"0.99":10}}
+//
+// Quantiles holds the estimate of every Known target, keyed by the
+// quantile in the shortest decimal form.  Min, max and the estimates are
+// null without values, and infinities are the strings "+Inf" and "-Inf",
+// as in Estimator.MarshalJSON.
+func (s *Snapshot) String() string {
+	var quantiles []float64
+	for _, f := range s.est.estimates() {
+		if t, ok := f.(target); ok {
+			quantiles = append(quantiles, t.q)
+		}
+	}
+	sort.Float64s(quantiles)
+
+	state := expvarState{
+		Count:     s.Count(),
+		Sum:       jsonFloat(s.Sum()),
+		Quantiles: make(map[string]*jsonFloat, len(quantiles)),
+	}
+	if state.Count > 0 {
+		min, max := jsonFloat(s.Min()), jsonFloat(s.Max())
+		state.Min, state.Max = &min, &max
+	}
+	for _, q := range quantiles {
+		var estimate *jsonFloat
+		if state.Count > 0 {
+			v := jsonFloat(s.Get(q))
+			estimate = &v
+		}
+		state.Quantiles[strconv.FormatFloat(q, 'g', -1, 64)] = estimate
+	}
+
+	// nothing in the state fails to marshal
+	data, _ := json.Marshal(state)
+	return string(data)
+}
+
+// String renders a Snapshot like Snapshot.String, which makes the
+// SafeEstimator an expvar.Var safe to publish while values are added:
+//
+//	expvar.Publish("rpc_latency", est)
+func (s *SafeEstimator) String() string {
+	return s.Snapshot().String()
+}
+
+// String renders a Snapshot of all shards like Snapshot.String, which makes
+// Sharded an expvar.Var.
+func (s *Sharded) String() string {
+	return s.Snapshot().String()
+}
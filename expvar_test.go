@@ -0,0 +1,60 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestSnapshotString(t *testing.T) {
+	est := NewSafe(Known(0.99, 0.001), Known(0.5, 0.01), Unknown(0.01))
+	if got, want := est.String(), `{"count":0,"sum":0,"min":null,"max":null,"quantiles":{"0.5":null,"0.99":null}}`; got != want {
+		t.Fatalf("want %s without values, got %s", want, got)
+	}
+
+	for i := 1; i <= 10; i++ {
+		est.Add(float64(i))
+	}
+	if got, want := est.String(), `{"count":10,"sum":55,"min":1,"max":10,"quantiles":{"0.5":5,"0.99":10}}`; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+
+	est.Add(math.Inf(1))
+	if got, want := est.String(), `{"count":11,"sum":"+Inf","min":1,"max":"+Inf","quantiles":{"0.5":6,"0.99":"+Inf"}}`; got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+}
+
+func TestExpvarPublish(t *testing.T) {
+	est := NewSharded(4, Known(0.5, 0.01))
+	expvar.Publish("quantile_test_latency", est)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				est.Add(float64(i))
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(expvar.Get("quantile_test_latency").String()), &v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	var v struct{ Count int }
+	if err := json.Unmarshal([]byte(expvar.Get("quantile_test_latency").String()), &v); err != nil || v.Count != 40000 {
+		t.Fatalf("want a count of 40000, got %d: %v", v.Count, err)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import "math"
+
+// Number is the constraint of the values an EstimatorOf estimates.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// EstimatorOf is an Estimator of values of type T, such as time.Duration.
+// Values convert to float64 once on Add, and estimates convert back on Get.
+//
+// Every estimate is one of the values added, so it converts back exactly
+// as long as the values do.  Integers beyond ±2^53 do not: they round to
+// the nearest float64, and an estimate is then only near the value it
+// stands for.  Inexact counts those values, and Get keeps estimates
+// within the exact minimum and maximum, so rounding never wraps around.
+type EstimatorOf[T Number] struct {
+	est      *Estimator
+	min, max T
+	inexact  int
+}
+
+// NewOf allocates an EstimatorOf tolerating the minimum of the invariants
+// like New.
+func NewOf[T Number](invariants ...Estimate) *EstimatorOf[T] {
+	return &EstimatorOf[T]{est: New(invariants...)}
+}
+
+// NewOfWithOptions allocates an EstimatorOf like NewWithOptions.
+func NewOfWithOptions[T Number](options []Option, invariants ...Estimate) *EstimatorOf[T] {
+	return &EstimatorOf[T]{est: NewWithOptions(options, invariants...)}
+}
+
+// Add adds value like Estimator.Add.
+func (e *EstimatorOf[T]) Add(value T) {
+	n := e.est.Count()
+	v := float64(value)
+	e.est.Add(v)
+	if e.est.Count() == n {
+		// NaN or a dropped infinity
+		return
+	}
+
+	if T(v) != value {
+		e.inexact++
+	}
+	if n == 0 || value < e.min {
+		e.min = value
+	}
+	if n == 0 || value > e.max {
+		e.max = value
+	}
+}
+
+// Get estimates quantile like Estimator.Get.  Without values it returns
+// empty.
+func (e *EstimatorOf[T]) Get(quantile float64) T {
+	if v, ok := e.GetOK(quantile); ok {
+		return v
+	}
+	return empty[T]()
+}
+
+// GetOK estimates quantile like Estimator.GetOK, returning 0 and false
+// without values or for a quantile outside [0, 1], where Get returns empty.
+func (e *EstimatorOf[T]) GetOK(quantile float64) (T, bool) {
+	v, ok := e.est.GetOK(quantile)
+	if !ok || v != v {
+		return 0, false
+	}
+	return e.convert(v), true
+}
+
+// convert returns v as a T within the exact minimum and maximum.  Converting
+// a float64 beyond the range of an integer type is undefined, which only
+// rounded extremes reach.
+func (e *EstimatorOf[T]) convert(v float64) T {
+	switch {
+	case v <= float64(e.min):
+		return e.min
+	case v >= float64(e.max):
+		return e.max
+	}
+	return T(v)
+}
+
+// Count returns the number of values observed like Estimator.Count.
+func (e *EstimatorOf[T]) Count() int64 {
+	return e.est.Count()
+}
+
+// Min returns the smallest value added, or empty without values.
+func (e *EstimatorOf[T]) Min() T {
+	if e.est.Count() == 0 {
+		return empty[T]()
+	}
+	return e.min
+}
+
+// Max returns the largest value added, or empty without values.
+func (e *EstimatorOf[T]) Max() T {
+	if e.est.Count() == 0 {
+		return empty[T]()
+	}
+	return e.max
+}
+
+// Inexact returns the number of values added that float64 does not
+// represent exactly.
+func (e *EstimatorOf[T]) Inexact() int {
+	return e.inexact
+}
+
+// Reset empties the estimator like Estimator.Reset.
+func (e *EstimatorOf[T]) Reset() {
+	e.est.Reset()
+	e.min, e.max = 0, 0
+	e.inexact = 0
+}
+
+// Estimator returns the underlying float64 Estimator, for everything
+// EstimatorOf does not wrap, such as snapshots and encoding.  Values added
+// through it bypass the exact minimum, maximum and Inexact.
+func (e *EstimatorOf[T]) Estimator() *Estimator {
+	return e.est
+}
+
+// empty returns the estimate without values: NaN when T is a floating-point
+// type, like Estimator, and 0 otherwise.
+func empty[T Number]() T {
+	if half := 0.5; T(half) == 0 {
+		return 0
+	}
+	return T(math.NaN())
+}
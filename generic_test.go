@@ -0,0 +1,105 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEstimatorOfDurations(t *testing.T) {
+	est := NewOf[time.Duration](Known(0.5, 0.01), Known(0.99, 0.001))
+	if got := est.Get(0.5); got != 0 {
+		t.Fatalf("want 0 from an empty estimator, got %v", got)
+	}
+	if _, ok := est.GetOK(0.5); ok {
+		t.Fatalf("want no estimate from an empty estimator")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	var obs []time.Duration
+	for i := 0; i < 10000; i++ {
+		d := time.Duration(r.ExpFloat64() * float64(time.Millisecond))
+		obs = append(obs, d)
+		est.Add(d)
+	}
+	sort.Slice(obs, func(i, j int) bool { return obs[i] < obs[j] })
+
+	if est.Min() != obs[0] || est.Max() != obs[len(obs)-1] {
+		t.Fatalf("want extremes %v and %v, got %v and %v", obs[0], obs[len(obs)-1], est.Min(), est.Max())
+	}
+	for _, q := range []float64{0.5, 0.99} {
+		got := est.Get(q)
+		// every estimate is a value added, exactly
+		i := sort.Search(len(obs), func(i int) bool { return obs[i] >= got })
+		if i == len(obs) || obs[i] != got {
+			t.Fatalf("q=%f: estimate %v was never added", q, got)
+		}
+		if want := est.Estimator().Get(q); float64(got) != want {
+			t.Fatalf("q=%f: want %v from the float64 estimate, got %v", q, time.Duration(want), got)
+		}
+	}
+	if got, ok := est.GetOK(99); got != 0 || ok {
+		t.Fatalf("want no estimate of the quantile 99, got %v", got)
+	}
+	if est.Inexact() != 0 || est.Count() != int64(len(obs)) {
+		t.Fatalf("want %d exact values, got %d of which %d inexact", len(obs), est.Count(), est.Inexact())
+	}
+
+	est.Reset()
+	if est.Count() != 0 || est.Min() != 0 || est.Max() != 0 || est.Inexact() != 0 {
+		t.Fatalf("want an empty estimator after Reset")
+	}
+}
+
+func TestEstimatorOfInexact(t *testing.T) {
+	est := NewOf[int64](Unknown(0.01))
+	// 2^53 is the last of the consecutive integers float64 represents
+	est.Add(1 << 53)
+	est.Add(1<<53 + 1)
+	if est.Inexact() != 1 {
+		t.Fatalf("want 2^53+1 counted inexact, got %d", est.Inexact())
+	}
+	if est.Max() != 1<<53+1 {
+		t.Fatalf("want the exact maximum, got %d", est.Max())
+	}
+
+	// rounding up to 2^64 must not wrap around
+	u := NewOf[uint64](Unknown(0.01))
+	u.Add(math.MaxUint64)
+	u.Add(1<<63 + 1)
+	if got := u.Get(1); got != math.MaxUint64 {
+		t.Fatalf("want the maximum %d, got %d", uint64(math.MaxUint64), got)
+	}
+	if got := u.Get(0); got != 1<<63+1 {
+		t.Fatalf("want the minimum %d, got %d", uint64(1<<63+1), got)
+	}
+	if u.Inexact() != 2 {
+		t.Fatalf("want both values counted inexact, got %d", u.Inexact())
+	}
+}
+
+func TestEstimatorOfFloats(t *testing.T) {
+	est := NewOf[float32]()
+	if got := est.Get(0.5); !math.IsNaN(float64(got)) {
+		t.Fatalf("want NaN from an empty estimator, got %f", got)
+	}
+	if got := est.Min(); !math.IsNaN(float64(got)) {
+		t.Fatalf("want a NaN minimum of an empty estimator, got %f", got)
+	}
+
+	est.Add(float32(math.NaN()))
+	est.Add(0.1)
+	est.Add(float32(math.Inf(1)))
+	if est.Inexact() != 0 || est.Count() != 2 {
+		t.Fatalf("want 2 exact values, got %d of which %d inexact", est.Count(), est.Inexact())
+	}
+	if est.Get(0) != 0.1 || !math.IsInf(float64(est.Get(1)), 1) {
+		t.Fatalf("want 0.1 and +Inf, got %f and %f", est.Get(0), est.Get(1))
+	}
+}
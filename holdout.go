@@ -0,0 +1,76 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// maxHoldout bounds the exact values kept by WithHoldout.
+const maxHoldout = 4096
+
+// holdout is a uniform sample of the values added, kept exactly.
+type holdout struct {
+	fraction float64
+	rand     *rand.Rand
+	kept     int // values selected, including those the reservoir replaced
+	values   []float64
+}
+
+func (h *holdout) add(v float64) {
+	if h.rand.Float64() >= h.fraction {
+		return
+	}
+
+	// Algorithm R over the selected values keeps the sample uniform once
+	// it is full
+	h.kept++
+	if len(h.values) < maxHoldout {
+		h.values = append(h.values, v)
+	} else if i := h.rand.Intn(h.kept); i < maxHoldout {
+		h.values[i] = v
+	}
+}
+
+// WithHoldout keeps an exact sample of about fraction of the added values,
+// at most 4096, for ObservedError to measure the estimates against.  The
+// sample is drawn from the same values the summary sees; values merged from
+// other estimators are not sampled.
+func WithHoldout(fraction float64) Option {
+	return func(est *Estimator) {
+		est.holdout = &holdout{
+			fraction: fraction,
+			rand:     rand.New(rand.NewSource(1)),
+		}
+	}
+}
+
+// ObservedError returns the rank error of Get(quantile) within the holdout
+// sample, as a fraction of the sample.  It is NaN without WithHoldout or
+// before any value was sampled.  The sample itself is accurate to about
+// sqrt(quantile·(1-quantile)/size).
+func (est *Estimator) ObservedError(quantile float64) float64 {
+	if est.holdout == nil || len(est.holdout.values) == 0 {
+		return math.NaN()
+	}
+
+	v := est.Get(quantile)
+	values := est.holdout.values
+	sort.Float64s(values)
+
+	m := float64(len(values))
+	below := float64(sort.SearchFloat64s(values, v))
+	atOrBelow := float64(sort.Search(len(values), func(i int) bool { return values[i] > v }))
+	target := quantile * m
+	switch {
+	case target < below+1:
+		return (below + 1 - target) / m
+	case target > atOrBelow:
+		return (target - atOrBelow) / m
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+func TestObservedErrorConsistent(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		est := NewWithOptions([]Option{WithHoldout(0.02)}, Unknown(0.05))
+		obs := quantiletest.Normal(0, 1).Generate(100000, seed)
+		for _, v := range obs {
+			est.Add(v)
+		}
+		sort.Float64s(obs)
+
+		m := float64(len(est.holdout.values))
+		if want := 0.02 * float64(len(obs)); math.Abs(m-want) > 4*math.Sqrt(want) {
+			t.Fatalf("seed %d: want a holdout of about %f values, got %f", seed, want, m)
+		}
+
+		for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+			observed, actual := est.ObservedError(q), quantiletest.RankError(obs, q, est.Get(q))
+			if sampling := math.Sqrt(q*(1-q)/m) + 1/m; math.Abs(observed-actual) > 4*sampling {
+				t.Errorf("seed %d q=%f: observed error %f, actual %f, sampling error %f", seed, q, observed, actual, sampling)
+			}
+		}
+	}
+}
+
+func TestHoldoutBounded(t *testing.T) {
+	est := NewWithOptions([]Option{WithHoldout(1)})
+	for i := 0; i < 3*maxHoldout; i++ {
+		est.Add(float64(i))
+	}
+	if got := len(est.holdout.values); got != maxHoldout {
+		t.Fatalf("want the holdout capped at %d values, got %d", maxHoldout, got)
+	}
+
+	// the reservoir keeps later values as likely as earlier ones
+	late := 0
+	for _, v := range est.holdout.values {
+		if v >= 2*maxHoldout {
+			late++
+		}
+	}
+	if want := maxHoldout / 3; math.Abs(float64(late-want)) > 4*math.Sqrt(float64(want)) {
+		t.Fatalf("want about %d values from the last third, got %d", want, late)
+	}
+
+	est.Reset()
+	if got := est.ObservedError(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN after Reset, got %f", got)
+	}
+	if got := New().ObservedError(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN without a holdout, got %f", got)
+	}
+}
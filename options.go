@@ -0,0 +1,127 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+// Option configures an Estimator constructed with NewWithOptions.
+type Option func(*Estimator)
+
+// NewWithOptions allocates a new estimator like New, then applies options in
+// order.
+func NewWithOptions(options []Option, invariants ...Estimate) *Estimator {
+	est := New(invariants...)
+	for _, option := range options {
+		option(est)
+	}
+	return est
+}
+
+// NewCheckedWithOptions allocates an estimator like NewWithOptions after
+// checking the invariants like NewChecked.
+func NewCheckedWithOptions(options []Option, invariants ...Estimate) (*Estimator, error) {
+	est, err := NewChecked(invariants...)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		option(est)
+	}
+	return est, nil
+}
+
+// InfPolicy decides what Add does with infinite values.
+type InfPolicy int
+
+const (
+	// KeepInf treats ±Inf as valid extreme values.  They become the minimum
+	// or maximum and are returned for quantiles whose rank falls among them,
+	// but like any other value they only shift the rank of the finite values,
+	// so interior quantiles stay finite while finite values dominate.
+	KeepInf InfPolicy = iota
+
+	// DropInf discards ±Inf values.
+	DropInf
+)
+
+// WithInfPolicy sets the handling of ±Inf values, KeepInf by default.  Both
+// policies count infinite values in InfCount.
+func WithInfPolicy(policy InfPolicy) Option {
+	return func(est *Estimator) {
+		est.infPolicy = policy
+	}
+}
+
+// WithBufferSize sets how many values Add buffers before merging them into
+// the summary, 512 by default.  Larger buffers amortize sorting and
+// compression over more values, smaller ones keep queries from paying for a
+// large flush.  A size of 0 or 1 inserts every value as it is added.
+func WithBufferSize(size int) Option {
+	return func(est *Estimator) {
+		if size < 1 {
+			size = 1
+		}
+		est.flush()
+		est.buffer = make([]float64, 0, size)
+	}
+}
+
+// WithMaxItems caps the items the summary retains after every compression
+// at max, but no fewer than the 2 of the minimum and maximum.  Inputs for
+// which the invariants retain more, such as strictly increasing values with
+// Unknown, have items merged beyond the invariants until the cap holds,
+// which widens the error of every estimate.  Degraded reports whether that
+// happened, and GuaranteedError then includes the widest item kept, as Get
+// may be off by its whole width.
+func WithMaxItems(max int) Option {
+	return func(est *Estimator) {
+		if max < 2 {
+			max = 2
+		}
+		est.maxItems = max
+	}
+}
+
+// WithOnFlush calls hook with the number of values merged into the summary
+// after every flush.
+//
+// Hooks run synchronously once the estimator is consistent again.  A hook
+// that panics is recovered and disabled without affecting the estimator.
+func WithOnFlush(hook func(batchSize int)) Option {
+	return func(est *Estimator) {
+		est.onFlush = hook
+	}
+}
+
+// WithOnCompress calls hook with the number of items before and after every
+// compression pass, including those of Merge.  Like WithOnFlush, a panicking
+// hook is disabled.
+func WithOnCompress(hook func(before, after int)) Option {
+	return func(est *Estimator) {
+		est.onCompress = hook
+	}
+}
+
+func (est *Estimator) flushed(batchSize int) {
+	if est.onFlush == nil {
+		return
+	}
+	defer func() {
+		if recover() != nil {
+			est.onFlush = nil
+		}
+	}()
+	est.onFlush(batchSize)
+}
+
+func (est *Estimator) compressed(before, after int) {
+	if est.onCompress == nil {
+		return
+	}
+	defer func() {
+		if recover() != nil {
+			est.onCompress = nil
+		}
+	}()
+	est.onCompress(before, after)
+}
@@ -0,0 +1,232 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+func TestHooks(t *testing.T) {
+	var flushes, flushed, compressions, removed int
+	est := NewWithOptions([]Option{
+		WithOnFlush(func(batchSize int) {
+			flushes++
+			flushed += batchSize
+		}),
+		WithOnCompress(func(before, after int) {
+			compressions++
+			removed = before - after
+		}),
+	}, Unknown(0.01))
+
+	for i, v := range quantiletest.Normal(0, 1).Generate(10000, 1) {
+		est.Add(v)
+		if i%777 == 0 {
+			est.Get(0.5)
+		}
+	}
+	est.Get(0.5)
+
+	s := est.Stats()
+	if flushes != s.Flushes || compressions != s.Compressions || removed != s.LastRemoved {
+		t.Fatalf("hooks saw %d flushes, %d compressions, %d removed last; stats %+v", flushes, compressions, removed, s)
+	}
+	if flushed != s.Observations {
+		t.Fatalf("flushed batches add up to %d, want %d observations", flushed, s.Observations)
+	}
+}
+
+func TestPanickingHooks(t *testing.T) {
+	var flushes, compressions int
+	est := NewWithOptions([]Option{
+		WithOnFlush(func(int) {
+			flushes++
+			panic("flush hook")
+		}),
+		WithOnCompress(func(int, int) {
+			compressions++
+			panic("compress hook")
+		}),
+	})
+
+	for i := 0; i < 2000; i++ {
+		est.Add(float64(i))
+	}
+	est.Get(0.5)
+
+	if flushes != 1 || compressions != 1 {
+		t.Fatalf("want panicking hooks called once, got %d flushes and %d compressions", flushes, compressions)
+	}
+	if err := est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+	if est.Count() != 2000 || est.Get(1) != 1999 {
+		t.Fatalf("want all 2000 values up to 1999, got %d values up to %f", est.Count(), est.Get(1))
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	for _, size := range []int{0, 1, 128, 4096} {
+		est := NewWithOptions([]Option{WithBufferSize(size)}, Unknown(0.01))
+		for i := 0; i < 10000; i++ {
+			est.Add(float64(i))
+			if size <= 1 && len(est.buffer) != 0 {
+				t.Fatalf("size %d: want every value inserted, got %d buffered", size, len(est.buffer))
+			}
+		}
+		if size > 1 && est.Stats().Flushes != 10000/size {
+			t.Fatalf("size %d: want %d flushes, got %d", size, 10000/size, est.Stats().Flushes)
+		}
+		if est.Count() != 10000 || est.Get(0) != 0 || est.Get(1) != 9999 {
+			t.Fatalf("size %d: want 10000 values from 0 to 9999, got %d from %f to %f", size, est.Count(), est.Get(0), est.Get(1))
+		}
+
+		est.Reset()
+		if want := size; want > 1 && cap(est.buffer) != want {
+			t.Fatalf("size %d: want the buffer kept by Reset, got %d", size, cap(est.buffer))
+		}
+	}
+}
+
+func TestMaxItems(t *testing.T) {
+	const n, max = 10000000, 500
+	most := 0
+	est := NewWithOptions([]Option{
+		WithMaxItems(max),
+		WithOnCompress(func(before, after int) {
+			if after > most {
+				most = after
+			}
+		}),
+	}, Unknown(0.001))
+
+	// a ramp keeps every value with Unknown, as each ranks above all others
+	for i := 0; i < n; i++ {
+		est.Add(float64(i))
+	}
+	est.Get(0.5)
+
+	if most > max || len(est.items) > max {
+		t.Fatalf("want at most %d items, got %d and %d after the last compression", max, most, len(est.items))
+	}
+	if !est.Degraded() {
+		t.Fatal("want the forced merges reported")
+	}
+	if err := est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+	if est.Count() != n || est.Get(0) != 0 || est.Get(1) != n-1 {
+		t.Fatalf("want %d values from 0 to %d, got %d from %f to %f", n, n-1, est.Count(), est.Get(0), est.Get(1))
+	}
+
+	// the ramp's value i has rank i+1
+	for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99} {
+		v := est.Get(q)
+		if got, want := math.Abs(v+1-math.Ceil(q*n))/n, est.GuaranteedError(q); got > want {
+			t.Fatalf("q=%f: want rank error within %f, got %f of %f", q, want, got, v)
+		}
+	}
+
+	est.Reset()
+	if est.Degraded() {
+		t.Fatal("want Reset to clear the degradation")
+	}
+}
+
+func TestMaxItemsNonMonotone(t *testing.T) {
+	quantiles := []float64{0.001, 0.01, 0.1, 0.3, 0.5, 0.7, 0.9, 0.99, 0.999}
+	check := func(name string, est *Estimator, exact *quantiletest.Exact) {
+		t.Helper()
+		if !est.Degraded() {
+			t.Fatalf("%s: want the forced merges reported", name)
+		}
+		for _, q := range quantiles {
+			v := est.Get(q)
+			got := exact.RankError(q, v)
+			if want := est.GuaranteedError(q); got > want {
+				t.Errorf("%s q=%g: rank error %f of %g exceeds the guaranteed %f", name, q, got, v, want)
+			}
+			if want := est.ErrorAt(q) + quantiletest.RankSlack(exact.Samples()); got > want {
+				t.Errorf("%s q=%g: rank error %f of %g exceeds the achieved %f", name, q, got, v, want)
+			}
+		}
+	}
+
+	// a ramp up and back down leaves gaps from the forced merges of
+	// several flushes
+	for _, max := range []int{10, 20} {
+		est := NewWithOptions([]Option{WithMaxItems(max)}, Unknown(0.01))
+		exact := quantiletest.NewExact(nil)
+		for i := 0; i < 40000; i++ {
+			v := float64(i)
+			if i >= 20000 {
+				v = float64(39999 - i)
+			}
+			est.Add(v)
+			exact.Add(v)
+		}
+		check(fmt.Sprintf("up and down, max %d", max), est, exact)
+	}
+
+	// ramps in either direction at random offsets, and merged estimators
+	for seed := int64(0); seed < 24; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		max := 5 + r.Intn(40)
+		invariants := []Estimate{Known(0.5, 0.01), Known(0.99, 0.001)}
+		est := NewWithOptions([]Option{WithMaxItems(max)}, invariants...)
+		exact := quantiletest.NewExact(nil)
+		for ramp := 0; ramp < 4; ramp++ {
+			n, offset, down := 1000+r.Intn(10000), r.Float64()*1000, r.Intn(2) == 0
+			for i := 0; i < n; i++ {
+				v := offset + float64(i)
+				if down {
+					v = offset + float64(n-i)
+				}
+				est.Add(v)
+				exact.Add(v)
+			}
+			other := NewWithOptions([]Option{WithMaxItems(max)}, invariants...)
+			for _, v := range quantiletest.Sawtooth(5).Generate(3000, seed) {
+				other.Add(1000 * v)
+				exact.Add(1000 * v)
+			}
+			if err := est.Merge(other); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check(fmt.Sprintf("seed %d, max %d", seed, max), est, exact)
+	}
+}
+
+func TestMaxItemsUnreached(t *testing.T) {
+	est := NewWithOptions([]Option{WithMaxItems(10000)}, Known(0.5, 0.01), Known(0.99, 0.001))
+	for _, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		est.Add(v)
+	}
+	est.Get(0.5)
+	if est.Degraded() {
+		t.Fatalf("want no forced merges below the cap, got %d items", len(est.items))
+	}
+}
+
+func BenchmarkBufferSize(b *testing.B) {
+	debug = false
+	defer func() { debug = true }()
+	values := quantiletest.Normal(0, 1).Generate(1<<16, 1)
+
+	for _, size := range []int{128, 512, 4096} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			est := NewWithOptions([]Option{WithBufferSize(size)}, Known(0.5, 0.01), Known(0.99, 0.001))
+			for i := 0; i < b.N; i++ {
+				est.Add(values[i&(len(values)-1)])
+			}
+		})
+	}
+}
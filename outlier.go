@@ -0,0 +1,101 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+)
+
+// OutlierDetector flags values above a quantile of the values seen so far,
+// such as requests slower than the rolling p99.9.  It is not safe for
+// concurrent use.
+//
+// The threshold is estimated again after every flush of the estimator and
+// at least every outlierStaleness, 16, values, so after a shift of the
+// level at most 16 values more are flagged than the quantile of all values
+// checked would flag.
+type OutlierDetector struct {
+	// Warmup is the number of values the estimator must hold before any value
+	// is flagged.
+	Warmup int
+
+	// Factor is how many times the threshold quantile a value must exceed to
+	// be flagged, at least 1.  It assumes positive values such as latencies.
+	Factor float64
+
+	// Cooldown is the number of values after a flagged one that are not
+	// flagged, so a burst or a shift of the tail is reported once rather than
+	// for every value until the estimate catches up.
+	Cooldown int
+
+	est      *Estimator
+	quantile float64
+
+	threshold float64
+	flushes   int
+	estimated int64
+	current   bool
+	quiet     int
+
+	flagged int64
+	total   int64
+}
+
+// NewOutlierDetector flags values above the given quantile of est, which
+// should be accurate for that quantile.  Warmup defaults to the number of
+// values that puts ten above the quantile, Factor to 1 and Cooldown to 0.
+// The estimator must not be used directly afterwards.
+func NewOutlierDetector(est *Estimator, quantile float64) *OutlierDetector {
+	return &OutlierDetector{
+		Warmup:   int(math.Ceil(10 / (1 - quantile))),
+		Factor:   1,
+		est:      est,
+		quantile: quantile,
+	}
+}
+
+// Check reports whether v is an outlier compared to the values observed
+// before it, then observes v.
+func (d *OutlierDetector) Check(v float64) bool {
+	d.total++
+
+	outlier := false
+	if d.quiet > 0 {
+		d.quiet--
+	} else if d.est.Count() >= int64(d.Warmup) && v > math.Max(1, d.Factor)*d.limit() {
+		outlier = true
+		d.flagged++
+		d.quiet = d.Cooldown
+	}
+
+	d.est.Add(v)
+	return outlier
+}
+
+// outlierStaleness is the most values the threshold of an OutlierDetector
+// lags behind.
+const outlierStaleness = 16
+
+// limit returns the threshold quantile, estimated again only after the
+// estimator committed new values or outlierStaleness values were added, so
+// Check costs about as much as Add.
+func (d *OutlierDetector) limit() float64 {
+	if !d.current || d.est.flushes != d.flushes || d.est.Count()-d.estimated >= outlierStaleness {
+		d.threshold = d.est.Get(d.quantile)
+		d.flushes, d.estimated = d.est.flushes, d.est.Count()
+		d.current = true
+	}
+	return d.threshold
+}
+
+// Flagged returns the number of values reported as outliers.
+func (d *OutlierDetector) Flagged() int64 {
+	return d.flagged
+}
+
+// Total returns the number of values checked.
+func (d *OutlierDetector) Total() int64 {
+	return d.total
+}
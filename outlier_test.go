@@ -0,0 +1,131 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+// planted returns lognormal latencies with every period'th value replaced by
+// one a hundred times the p99.9, along with which values were planted.
+func planted(n, period int, seed int64) ([]float64, []bool) {
+	d := quantiletest.LogNormal(0, 0.5)
+	values := d.Generate(n, seed)
+	outliers := make([]bool, n)
+	for i := period - 1; i < n; i += period {
+		values[i] = 100 * d.Quantile(0.999)
+		outliers[i] = true
+	}
+	return values, outliers
+}
+
+func TestOutlierPrecisionRecall(t *testing.T) {
+	for seed := int64(0); seed < 5; seed++ {
+		values, outliers := planted(100000, 997, seed)
+		d := NewOutlierDetector(New(Known(0.999, 0.0001)), 0.999)
+		d.Factor = 2
+
+		var truePositive, falsePositive, falseNegative int
+		for i, v := range values {
+			switch flagged := d.Check(v); {
+			case flagged && outliers[i]:
+				truePositive++
+			case flagged:
+				falsePositive++
+			case outliers[i] && i >= d.Warmup:
+				falseNegative++
+			}
+		}
+
+		precision := float64(truePositive) / float64(truePositive+falsePositive)
+		recall := float64(truePositive) / float64(truePositive+falseNegative)
+		if precision < 0.95 || recall < 0.99 {
+			t.Errorf("seed %d: precision %f recall %f", seed, precision, recall)
+		}
+		if d.Total() != int64(len(values)) || d.Flagged() != int64(truePositive+falsePositive) {
+			t.Errorf("seed %d: counted %d of %d, want %d of %d",
+				seed, d.Flagged(), d.Total(), truePositive+falsePositive, len(values))
+		}
+	}
+}
+
+func TestOutlierWarmup(t *testing.T) {
+	values, _ := planted(20000, 997, 1)
+	d := NewOutlierDetector(New(Known(0.999, 0.0001)), 0.999)
+	if d.Warmup != 10000 {
+		t.Fatalf("want a warmup of 10000 for p99.9, got %d", d.Warmup)
+	}
+
+	for i, v := range values {
+		if d.Check(v) && i < d.Warmup {
+			t.Fatalf("flagged value %d during a warmup of %d", i, d.Warmup)
+		}
+	}
+	if d.Flagged() == 0 {
+		t.Fatal("want flags after the warmup")
+	}
+}
+
+func TestOutlierCooldown(t *testing.T) {
+	d := NewOutlierDetector(New(Known(0.99, 0.001)), 0.99)
+	d.Cooldown = 100
+	for i, v := range quantiletest.Exponential().Generate(100*d.Warmup, 1) {
+		if d.Check(v) && i < d.Warmup {
+			t.Fatal("flagged during the warmup")
+		}
+	}
+	flagged := d.Flagged()
+
+	// a burst of 250 outliers, too few to move the p99, is flagged once
+	// every 101 values
+	for i := 0; i < 250; i++ {
+		d.Check(1000)
+	}
+	if got := d.Flagged() - flagged; got != 3 {
+		t.Fatalf("want 3 flags for the burst, got %d", got)
+	}
+}
+
+func TestOutlierLevelShift(t *testing.T) {
+	for _, buffer := range []int{1, 512, 4096} {
+		d := NewOutlierDetector(NewWithOptions([]Option{WithBufferSize(buffer)}, Known(0.9, 0.01)), 0.9)
+		for i := 0; i < 100; i++ {
+			d.Check(1)
+		}
+		// the p90 of all values checked is 100 from the 12th value of 100 on
+		for i := 0; i < 300; i++ {
+			d.Check(100)
+		}
+		if got, most := d.Flagged(), int64(11+outlierStaleness); got == 0 || got > most {
+			t.Errorf("buffer %d: want between 1 and %d values of the new level flagged, got %d", buffer, most, got)
+		}
+	}
+}
+
+func TestOutlierTailShift(t *testing.T) {
+	before := quantiletest.LogNormal(0, 0.5).Generate(50000, 1)
+	after := quantiletest.LogNormal(2, 0.5).Generate(50000, 2)
+
+	flags := func(cooldown int) int64 {
+		d := NewOutlierDetector(New(Known(0.999, 0.0001)), 0.999)
+		d.Cooldown = cooldown
+		for _, v := range before {
+			d.Check(v)
+		}
+		flagged := d.Flagged()
+		for _, v := range after {
+			d.Check(v)
+		}
+		return d.Flagged() - flagged
+	}
+
+	// until the estimate catches up most shifted values exceed the old p99.9
+	without, with := flags(0), flags(1000)
+	if with*5 > without {
+		t.Fatalf("want the cool-down to suppress most flags of the shift, got %d without and %d with", without, with)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"sort"
+)
+
+// P2 estimates a single quantile in constant memory with the P² algorithm of
+// Jain and Chlamtac, "The P² Algorithm for Dynamic Calculation of Quantiles
+// and Histograms Without Storing Observations" (1985), for when even the
+// few hundred items of an Estimator are too many, such as per connection of
+// a proxy.  It is not safe for concurrent use.
+//
+// Five markers track the minimum, the quantile, the maximum and the
+// quantiles halfway between them.  Every Add moves the markers towards
+// their desired ranks along a parabola through their neighbors, so the
+// estimate is usually close on smooth distributions, but unlike Estimator
+// P2 guarantees no error at all: the order of the values, such as sorted or
+// clustered input, can pull it arbitrarily far from the true quantile.
+type P2 struct {
+	p float64
+
+	// marker heights, their ranks counted from 1, the ranks they should
+	// have and how far those advance per value
+	heights [5]float64
+	ranks   [5]float64
+	desired [5]float64
+	steps   [5]float64
+
+	count int64
+}
+
+// NewP2 allocates a P2 estimating the quantile q, which must be within
+// (0, 1) or NewP2 panics with an error wrapping ErrInvalidQuantile.
+func NewP2(q float64) *P2 {
+	if !(q > 0 && q < 1) {
+		panic(wrapf(ErrInvalidQuantile, "P2 of %g: quantile outside (0, 1)", q))
+	}
+	return &P2{
+		p:     q,
+		steps: [5]float64{0, q / 2, q, (1 + q) / 2, 1},
+	}
+}
+
+// Add adds a value.  NaN and infinite values would break the interpolation
+// of the markers and are dropped.
+func (e *P2) Add(value float64) {
+	if value != value || math.IsInf(value, 0) {
+		return
+	}
+
+	// the first five values are the markers
+	if e.count < 5 {
+		e.heights[e.count] = value
+		e.count++
+		if e.count == 5 {
+			sort.Float64s(e.heights[:])
+			p := e.p
+			e.ranks = [5]float64{1, 2, 3, 4, 5}
+			e.desired = [5]float64{1, 1 + 2*p, 1 + 4*p, 3 + 2*p, 5}
+		}
+		return
+	}
+	e.count++
+
+	// the cell of value, widening the extremes
+	var k int
+	switch {
+	case value < e.heights[0]:
+		e.heights[0] = value
+	case value >= e.heights[4]:
+		e.heights[4] = value
+		k = 3
+	default:
+		for k = 0; value >= e.heights[k+1]; k++ {
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		e.ranks[i]++
+	}
+	for i := range e.desired {
+		e.desired[i] += e.steps[i]
+	}
+
+	// the middle markers move one rank towards their desired ranks where
+	// they are off by a rank or more and would not run into a neighbor
+	for i := 1; i < 4; i++ {
+		d := e.desired[i] - e.ranks[i]
+		if d >= 1 && e.ranks[i+1]-e.ranks[i] > 1 || d <= -1 && e.ranks[i-1]-e.ranks[i] < -1 {
+			d = math.Copysign(1, d)
+			h := e.parabolic(i, d)
+			if !(e.heights[i-1] < h && h < e.heights[i+1]) {
+				h = e.linear(i, d)
+			}
+			e.heights[i] = h
+			e.ranks[i] += d
+		}
+	}
+}
+
+// parabolic is the height of marker i moved by d along the parabola through
+// its neighbors.
+func (e *P2) parabolic(i int, d float64) float64 {
+	h, n := &e.heights, &e.ranks
+	return h[i] + d/(n[i+1]-n[i-1])*
+		((n[i]-n[i-1]+d)*(h[i+1]-h[i])/(n[i+1]-n[i])+
+			(n[i+1]-n[i]-d)*(h[i]-h[i-1])/(n[i]-n[i-1]))
+}
+
+// linear is the height of marker i moved by d towards its neighbor, where
+// the parabola leaves the interval between them.
+func (e *P2) linear(i int, d float64) float64 {
+	j := i + int(d)
+	return e.heights[i] + d*(e.heights[j]-e.heights[i])/(e.ranks[j]-e.ranks[i])
+}
+
+// Get returns the estimate of the quantile of NewP2, the exact minimum and
+// maximum for 0 and 1, and other quantiles interpolated linearly between
+// the markers, which is coarse.  Of fewer than five values it returns the
+// exact order statistic like Estimator.Get.  Like Estimator.Get it returns
+// NaN without values or for a quantile outside [0, 1].
+func (e *P2) Get(quantile float64) float64 {
+	if e.count == 0 || !(quantile >= 0 && quantile <= 1) {
+		return math.NaN()
+	}
+	if e.count < 5 {
+		sorted := append([]float64(nil), e.heights[:e.count]...)
+		sort.Float64s(sorted)
+		return orderStatistic(sorted, quantile)
+	}
+	switch quantile {
+	case 0:
+		return e.heights[0]
+	case e.p:
+		return e.heights[2]
+	case 1:
+		return e.heights[4]
+	}
+
+	// the markers at the ranks around 1 + quantile·(n-1)
+	rank := 1 + quantile*float64(e.count-1)
+	i := 1
+	for i < 4 && e.ranks[i] < rank {
+		i++
+	}
+	lo, hi := i-1, i
+	return e.heights[lo] + (rank-e.ranks[lo])*(e.heights[hi]-e.heights[lo])/(e.ranks[hi]-e.ranks[lo])
+}
+
+// Quantile returns the quantile the estimator was allocated for.
+func (e *P2) Quantile() float64 {
+	return e.p
+}
+
+// Count returns the number of values added, not counting those dropped.
+func (e *P2) Count() int64 {
+	return e.count
+}
+
+// Reset discards the values added, keeping the quantile.
+func (e *P2) Reset() {
+	*e = *NewP2(e.p)
+}
@@ -0,0 +1,89 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+func TestP2Distributions(t *testing.T) {
+	for _, d := range []quantiletest.Distribution{quantiletest.Normal(0, 1), quantiletest.Exponential()} {
+		values := d.Generate(100000, 1)
+		exact := quantiletest.NewExact(append([]float64(nil), values...))
+		for _, q := range []float64{0.5, 0.9, 0.99} {
+			est := NewP2(q)
+			for _, v := range values {
+				est.Add(v)
+			}
+			// no guarantee, but well within a percent of the ranks on
+			// smooth distributions
+			if e := exact.RankError(q, est.Get(q)); e > 0.01 {
+				t.Errorf("%s q=%g: want a rank error within 0.01, got %g", d.Name, q, e)
+			}
+			if est.Get(0) != exact.Get(0) || est.Get(1) != exact.Get(1) {
+				t.Errorf("%s: want the exact extremes %f and %f, got %f and %f", d.Name, exact.Get(0), exact.Get(1), est.Get(0), est.Get(1))
+			}
+			if est.Count() != int64(len(values)) {
+				t.Errorf("%s: want a count of %d, got %d", d.Name, len(values), est.Count())
+			}
+		}
+	}
+}
+
+func TestP2Small(t *testing.T) {
+	est := NewP2(0.9)
+	if got := est.Get(0.9); !math.IsNaN(got) {
+		t.Fatalf("want NaN from an empty estimator, got %f", got)
+	}
+
+	// exact order statistics before the markers are placed
+	exact := quantiletest.NewExact(nil)
+	for _, v := range []float64{3, 1, math.NaN(), 4, math.Inf(1), 1} {
+		est.Add(v)
+		if v == v && !math.IsInf(v, 0) {
+			exact.Add(v)
+		}
+		for _, q := range []float64{0, 0.25, 0.5, 0.9, 1} {
+			if got, want := est.Get(q), exact.Get(q); got != want {
+				t.Fatalf("n=%d q=%g: want %f, got %f", exact.Samples(), q, want, got)
+			}
+		}
+	}
+	if est.Count() != 4 || !math.IsNaN(est.Get(2)) {
+		t.Fatalf("want the NaN and Inf dropped and NaN for the quantile 2, got %d and %f", est.Count(), est.Get(2))
+	}
+
+	est.Reset()
+	if est.Count() != 0 || est.Quantile() != 0.9 {
+		t.Fatalf("want an empty estimator of 0.9 after Reset, got %d of %g", est.Count(), est.Quantile())
+	}
+
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrInvalidQuantile) {
+			t.Fatalf("want a panic with ErrInvalidQuantile, got %v", err)
+		}
+	}()
+	NewP2(99)
+}
+
+func TestInterface(t *testing.T) {
+	values := quantiletest.Normal(0, 1).Generate(10000, 1)
+	exact := quantiletest.NewExact(append([]float64(nil), values...))
+	for _, est := range []Interface{New(Known(0.99, 0.001)), NewSafe(Known(0.99, 0.001)), NewP2(0.99)} {
+		for _, v := range values {
+			est.Add(v)
+		}
+		if est.Count() != int64(len(values)) {
+			t.Errorf("%T: want a count of %d, got %d", est, len(values), est.Count())
+		}
+		if e := exact.RankError(0.99, est.Get(0.99)); e > 0.01 {
+			t.Errorf("%T: want a rank error within 0.01, got %g", est, e)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+/*
+Package promadapter exposes quantile estimators to Prometheus as summaries.
+
+A Collector reports one summary per estimator, with the quantiles of its
+Known targets, the sum and the count of a snapshot taken on every scrape:
+
+	rpcs := quantile.NewSafe(quantile.Known(0.5, 0.01), quantile.Known(0.99, 0.001))
+	prometheus.MustRegister(promadapter.NewCollector(promadapter.Opts{
+		Name: "rpc_duration_seconds",
+		Help: "RPC latency.",
+	}, rpcs))
+
+Estimators are read only through Snapshot, so scrapes do not race with Add
+as long as the estimator is safe for concurrent use, like a
+quantile.SafeEstimator or quantile.Sharded.
+*/
+package promadapter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streadway/quantile"
+)
+
+// Snapshotter is an estimator a Collector can read, such as a
+// quantile.SafeEstimator or a quantile.Sharded.
+type Snapshotter interface {
+	Snapshot() *quantile.Snapshot
+}
+
+// Opts describes the summary reported by a Collector.
+type Opts struct {
+	// Name and Help are the metric name and help text, required.
+	Name string
+	Help string
+
+	// ConstLabels label every estimator of the Collector alike.
+	ConstLabels prometheus.Labels
+
+	// VariableLabels name the labels telling the estimators apart, whose
+	// values are given with each estimator.
+	VariableLabels []string
+
+	// Quantiles, when set, are reported for every estimator instead of the
+	// quantiles of its Known targets.
+	Quantiles []float64
+}
+
+// Collector is a prometheus.Collector reporting estimators as summaries.
+type Collector struct {
+	desc      *prometheus.Desc
+	quantiles []float64
+	labels    int
+
+	mu         sync.Mutex
+	estimators []labeled
+}
+
+// labeled is an estimator with the values of the variable labels.
+type labeled struct {
+	est    Snapshotter
+	values []string
+}
+
+// NewCollector returns a Collector reporting est with the label values,
+// one for each of the VariableLabels of opts.  It panics if the number of
+// values does not match, like prometheus.MustNewConstMetric.
+func NewCollector(opts Opts, est Snapshotter, labelValues ...string) *Collector {
+	c := &Collector{
+		desc:      prometheus.NewDesc(opts.Name, opts.Help, opts.VariableLabels, opts.ConstLabels),
+		quantiles: append([]float64(nil), opts.Quantiles...),
+		labels:    len(opts.VariableLabels),
+	}
+	if err := c.Add(est, labelValues...); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Add reports est as well, with the label values.  It returns an error if
+// the number of values does not match the VariableLabels.
+func (c *Collector) Add(est Snapshotter, labelValues ...string) error {
+	if len(labelValues) != c.labels {
+		return fmt.Errorf("promadapter: %d label values for %d variable labels", len(labelValues), c.labels)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.estimators = append(c.estimators, labeled{est: est, values: append([]string(nil), labelValues...)})
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector, reporting a snapshot of every
+// estimator.  Quantiles of an estimator without values are NaN.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	estimators := append([]labeled(nil), c.estimators...)
+	c.mu.Unlock()
+
+	for _, l := range estimators {
+		s := l.est.Snapshot()
+		quantiles := s.Estimates()
+		if len(c.quantiles) > 0 {
+			quantiles = make(map[float64]float64, len(c.quantiles))
+			for _, q := range c.quantiles {
+				quantiles[q] = s.Get(q)
+			}
+		}
+		ch <- prometheus.MustNewConstSummary(c.desc, uint64(s.Count()), s.Sum(), quantiles, l.values...)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package promadapter
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/streadway/quantile"
+)
+
+func TestCollectAndCompare(t *testing.T) {
+	get := quantile.NewSafe(quantile.Known(0.5, 0.01), quantile.Known(0.9, 0.01))
+	put := quantile.NewSafe(quantile.Known(0.5, 0.01), quantile.Known(0.9, 0.01))
+	for i := 1; i <= 10; i++ {
+		get.Add(float64(i))
+	}
+
+	c := NewCollector(Opts{
+		Name:           "rpc_duration_seconds",
+		Help:           "RPC latency.",
+		ConstLabels:    prometheus.Labels{"service": "api"},
+		VariableLabels: []string{"method"},
+	}, get, "GET")
+	if err := c.Add(put, "PUT"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `
+# HELP rpc_duration_seconds RPC latency.
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{method="GET",service="api",quantile="0.5"} 5
+rpc_duration_seconds{method="GET",service="api",quantile="0.9"} 9
+rpc_duration_seconds_sum{method="GET",service="api"} 55
+rpc_duration_seconds_count{method="GET",service="api"} 10
+rpc_duration_seconds{method="PUT",service="api",quantile="0.5"} NaN
+rpc_duration_seconds{method="PUT",service="api",quantile="0.9"} NaN
+rpc_duration_seconds_sum{method="PUT",service="api"} 0
+rpc_duration_seconds_count{method="PUT",service="api"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuantilesOverride(t *testing.T) {
+	est := quantile.NewSafe(quantile.Known(0.5, 0.01))
+	for i := 1; i <= 4; i++ {
+		est.Add(float64(i))
+	}
+	c := NewCollector(Opts{Name: "x", Help: "x", Quantiles: []float64{0.25, 1}}, est)
+
+	want := `
+# HELP x x
+# TYPE x summary
+x{quantile="0.25"} 1
+x{quantile="1"} 4
+x_sum 10
+x_count 4
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLabelValuesMismatch(t *testing.T) {
+	c := NewCollector(Opts{Name: "x", Help: "x", VariableLabels: []string{"a"}}, quantile.NewSafe(), "1")
+	if err := c.Add(quantile.NewSafe()); err == nil {
+		t.Fatal("want an error adding an estimator without label values")
+	}
+}
+
+func TestCollectWhileAdding(t *testing.T) {
+	est := quantile.NewSharded(4, quantile.Known(0.99, 0.001))
+	c := NewCollector(Opts{Name: "x", Help: "x", Quantiles: []float64{0.99}}, est)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				est.Add(float64(i))
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		testutil.CollectAndCount(c)
+	}
+	wg.Wait()
+
+	if n := testutil.CollectAndCount(c); n != 1 {
+		t.Fatalf("want 1 summary, got %d", n)
+	}
+}
@@ -15,6 +15,7 @@ package quantile
 
 import (
 	"math"
+	"reflect"
 	"sort"
 )
 
@@ -24,6 +25,22 @@ type Estimate interface {
 	Delta(rank, observations float64) float64
 }
 
+// Target is implemented by the estimates of this package and reports what
+// they were constructed with, such as for reporting every quantile an
+// estimator was configured for from its Targets.
+type Target interface {
+	Estimate
+
+	// Quantile returns the quantile of Known, or NaN for the estimates of
+	// every quantile: Unknown, LowBiased and HighBiased.
+	Quantile() float64
+
+	// Tolerance returns the tolerance passed to the constructor, before
+	// Known clamps it, or 0 for Known(0, ...) and Known(1, ...), which
+	// constrain nothing.
+	Tolerance() float64
+}
+
 type bias struct {
 	tolerance float64
 }
@@ -32,52 +49,176 @@ func (b bias) Delta(rank, observations float64) float64 {
 	return 2 * b.tolerance * rank
 }
 
+func (b bias) Quantile() float64  { return math.NaN() }
+func (b bias) Tolerance() float64 { return b.tolerance }
+
 // Unknown produces estimations for all possible quantiles at this error tolerance.
 // It uses significantly more space and time than when you know the quantiles
 // you wish to estimate.
 //
+// The tolerated error grows with the rank, ƒ(r,n) = 2·tolerance·r, which at
+// most reaches the 2·tolerance·n that every quantile needs.  Combined with
+// Known estimations the tighter of the two applies at each rank, so the
+// guarantees of both hold.
+//
 // The Known estimation should be used when you know which quantiles you will be
 // querying.
 func Unknown(tolerance float64) Estimate {
 	return bias{tolerance: tolerance}
 }
 
+// LowBiased produces the low-biased estimation of the paper, accurate to
+// tolerance relative to the rank: Get(q) ranks within q·tolerance·n of
+// q·n, so the lowest quantiles are the most accurate, and items are only
+// retained densely where the ranks are low.  It allows ƒ(r,n) = 2·tolerance·r
+// like Unknown, the same invariant under the name of its guarantee.
+func LowBiased(tolerance float64) Estimate {
+	return bias{tolerance: tolerance}
+}
+
+type highBias struct {
+	tolerance float64
+}
+
+func (b highBias) Delta(rank, observations float64) float64 {
+	return 2 * b.tolerance * (observations - rank)
+}
+
+func (b highBias) Quantile() float64  { return math.NaN() }
+func (b highBias) Tolerance() float64 { return b.tolerance }
+
+// HighBiased produces the high-biased estimation, the mirror of LowBiased:
+// Get(q) ranks within (1-q)·tolerance·n of q·n, so every high quantile, such
+// as 0.999 or 0.9995, is accurate relative to its distance from the maximum
+// without a Known estimation for each.  It allows ƒ(r,n) = 2·tolerance·(n-r).
+func HighBiased(tolerance float64) Estimate {
+	return highBias{tolerance: tolerance}
+}
+
 type target struct {
 	q  float64 // targeted quantile
 	f1 float64 // cached coefficient for fi  q*n <= rank <= n
 	f2 float64 // cached coefficient for fii 0 <= rank <= q*n
+
+	// tolerance passed to Known when it was clamped, otherwise 0
+	requested float64
+	tolerance float64
 }
 
 func (t target) Delta(rank, observations float64) float64 {
+	// Targeting the minimum or maximum constrains nothing beyond keeping the
+	// extreme itself, which the estimator always does.
+	if t.q <= 0 || t.q >= 1 {
+		return observations + 1
+	}
+
 	if rank <= math.Floor(t.q*observations) {
 		return t.f2 * (observations - rank)
 	}
 	return t.f1 * rank
 }
 
+func (t target) Quantile() float64 { return t.q }
+
+func (t target) Tolerance() float64 {
+	if t.requested != 0 {
+		return t.requested
+	}
+	return t.tolerance
+}
+
 // Known produces a optimal space usage for estimations at the given quantile and error tolerance.
 //
 // Quantiles not known ahead of time can also be queried, but at a lower accuracy.
+//
+// Known(0, tolerance) and Known(1, tolerance) target the exact minimum and
+// maximum, which are always retained, and add no further constraint.
+//
+// A tolerance reaching past the minimum or maximum, beyond min(quantile,
+// 1-quantile), is clamped to that distance.  Beyond it the allowance above
+// a low target or below a high one grows without bound and compression
+// discards the tail the target is in.  Warnings reports clamped estimates.
 func Known(quantile, tolerance float64) Estimate {
+	if quantile <= 0 || quantile >= 1 {
+		return target{q: quantile}
+	}
+
+	var requested float64
+	if limit := math.Min(quantile, 1-quantile); tolerance > limit {
+		requested, tolerance = tolerance, limit
+	}
+
 	return target{
-		q:  quantile,
-		f1: 2 * tolerance / quantile,
-		f2: 2 * tolerance / (1 - quantile),
+		q:         quantile,
+		f1:        2 * tolerance / quantile,
+		f2:        2 * tolerance / (1 - quantile),
+		requested: requested,
+		tolerance: tolerance,
+	}
+}
+
+// checkEstimate reports an error wrapping ErrInvalidQuantile or
+// ErrInvalidEpsilon if f cannot be honored.  Estimates defined outside the
+// package are not checked.
+func checkEstimate(f Estimate) error {
+	var tolerance float64
+	switch f := f.(type) {
+	case bias:
+		tolerance = f.tolerance
+	case highBias:
+		tolerance = f.tolerance
+	case target:
+		if !(f.q >= 0 && f.q <= 1) {
+			return wrapf(ErrInvalidQuantile, "Known(%g, ...): quantile outside [0, 1]", f.q)
+		}
+		if f.q == 0 || f.q == 1 {
+			// the extremes, whose tolerance is unused
+			return nil
+		}
+		tolerance = f.tolerance
+		if f.requested != 0 {
+			tolerance = f.requested
+		}
+	default:
+		return nil
+	}
+	if !(tolerance > 0 && tolerance < 0.5) {
+		return wrapf(ErrInvalidEpsilon, "%s: tolerance outside (0, 0.5)", describe(f))
 	}
+	return nil
 }
 
-// the tuple and list element
+// a value added with a weight, buffered until the next flush
+type weightedValue struct {
+	v, w float64
+}
+
+// the tuple of the summary
 type item struct {
 	v     float64
 	rank  float64
 	delta float64
-	next  *item
+
+	// observations of exactly v counted in rank, all ranking above any
+	// value inserted before the item
+	copies float64
+}
+
+// Interface is what the estimators of this package have in common, so that
+// callers can choose between them, such as an Estimator or the constant
+// memory P2, without changing their code.
+type Interface interface {
+	Add(value float64)
+	Get(quantile float64) float64
+	Count() int64
 }
 
 type Estimator struct {
-	// linked list data structure "S", bookeeping in observe/recycle
-	head  *item
-	items int
+	// data structure "S", the items in ascending order of value, and the
+	// storage update and Merge build the next summary in, swapped after
+	// every flush so that neither allocates once grown
+	items []item
+	spare []item
 
 	// float64 avoids conversion during invariant checks
 	observations float64
@@ -85,15 +226,50 @@ type Estimator struct {
 	// used to calculate ƒ(r,n)
 	invariants []Estimate
 
-	// batching of updates
-	buffer []float64
+	// batching of updates, and of values added with a weight along with
+	// their total weight
+	buffer   []float64
+	weighted []weightedValue
+	pending  float64
+
+	// exact extremes of the observed values, valid while Count is not 0,
+	// and their sum
+	min float64
+	max float64
+	sum float64
+
+	// non-finite values seen by Add
+	nans      int
+	infs      int
+	infPolicy InfPolicy
 
-	// free list
-	pool chan *item
+	// reported by Stats, since construction or the last Reset
+	flushes      int
+	compressions int
+	removed      int
+	ratio        float64
+
+	// set by WithOnFlush and WithOnCompress
+	onFlush    func(batchSize int)
+	onCompress func(before, after int)
+
+	// set by WithHoldout
+	holdout *holdout
+
+	// set by WithMaxItems, and the widest g + Δ kept once compress had to
+	// force merges, as a fraction of the observations at the time
+	maxItems    int
+	degradation float64
+
+	// ƒ for the observations of the current compression
+	bounds bounds
 }
 
 var defaultInvariants = []Estimate{Unknown(0.1)}
 
+// capacity of the buffer of New
+const bufferSize = 512
+
 // New allocates a new estimator tolerating the minimum of the invariants provided.
 //
 // When you know how much error you can tolerate in the quantiles you will
@@ -113,6 +289,8 @@ var defaultInvariants = []Estimate{Unknown(0.1)}
 //
 //   quantile.New(quantile.Unknown(0.1))
 //
+// The zero Estimator is usable and behaves the same way.
+//
 // Estimators are not safe to use from multiple goroutines.
 func New(invariants ...Estimate) *Estimator {
 	if len(invariants) == 0 {
@@ -121,57 +299,1084 @@ func New(invariants ...Estimate) *Estimator {
 
 	return &Estimator{
 		invariants: invariants,
-		buffer:     make([]float64, 0, 512),
-		pool:       make(chan *item, 1024),
+		buffer:     make([]float64, 0, bufferSize),
 	}
 }
 
+// NewChecked allocates an estimator like New, but first checks the
+// invariants, for those built from configuration or user input.  Known
+// quantiles must be within [0, 1], 0 and 1 targeting the exact minimum and
+// maximum, and tolerances within (0, 0.5), otherwise the error wraps
+// ErrInvalidQuantile or ErrInvalidEpsilon.  New accepts such invariants and
+// returns nonsense.  Known tolerances clamped as described there are
+// accepted and reported by Warnings, and no invariants at all select the
+// default, as for New.
+func NewChecked(invariants ...Estimate) (*Estimator, error) {
+	for _, f := range invariants {
+		if err := checkEstimate(f); err != nil {
+			return nil, err
+		}
+	}
+	return New(invariants...), nil
+}
+
 // Add buffers a new sample, committing and compressing the data structure
 // when the buffer is full.
+//
+// NaN values have no rank and are dropped, counted by NaNCount.  Infinite
+// values are counted by InfCount and handled according to the InfPolicy.
 func (est *Estimator) Add(value float64) {
+	if !est.accept(value) {
+		return
+	}
+
 	est.buffer = append(est.buffer, value)
-	if len(est.buffer) == cap(est.buffer) {
+	if len(est.buffer)+len(est.weighted) >= cap(est.buffer) {
+		est.flush()
+	}
+}
+
+// AddBatch adds values like as many calls to Add, after flushing the values
+// already buffered, but sorts them at once and merges their summary like
+// Merge instead of a buffer at a time.  Values is copied, not retained or
+// modified.
+//
+// The sorted values rank exactly, so their summary keeps only the values the
+// invariants require, and the batch allocates in proportion to the items
+// rather than the values.
+func (est *Estimator) AddBatch(values []float64) {
+	est.flush()
+
+	batch := make([]float64, 0, len(values))
+	for _, v := range values {
+		if est.accept(v) {
+			batch = append(batch, v)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	sort.Float64s(batch)
+	est.flushes++
+	// the summary has the same invariants, so this cannot fail
+	est.Merge(est.summarize(batch))
+	est.flushed(len(batch))
+}
+
+// AddSorted adds values in ascending order like as many calls to Add, after
+// flushing the values already buffered, but merges them into the summary at
+// once without sorting them, for batches that arrive sorted such as from a
+// merge.  Values out of order are detected and sorted in a copy, at the cost
+// of a sort.  Values is not retained or modified.
+func (est *Estimator) AddSorted(values []float64) {
+	est.flush()
+
+	// values are merged as they are unless some are dropped
+	batch, copied := values, false
+	for i, v := range values {
+		switch {
+		case !est.accept(v):
+			if !copied {
+				batch, copied = append(make([]float64, 0, len(values)), values[:i]...), true
+			}
+		case copied:
+			batch = append(batch, v)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	if !sort.Float64sAreSorted(batch) {
+		if !copied {
+			batch = append([]float64(nil), batch...)
+		}
+		sort.Float64s(batch)
+	}
+	est.commitSorted(batch, nil)
+}
+
+// summarize returns an estimator summarizing the sorted batch.  The runs of
+// the minimum and maximum are items of their own, and the values between
+// them are grouped into the widest items compress would merge.  Ranks in the
+// batch are exact, so every delta is 0.
+func (est *Estimator) summarize(batch []float64) *Estimator {
+	n := float64(len(batch))
+	other := &Estimator{invariants: est.invariants, observations: n}
+	other.bounds.reset(other.estimates(), n)
+
+	// start is the index of the first value not yet in an item, the number
+	// of values ranking below it
+	start := 0
+	cut := func(end int) {
+		v := batch[end-1]
+		copies := 1
+		for j := end - 2; j >= start && batch[j] == v; j-- {
+			copies++
+		}
+		other.items = append(other.items, item{v: v, rank: float64(end - start), copies: float64(copies)})
+		start = end
+	}
+
+	// the last run
+	last := len(batch) - 1
+	for last > 0 && batch[last-1] == batch[last] {
+		last--
+	}
+
+	// group ends after a run, at the latest that fits the invariant
+	group := 0
+	for end := 1; end <= last; end++ {
+		if end < last && batch[end] == batch[end-1] {
+			continue
+		}
+		switch width := float64(end - start); {
+		case start == 0:
+			cut(end)
+		case width <= other.bounds.over(float64(start), width):
+			group = end
+		default:
+			if group > start {
+				cut(group)
+			}
+			// a run wider than the invariant is an item of its own
+			group = end
+			if width := float64(end - start); width > other.bounds.over(float64(start), width) {
+				cut(end)
+			}
+		}
+	}
+	if group > start {
+		cut(group)
+	}
+	cut(len(batch))
+
+	other.min, other.max = other.items[0].v, other.items[len(other.items)-1].v
+	return other
+}
+
+// accept counts value towards the statistics kept besides the summary, and
+// reports whether it is to be inserted.
+func (est *Estimator) accept(value float64) bool {
+	if value != value {
+		est.nans++
+		return false
+	}
+
+	if math.IsInf(value, 0) {
+		est.infs++
+		if est.infPolicy == DropInf {
+			return false
+		}
+	}
+
+	if est.holdout != nil {
+		est.holdout.add(value)
+	}
+
+	est.sum += value
+	est.extend(value)
+	return true
+}
+
+// AddWeighted buffers value with the weight of weight observations, such as
+// a value of pre-aggregated data and its count.  Its rank is counted as
+// weight in the summary, so the invariants bound errors in ranks of
+// weighted observations.  Weights that are not positive and finite return
+// ErrInvalidWeight, as do weights that would take the observations to 2^63,
+// which Count cannot report.
+//
+// NaN and infinite values are handled like by Add.  Weighted values are not
+// part of the holdout sample.
+func (est *Estimator) AddWeighted(value, weight float64) error {
+	if !(weight > 0) || math.IsInf(weight, 0) {
+		return wrapf(ErrInvalidWeight, "weight %g of %g", weight, value)
+	}
+	if value != value {
+		est.nans++
+		return nil
+	}
+	if math.IsInf(value, 0) {
+		est.infs++
+		if est.infPolicy == DropInf {
+			return nil
+		}
+	}
+	if total := est.weight() + weight; total >= maxObservations {
+		return wrapf(ErrInvalidWeight, "weight %g of %g takes the observations to %g", weight, value, total)
+	}
+
+	est.addWeighted(value, weight)
+	return nil
+}
+
+// AddN adds n observations of value, like n calls to Add, but buffers them
+// as a single entry that the summary inserts as one item of width n, for
+// values repeated in bulk such as the sizes of an access log.  Count grows
+// by exactly n, NaNCount or InfCount by n for such values, and the
+// observations are not part of the holdout sample.  Counts up to 2^53 are
+// exact.
+//
+// Count is an int64, so n is capped at the observations it can still
+// report, which stop short of 2^63 at 2^63-1024.  Once full, AddN adds
+// nothing.  NaNCount and InfCount saturate at math.MaxInt likewise.
+func (est *Estimator) AddN(value float64, n uint64) {
+	switch {
+	case n == 0:
+		return
+	case n == 1:
+		est.Add(value)
+		return
+	case value != value:
+		est.nans = saturatingAdd(est.nans, n)
+		return
+	case math.IsInf(value, 0):
+		est.infs = saturatingAdd(est.infs, n)
+		if est.infPolicy == DropInf {
+			return
+		}
+	}
+
+	if weight := math.Min(float64(n), maxWeight-est.weight()); weight > 0 {
+		est.addWeighted(value, weight)
+	}
+}
+
+// saturatingAdd adds n to count, or returns math.MaxInt if the sum would
+// overflow.
+func saturatingAdd(count int, n uint64) int {
+	if n > uint64(math.MaxInt-count) {
+		return math.MaxInt
+	}
+	return count + int(n)
+}
+
+// addWeighted buffers an accepted value with its weight.
+func (est *Estimator) addWeighted(value, weight float64) {
+	est.sum += value * weight
+	est.extend(value)
+
+	est.weighted = append(est.weighted, weightedValue{v: value, w: weight})
+	est.pending += weight
+	if len(est.buffer)+len(est.weighted) >= cap(est.buffer) {
 		est.flush()
 	}
 }
 
+// extend widens the extremes to an added value.
+func (est *Estimator) extend(value float64) {
+	if est.weight() == 0 {
+		est.min, est.max = value, value
+	} else if value < est.min {
+		est.min = value
+	} else if value > est.max {
+		est.max = value
+	}
+}
+
+// weight is the weight of the observations, including those still buffered.
+func (est *Estimator) weight() float64 {
+	return est.observations + est.pending + float64(len(est.buffer))
+}
+
+// Flush merges the buffered values into the summary and compresses it, as
+// Get would, so that a later Get does not pay for it.  Without buffered
+// values it does nothing.
+func (est *Estimator) Flush() {
+	est.flush()
+}
+
 // Get finds a value within (quantile - tolerance) * n <= value <= (quantile + tolerance) * n
-// or 0 if no values have been observed.
+// or NaN if no values have been observed since construction or the last
+// Reset, so that no data is not mistaken for an estimate of 0.  A quantile
+// outside [0, 1] or NaN, such as the percentage 99 for 0.99, is NaN as well
+// rather than the minimum or maximum.  GetErr tells the cases apart.
+//
+// The quantile is the value at rank ⌈quantile·n⌉, so where a run of equal
+// values ends, Get(k/n) at its last rank k returns the run's value and the
+// next rank the following value.  Ranks are whole numbers compared exactly,
+// and quantile·n within floating point error of a whole rank is that rank,
+// so Get(0.07) of 100 values targets the 7th.  Get(0) and Get(1) return
+// the exact minimum and maximum, and while the invariants allow no error at
+// any rank, as for fewer than 1/(2·tolerance) values with Unknown, Get
+// returns the exact order statistic.
+//
+// Until the buffer first fills, Get keeps the values in it, sorts them and
+// returns the exact order statistic whatever the invariants.  The summary
+// starts with the first flush, by Add on a full buffer or otherwise, which
+// seeds it with the sorted values.
+//
+// Without intervening Adds, Get is non-decreasing in quantile: the rank it
+// searches up to, quantile * n plus half the invariant there, only falls
+// where it already exceeds n.
 func (est *Estimator) Get(quantile float64) float64 {
-	if est.observations == 0 && len(est.buffer) == 0 {
-		return 0
+	if est.weight() == 0 {
+		return math.NaN()
+	}
+	if est.exact() {
+		sort.Float64s(est.buffer)
+		return orderStatistic(est.buffer, quantile)
+	}
+
+	est.flush()
+	if len(est.items) == 0 {
+		return math.NaN()
+	}
+
+	v, _ := est.query(quantile, cursor{})
+	return v
+}
+
+// exact reports whether the values are all still buffered, with no summary
+// yet, so that queries answer exactly from the buffer instead of flushing.
+func (est *Estimator) exact() bool {
+	return len(est.items) == 0 && len(est.weighted) == 0 && len(est.buffer) > 0
+}
+
+// orderStatistic returns the value at rank ⌈quantile·n⌉ of n sorted values,
+// the minimum for 0, or NaN for a quantile outside [0, 1] as for query.
+func orderStatistic(sorted []float64, quantile float64) float64 {
+	if !(quantile >= 0 && quantile <= 1) {
+		return math.NaN()
+	}
+	r := quantileRank(quantile, float64(len(sorted)))
+	return sorted[int(math.Max(r, 1))-1]
+}
+
+// GetWithBounds returns the estimate of Get with an interval guaranteed to
+// hold it as well as the exact value at rank ⌈quantile·n⌉: lo and hi are
+// the retained values bracketing the ranks within GuaranteedError of that
+// rank.  For alerting, lo exceeding a threshold means the quantile surely
+// does, whatever the error of the estimate.  All three are NaN if no values
+// have been observed.
+//
+// At most an item's upper rank bound less its copies precede its value, so
+// it is at or below the values of higher ranks, and at least its lower rank
+// bound are at or below it.
+func (est *Estimator) GetWithBounds(quantile float64) (v, lo, hi float64) {
+	if est.weight() == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	if est.exact() {
+		v = est.Get(quantile)
+		return v, v, v
+	}
+
+	est.flush()
+	items := est.items
+	if len(items) == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	v, _ = est.query(quantile, cursor{})
+	if v != v {
+		return v, v, v
+	}
+
+	n := est.observations
+	midrank := quantileRank(quantile, n)
+	e := est.GuaranteedError(quantile) * n
+	lorank := math.Min(math.Max(midrank-e, 1), n)
+	hirank := math.Min(midrank+e, n)
+
+	lo, hi = items[0].v, items[len(items)-1].v
+	rank := 0.0
+	for i := range items {
+		cur := &items[i]
+		rank += cur.rank
+		if rank+cur.delta-cur.copies < lorank {
+			lo = cur.v
+		}
+		if rank >= hirank {
+			hi = cur.v
+			break
+		}
+	}
+	return v, lo, hi
+}
+
+// GetErr is like Get but returns an error instead of NaN: one wrapping
+// ErrInvalidQuantile for a quantile outside [0, 1], or ErrNoSamples if no
+// values have been observed.
+func (est *Estimator) GetErr(quantile float64) (float64, error) {
+	if !(quantile >= 0 && quantile <= 1) {
+		return math.NaN(), wrapf(ErrInvalidQuantile, "%g", quantile)
+	}
+	if est.weight() == 0 {
+		return math.NaN(), wrapf(ErrNoSamples, "estimating quantile %g", quantile)
+	}
+	return est.Get(quantile), nil
+}
+
+// Peek returns the estimate of Get without modifying the estimator, so
+// that it can be called concurrently with other Peeks, such as under the
+// read lock of a sync.RWMutex.  Buffered values are merged into a copy of
+// the summary, which costs a copy of the items and a sort of the buffer on
+// every call, so Peek is slower than Get unless the buffer is empty.
+func (est *Estimator) Peek(quantile float64) float64 {
+	if est.weight() == 0 {
+		return math.NaN()
+	}
+	if est.exact() {
+		sorted := append([]float64(nil), est.buffer...)
+		sort.Float64s(sorted)
+		return orderStatistic(sorted, quantile)
+	}
+
+	if len(est.buffer) == 0 && len(est.weighted) == 0 {
+		if len(est.items) == 0 {
+			return math.NaN()
+		}
+		v, _ := est.query(quantile, cursor{})
+		return v
+	}
+
+	c := est.clone()
+	c.commit(append([]float64(nil), est.buffer...), append([]weightedValue(nil), est.weighted...))
+	v, _ := c.query(quantile, cursor{})
+	return v
+}
+
+// GetAll returns the estimates of Get for every quantile, in the order
+// given, flushing once and walking the summary once in ascending order of
+// quantile.  Like Get, the estimates are NaN if no values have been observed.
+func (est *Estimator) GetAll(quantiles ...float64) []float64 {
+	values := make([]float64, len(quantiles))
+	if est.weight() == 0 {
+		return fillNaN(values)
+	}
+	if est.exact() {
+		sort.Float64s(est.buffer)
+		for i, q := range quantiles {
+			values[i] = orderStatistic(est.buffer, q)
+		}
+		return values
 	}
 
 	est.flush()
+	if len(est.items) == 0 {
+		return fillNaN(values)
+	}
+
+	// a few quantiles, in ascending order with NaN first, which is walked
+	// on its own
+	order := make([]int, len(quantiles))
+	for i, q := range quantiles {
+		j := i
+		for ; j > 0; j-- {
+			if p := quantiles[order[j-1]]; !(q < p || q != q && p == p) {
+				break
+			}
+			order[j] = order[j-1]
+		}
+		order[j] = i
+	}
+
+	var start cursor
+	for _, i := range order {
+		if q := quantiles[i]; q != q {
+			values[i], _ = est.query(q, start)
+		} else {
+			values[i], start = est.query(q, start)
+		}
+	}
+	return values
+}
+
+// fillNaN sets every value to NaN, the estimate of no observations.
+func fillNaN(values []float64) []float64 {
+	for i := range values {
+		values[i] = math.NaN()
+	}
+	return values
+}
+
+// cursor is a position in the summary: the index of an item and the widths
+// of the items before it.
+type cursor struct {
+	i    int
+	rank float64
+}
+
+// query walks the summary from c for the estimate of quantile, and returns
+// it with the position before the one it stopped at.  As Get is
+// non-decreasing in quantile and values are distinct, the walk of a higher
+// quantile does not stop before that position, so it can start there.
+func (est *Estimator) query(quantile float64, c cursor) (float64, cursor) {
+	items := est.items
+
+	// most likely a percentage or an uninitialized value, which the
+	// minimum or maximum would hide
+	if !(quantile >= 0 && quantile <= 1) {
+		return math.NaN(), c
+	}
 
-	cur := est.head
-	if cur == nil {
-		return 0
+	// the minimum is retained exactly
+	if quantile == 0 {
+		return items[0].v, c
 	}
 
-	midrank := math.Floor(quantile * est.observations)
+	// The quantile is the value at rank ⌈quantile·n⌉, and the paper's
+	// rmax > midrank + ƒ/2 is the same test on whole ranks as
+	// rmax > midrank + ⌊ƒ/2⌋.
+	midrank := quantileRank(quantile, est.observations)
 	maxrank := midrank + math.Floor(est.invariant(midrank, est.observations)/2)
 
+	// An item holding a run of equal values can be wider than the invariant,
+	// in which case its predecessor may rank entirely below the tolerated
+	// window and the run's value is the better answer.
+	minrank := midrank - (maxrank - midrank)
+
+	// Every item within the window is an answer.  The paper returns the last
+	// one before an upper bound past maxrank, which drifts towards whichever
+	// end of the window the deltas leave room for.  Lower bounds track the
+	// true ranks closely, so the item whose lower bound is nearest midrank is
+	// returned instead.  No value ranks above n, so a window reaching n
+	// extends to the maximum.
+	//
+	// The copies of an item's value occupy the ranks just below its bound,
+	// so the successor is measured from its first copy.  Copies decayed to
+	// less than one observation occupy the bound.  Ties then resolve
+	// exactly where the summary knows the ranks of a run: rank ⌈quantile·n⌉
+	// answers with the value occupying it, the lower value at a boundary.
+	rank := c.rank
+	back := c
+	i := c.i
+	for ; i+1 < len(items); i++ {
+		cur, next := &items[i], &items[i+1]
+		at := cursor{i: i, rank: rank}
+		rank += cur.rank
+		nextrank := rank + next.rank
+		first := nextrank - next.copies + math.Min(1, next.copies)
+		if nextrank >= midrank && first-midrank >= midrank-rank && rank+cur.delta >= minrank {
+			return cur.v, back
+		}
+		if math.Min(first+next.delta, est.observations) > maxrank {
+			if rank+cur.delta < minrank {
+				return next.v, back
+			}
+			return cur.v, back
+		}
+		back = at
+	}
+	return items[i].v, back
+}
+
+// quantileRank is ⌈quantile·n⌉.  The product carries the error of
+// quantile's binary representation, which puts 0.07·100 above 7, so a
+// product within that error of a whole rank is that rank.
+func quantileRank(quantile, n float64) float64 {
+	r := quantile * n
+	if whole := math.Round(r); math.Abs(r-whole) <= n*0x1p-52 {
+		return whole
+	}
+	return math.Ceil(r)
+}
+
+// GuaranteedError returns the rank error, as a fraction of the observations,
+// that Get(quantile) is guaranteed to stay within, or NaN if no values have
+// been observed.  It is the configured tolerance at targeted quantiles and
+// grows for quantiles the invariants were not chosen for.
+func (est *Estimator) GuaranteedError(quantile float64) float64 {
+	n := est.weight()
+	if n == 0 {
+		return math.NaN()
+	}
+
+	// half the invariant around the rank Get searches for, plus the rank
+	// added by rounding quantile * n up, or the widest item forced by
+	// WithMaxItems: Get may answer from either end of an item wider than
+	// the invariant
+	midrank := quantileRank(quantile, n)
+	return math.Max((math.Floor(est.invariant(midrank, n)/2)+1)/n, est.degradation+1/n)
+}
+
+// ErrorAt flushes the estimator and returns the rank error, as a fraction of
+// the observations, that the summary achieves at quantile: half the widest
+// gap g + Δ of the items whose rank range covers ⌈quantile·n⌉, the
+// effective tolerance there, or the whole gap of items wider than the
+// invariant, which Get may answer from either end of.  Unlike
+// GuaranteedError, which follows from the invariants, it measures the
+// items, so it shows the error left after WithMaxItems forced merges.  It
+// is NaN if no values have been observed.
+func (est *Estimator) ErrorAt(quantile float64) float64 {
+	if est.weight() == 0 {
+		return math.NaN()
+	}
+
+	est.flush()
+	n := est.observations
+	midrank := quantileRank(quantile, n)
+	invariant := est.invariant(midrank, n)
+	worst, rank := 0.0, 0.0
+	for i := range est.items {
+		cur := &est.items[i]
+		if rank > midrank {
+			break
+		}
+		// the item ranks in (rank, rank+g+Δ]
+		if width := cur.rank + cur.delta; rank+width >= midrank {
+			if width > invariant {
+				width *= 2
+			}
+			worst = math.Max(worst, width)
+		}
+		rank += cur.rank
+	}
+	return worst / (2 * n)
+}
+
+// Estimates returns Get for the quantile of every Known target, keyed by the
+// quantile exactly as it was passed to Known, such as for an exporter
+// emitting the quantiles the estimator was built for.  Like GetAll it
+// flushes once and walks the summary once.  Without Known targets the map
+// is empty, and like Get the estimates are NaN if no values have been
+// observed.
+func (est *Estimator) Estimates() map[float64]float64 {
+	var quantiles []float64
+	for _, f := range est.estimates() {
+		if t, ok := f.(target); ok {
+			quantiles = append(quantiles, t.q)
+		}
+	}
+
+	estimates := make(map[float64]float64, len(quantiles))
+	for i, v := range est.GetAll(quantiles...) {
+		estimates[quantiles[i]] = v
+	}
+	return estimates
+}
+
+// Errors returns ErrorAt for the quantile of every Known target, keyed by
+// the quantile.
+func (est *Estimator) Errors() map[float64]float64 {
+	achieved := make(map[float64]float64)
+	for _, f := range est.estimates() {
+		if t, ok := f.(target); ok {
+			achieved[t.q] = est.ErrorAt(t.q)
+		}
+	}
+	return achieved
+}
+
+// Degraded reports whether the cap of WithMaxItems merged items beyond the
+// invariants since construction or the last Reset, so that estimates may be
+// off by more than the configured tolerance.
+func (est *Estimator) Degraded() bool {
+	return est.degradation > 0
+}
+
+// GetOK is like Get but reports whether any values have been observed, so
+// that an empty estimator can be told apart without testing for NaN.
+func (est *Estimator) GetOK(quantile float64) (float64, bool) {
+	if est.weight() == 0 {
+		return math.NaN(), false
+	}
+	return est.Get(quantile), true
+}
+
+// CDF flushes the estimator and returns the estimated fraction of the
+// observations at or below v, the inverse of Get.  It is 0 below the
+// minimum, 1 from the maximum on and NaN if no values have been observed or
+// v is NaN.
+//
+// The observations at or below v number between the rank of the last item
+// at or below v and the highest rank of its successor without the
+// successor's copies.  The invariant bounds that range, so the midpoint
+// returned is within the error Get tolerates at that rank.
+func (est *Estimator) CDF(v float64) float64 {
+	if est.weight() == 0 || v != v {
+		return math.NaN()
+	}
+
+	est.flush()
 	rank := 0.0
-	for cur.next != nil {
+	for i := range est.items {
+		cur := &est.items[i]
+		if cur.v > v {
+			// between the last item at or below v and this one
+			hi := rank + cur.rank + cur.delta - cur.copies
+			return (rank + math.Max(rank, hi)) / 2 / est.observations
+		}
 		rank += cur.rank
-		if rank+cur.next.rank+cur.next.delta > maxrank {
-			return cur.v
+	}
+	return 1
+}
+
+// Histogram flushes the buffer and apportions the observations to buckets:
+// bucket i counts those above bounds[i-1] and at or below bounds[i], like
+// Prometheus' le buckets, and a last bucket those above every bound.  The
+// counts add up to Count.  Bounds must be increasing, otherwise the error
+// wraps ErrInvalidBounds.
+//
+// The observations at or below each bound are estimated like CDF, so a
+// bucket count inherits the rank error the invariants allow at both of its
+// bounds.  A value exactly on a bound always counts below it.
+func (est *Estimator) Histogram(bounds []float64) ([]uint64, error) {
+	if len(bounds) == 0 {
+		return nil, wrapf(ErrInvalidBounds, "no bounds")
+	}
+	for i, b := range bounds {
+		switch {
+		case b != b:
+			return nil, wrapf(ErrInvalidBounds, "bound %d is NaN", i)
+		case i > 0 && !(b > bounds[i-1]):
+			return nil, wrapf(ErrInvalidBounds, "bound %d of %g after %g", i, b, bounds[i-1])
+		}
+	}
+
+	est.flush()
+	count := uint64(est.Count())
+	counts := make([]uint64, len(bounds)+1)
+	// below is the observations at or below the previous bound, rank the
+	// widths of the items at or below the current one
+	below, rank, i := uint64(0), 0.0, 0
+	for j, b := range bounds {
+		for ; i < len(est.items) && est.items[i].v <= b; i++ {
+			rank += est.items[i].rank
+		}
+		at := rank
+		if i < len(est.items) {
+			cur := &est.items[i]
+			hi := rank + cur.rank + cur.delta - cur.copies
+			at = (rank + math.Max(rank, hi)) / 2
+		}
+		// the midpoints of neighboring ranges can overlap
+		cum := uint64(math.Round(at))
+		if cum < below {
+			cum = below
+		}
+		if cum > count {
+			cum = count
+		}
+		counts[j] = cum - below
+		below = cum
+	}
+	counts[len(bounds)] = count - below
+	return counts, nil
+}
+
+// Reset discards all observations, keeping the invariants and options.
+// The storage of the items is kept for reuse by subsequent Adds, and the
+// estimator behaves as if freshly constructed.
+func (est *Estimator) Reset() {
+	est.items = est.items[:0]
+	est.observations = 0
+	est.buffer = est.buffer[:0]
+	est.weighted = est.weighted[:0]
+	est.pending = 0
+	est.sum = 0
+	est.nans = 0
+	est.infs = 0
+	est.flushes = 0
+	est.compressions = 0
+	est.removed = 0
+	est.ratio = 0
+	est.degradation = 0
+	if est.holdout != nil {
+		est.holdout.kept = 0
+		est.holdout.values = est.holdout.values[:0]
+	}
+}
+
+// Merge adds the observations summarized by other to the estimator.  Other
+// is flushed but otherwise left unchanged and usable.  Both must have the
+// same invariants, in any order, otherwise the merge returns
+// ErrTargetMismatch: a summary compressed for other targets may already
+// have merged away the ranks the receiver needs.
+//
+// The two summaries are interleaved by value, each item widening its
+// uncertainty by that of its successor from the other summary.  This treats
+// both sides alike, so a.Merge(b) and b.Merge(a) hold the same summary when
+// a and b have the same invariants.  Merging shards in different orders
+// compresses differently, and the estimates agree within twice the
+// tolerance.  Merging an estimator into itself returns ErrMergeSelf.
+//
+// Unknown bounds the error relative to the rank, which adds up across
+// summaries, so the merged summary keeps the bound.  Known is tightest at
+// the target rank of each summary, which is near the target rank of the
+// merged one only when the summaries observed alike distributions, as
+// shards of one stream do.
+func (est *Estimator) Merge(other *Estimator) error {
+	if other == est {
+		return ErrMergeSelf
+	}
+	if !sameInvariants(est.estimates(), other.estimates()) {
+		return wrapf(ErrTargetMismatch, "merging %d estimates into %d", len(other.estimates()), len(est.estimates()))
+	}
+
+	est.sum += other.sum
+	if other.weight() > 0 {
+		if est.weight() == 0 {
+			est.min, est.max = other.min, other.max
+		} else {
+			est.min, est.max = math.Min(est.min, other.min), math.Max(est.max, other.max)
+		}
+	}
+
+	est.flush()
+	other.flush()
+
+	a, b := est.items, other.items
+	merged := est.spare[:0]
+	for len(a) > 0 || len(b) > 0 {
+		var it item
+		switch {
+		case len(b) == 0 || len(a) > 0 && a[0].v < b[0].v:
+			// b ranks above every copy in a
+			it = a[0]
+			if len(b) > 0 {
+				it.delta += b[0].rank + b[0].delta - b[0].copies
+			}
+			a = a[1:]
+		case len(a) == 0 || b[0].v < a[0].v:
+			it = b[0]
+			if len(a) > 0 {
+				it.delta += a[0].rank + a[0].delta - a[0].copies
+			}
+			b = b[1:]
+		default:
+			// the same value on both sides, bounded by both
+			it = a[0]
+			it.rank += b[0].rank
+			it.delta += b[0].delta
+			it.copies += b[0].copies
+			a, b = a[1:], b[1:]
+		}
+		merged = append(merged, it)
+	}
+
+	est.items, est.spare = merged, est.items[:0]
+	est.observations += other.observations
+	est.nans += other.nans
+	est.infs += other.infs
+	est.compress()
+	if other.degradation > 0 || est.degradation > 0 {
+		// the merge widened the forced items by their neighbors'
+		est.degradation = math.Max(math.Max(est.degradation, other.degradation), est.widest())
+	}
+
+	if debug {
+		if err := est.DebugValidate(); err != nil {
+			panic(err)
+		}
+	}
+	return nil
+}
+
+// Sample is an item of the compressed summary.  Width is the number of
+// observations it stands for, ranking above those of the previous sample,
+// and Delta the uncertainty of its highest rank.
+type Sample struct {
+	Value float64
+	Width float64
+	Delta float64
+}
+
+// Samples flushes the estimator and returns a copy of its compressed summary
+// in ascending order of value.  The widths sum to the observations.
+func (est *Estimator) Samples() []Sample {
+	est.flush()
+
+	samples := make([]Sample, 0, len(est.items))
+	for _, it := range est.items {
+		samples = append(samples, Sample{Value: it.v, Width: it.rank, Delta: it.delta})
+	}
+	return samples
+}
+
+// MergeSamples adds the observations summarized by samples, as returned by
+// Samples of an estimator with the same invariants, like Merge.  Sum and
+// Mean count each value Width times.
+//
+// Samples must be in ascending order of finite values with positive, finite
+// widths and finite deltas that are not negative, 0 at the first and last
+// sample and below the uncertain rank of the next sample, and the widths
+// must keep Count within an int64, otherwise MergeSamples returns
+// ErrCorruptData and changes nothing.  Samples of infinite values are
+// rejected as well, Merge merges estimators holding them.
+func (est *Estimator) MergeSamples(samples []Sample) error {
+	other := &Estimator{invariants: est.invariants, items: make([]item, 0, len(samples))}
+	for i, s := range samples {
+		switch {
+		case s.Value != s.Value || math.IsInf(s.Value, 0):
+			return wrapf(ErrCorruptData, "sample %d: value %g is not finite", i, s.Value)
+		case !(s.Width > 0) || math.IsInf(s.Width, 0):
+			return wrapf(ErrCorruptData, "sample %d (v=%g): width %g is not positive and finite", i, s.Value, s.Width)
+		case !(s.Delta >= 0) || math.IsInf(s.Delta, 0):
+			return wrapf(ErrCorruptData, "sample %d (v=%g): delta %g is not finite and non-negative", i, s.Value, s.Delta)
+		}
+
+		// at least the value itself ranks above any earlier one
+		other.items = append(other.items, item{v: s.Value, rank: s.Width, delta: s.Delta, copies: math.Min(1, s.Width)})
+		other.observations += s.Width
+		other.sum += s.Value * s.Width
+	}
+	if len(other.items) == 0 {
+		return nil
+	}
+	other.min, other.max = other.items[0].v, other.items[len(other.items)-1].v
+
+	if err := other.DebugValidate(); err != nil {
+		return err
+	}
+	if total := est.weight() + other.observations; total >= maxObservations {
+		return wrapf(ErrCorruptData, "merging %g observations into %g exceeds the count", other.observations, est.weight())
+	}
+	return est.Merge(other)
+}
+
+// maxObservations bounds the observations, which Count reports as an int64.
+const maxObservations = 0x1p63
+
+// maxWeight is the largest float64 below maxObservations.
+const maxWeight = maxObservations - 0x1p10
+
+// Size returns the number of items the summary retains as of the last
+// flush, without flushing.
+func (est *Estimator) Size() int {
+	return len(est.items)
+}
+
+// Buffered returns the number of values and weighted values waiting for the
+// next flush, without flushing.
+func (est *Estimator) Buffered() int {
+	return len(est.buffer) + len(est.weighted)
+}
+
+// Count returns the number of values observed, including those still
+// buffered, without flushing.  NaN and dropped infinite values are not
+// counted.  Values added with AddWeighted count their weight, and a total
+// weight that is not a whole number is rounded down.
+func (est *Estimator) Count() int64 {
+	return int64(est.observations+est.pending) + int64(len(est.buffer))
+}
+
+// Min returns the smallest value observed, including those still buffered,
+// or NaN if none were.  It is the same as Get(0) without flushing.
+func (est *Estimator) Min() float64 {
+	if est.weight() == 0 {
+		return math.NaN()
+	}
+	return est.min
+}
+
+// Max returns the largest value observed, including those still buffered,
+// or NaN if none were.  It is the same as Get(1) without flushing.
+func (est *Estimator) Max() float64 {
+	if est.weight() == 0 {
+		return math.NaN()
+	}
+	return est.max
+}
+
+// Sum returns the sum of the values observed, including those still
+// buffered.  A kept infinite value makes it infinite.
+func (est *Estimator) Sum() float64 {
+	return est.sum
+}
+
+// Mean returns the mean of the values observed, including those still
+// buffered, or NaN if none were.
+func (est *Estimator) Mean() float64 {
+	if est.weight() == 0 {
+		return math.NaN()
+	}
+	return est.sum / est.weight()
+}
+
+// NaNCount returns the number of NaN values dropped by Add.
+func (est *Estimator) NaNCount() int {
+	return est.nans
+}
+
+// InfCount returns the number of infinite values passed to Add, whether
+// they were kept or dropped.
+func (est *Estimator) InfCount() int {
+	return est.infs
+}
+
+// Warnings describes the estimates whose configuration was adjusted, such as
+// Known tolerances clamped to the distance from the minimum or maximum,
+// which wrap ErrInvalidEpsilon.
+func (est *Estimator) Warnings() []error {
+	var warnings []error
+	for _, f := range est.invariants {
+		if t, ok := f.(target); ok && t.requested != 0 {
+			warnings = append(warnings, wrapf(ErrInvalidEpsilon, "Known(%g, %g) reaches past the boundary, clamped to %g", t.q, t.requested, t.tolerance))
+		}
+	}
+	return warnings
+}
+
+// Targets returns a copy of the invariants in the order they were passed to
+// New and AddTarget, less those WithMinimalTargets dropped, or the default
+// Unknown(0.1) of a zero Estimator.  The estimates of this package implement
+// Target, which reports their quantiles and tolerances.
+func (est *Estimator) Targets() []Estimate {
+	return append([]Estimate(nil), est.estimates()...)
+}
+
+// AddTarget adds an invariant that compression honors along with the others
+// from now on, such as a Known quantile newly configured for alerting.  The
+// items already retained were compressed to the looser invariants, so the
+// new guarantee holds for the observations added after the call, and the
+// rank error of Get approaches it as they outnumber the earlier ones.
+// GuaranteedError reports the new invariants at once, while ErrorAt reports
+// the error the summary achieves.
+//
+// The estimate is checked like by NewChecked.  Adding one the estimator
+// already has does nothing.  Merge requires the same invariants, so only
+// estimators with the same targets added can be merged.
+func (est *Estimator) AddTarget(f Estimate) error {
+	if err := checkEstimate(f); err != nil {
+		return err
+	}
+	invariants := est.estimates()
+	for _, g := range invariants {
+		if reflect.DeepEqual(f, g) {
+			return nil
 		}
-		cur = cur.next
 	}
-	return cur.v
+
+	// the invariants may be shared with the caller of New or the default
+	est.invariants = append(append([]Estimate(nil), invariants...), f)
+	return nil
 }
 
-// Samples returns the number of values this estimator has sampled.
-func (est *Estimator) Samples() int {
-	return int(est.observations) + len(est.buffer)
+// estimates returns the invariants, or the default for a zero Estimator.
+func (est *Estimator) estimates() []Estimate {
+	// a zero Estimator has no invariants, which would allow merging anything
+	if len(est.invariants) == 0 {
+		return defaultInvariants
+	}
+	return est.invariants
+}
+
+// sameInvariants reports whether a and b hold equal estimates, in any order.
+func sameInvariants(a, b []Estimate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	used := make([]bool, len(b))
+next:
+	for _, f := range a {
+		for j, g := range b {
+			if !used[j] && reflect.DeepEqual(f, g) {
+				used[j] = true
+				continue next
+			}
+		}
+		return false
+	}
+	return true
 }
 
 // ƒ(r,n) = minⁱ(ƒⁱ(r,n))
 func (est *Estimator) invariant(rank float64, n float64) float64 {
-	min := (n + 1)
-	for _, f := range est.invariants {
+	// no item can be wider than every observation
+	min := n
+	for _, f := range est.estimates() {
 		if delta := f.Delta(rank, n); delta < min {
 			min = delta
 		}
@@ -179,95 +1384,395 @@ func (est *Estimator) invariant(rank float64, n float64) float64 {
 	return math.Floor(min)
 }
 
-func (est *Estimator) observe(v float64, rank, delta float64, next *item) *item {
-	est.observations++
-	est.items++
-
-	// reuse or allocate
-	select {
-	case old := <-est.pool:
-		old.v = v
-		old.rank = rank
-		old.delta = delta
-		old.next = next
-		return old
-	default:
-		return &item{
-			v:     v,
-			rank:  rank,
-			delta: delta,
-			next:  next,
+// invariantOver is ƒ over the ranks from rank to rank+width.  An item's
+// bounds must hold at every rank it may occupy, and below a Known quantile ƒ
+// shrinks as the rank grows.  Unknown and HighBiased are linear and Known
+// falls to its target rank and grows after it, so ƒ is smallest at one of
+// the ends or at a target rank in between.
+func (est *Estimator) invariantOver(rank, width, n float64) float64 {
+	min := math.Min(est.invariant(rank, n), est.invariant(rank+width, n))
+	for _, f := range est.invariants {
+		if t, ok := f.(target); ok {
+			if r := math.Floor(t.q * n); rank < r && r < rank+width {
+				min = math.Min(min, est.invariant(r, n))
+			}
 		}
 	}
+	return min
+}
 
-	panic("unreachable")
+// bounds evaluates invariant and invariantOver for one number of
+// observations, such as throughout a compression pass.  The estimates of
+// the package are unpacked once, so evaluating them needs neither interface
+// calls nor the floors of the target ranks.  Of several Unknown or
+// HighBiased estimates only the smallest tolerance can be the minimum.
+// Every ƒ is computed with the same operations as by Delta, so the results
+// are identical.
+type bounds struct {
+	n float64
+
+	// 2·tolerance of Unknown and HighBiased, NaN without one
+	low, high float64
+
+	// the targets strictly between the minimum and maximum, and the ranks
+	// ⌊q·n⌋ of all targets in ascending order
+	targets []target
+	splits  []float64
+	ranks   []float64
+
+	// estimates defined outside the package
+	other []Estimate
+
+	// ƒ at the start of the last range of over, which stays the same while
+	// compression merges into the items after it
+	start, atStart float64
 }
 
-func (est *Estimator) recycle(old *item) {
-	est.items--
-	select {
-	case est.pool <- old:
-	default:
+// reset unpacks the estimates for n observations, reusing the slices.
+func (b *bounds) reset(estimates []Estimate, n float64) {
+	b.n = n
+	b.low, b.high = math.NaN(), math.NaN()
+	b.start = math.NaN()
+	b.targets, b.splits, b.ranks, b.other = b.targets[:0], b.splits[:0], b.ranks[:0], b.other[:0]
+
+	for _, f := range estimates {
+		switch f := f.(type) {
+		case bias:
+			if f.tolerance == f.tolerance && !(2*f.tolerance >= b.low) {
+				b.low = 2 * f.tolerance
+			}
+		case highBias:
+			if f.tolerance == f.tolerance && !(2*f.tolerance >= b.high) {
+				b.high = 2 * f.tolerance
+			}
+		case target:
+			r := math.Floor(f.q * n)
+			b.ranks = append(b.ranks, r)
+			// the others allow more than every observation
+			if f.q > 0 && f.q < 1 {
+				b.targets = append(b.targets, f)
+				b.splits = append(b.splits, r)
+			}
+		default:
+			b.other = append(b.other, f)
+		}
 	}
+	sort.Float64s(b.ranks)
 }
 
-// merges the batch
-func (est *Estimator) update(batch []float64) {
-	// initial data
-	if est.head == nil {
-		est.head = est.observe(batch[0], 1, 0, nil)
-		batch = batch[1:]
+// at is invariant(rank, n).
+func (b *bounds) at(rank float64) float64 {
+	min := b.n
+	if d := b.low * rank; d < min {
+		min = d
+	}
+	if d := b.high * (b.n - rank); d < min {
+		min = d
+	}
+	for i, t := range b.targets {
+		var d float64
+		if rank <= b.splits[i] {
+			d = t.f2 * (b.n - rank)
+		} else {
+			d = t.f1 * rank
+		}
+		if d < min {
+			min = d
+		}
+	}
+	for _, f := range b.other {
+		if d := f.Delta(rank, b.n); d < min {
+			min = d
+		}
 	}
+	return math.Floor(min)
+}
 
-	rank := 0.0
-	cur := est.head
-	for _, v := range batch {
-		// min
-		if v < est.head.v {
-			est.head = est.observe(v, 1, 0, est.head)
-			cur = est.head
-			continue
+// over is invariantOver(rank, width, n).
+func (b *bounds) over(rank, width float64) float64 {
+	if rank != b.start {
+		b.start, b.atStart = rank, b.at(rank)
+	}
+	min := math.Min(b.atStart, b.at(rank+width))
+	for _, r := range b.ranks {
+		if r >= rank+width {
+			break
+		}
+		if rank < r {
+			min = math.Min(min, b.at(r))
 		}
+	}
+	return min
+}
 
-		// cursor
-		for cur.next != nil && cur.next.v < v {
-			rank += cur.rank
-			cur = cur.next
+// update merges the batch and the weighted values into the summary, built in
+// the spare storage in one pass.
+//
+// Merging the sorted batch is the same as inserting its values one at a
+// time: every value is in place before the next, so the bounds seen by each
+// insertion count exactly the values before it.  A weighted value is
+// inserted like that many equal values.
+func (est *Estimator) update(batch []float64, weighted []weightedValue) {
+	old := est.items
+	merged := est.spare[:0]
+	for len(batch) > 0 || len(weighted) > 0 {
+		var v, w float64
+		if len(weighted) == 0 || len(batch) > 0 && batch[0] <= weighted[0].v {
+			v, w = batch[0], 1
+			batch = batch[1:]
+		} else {
+			v, w = weighted[0].v, weighted[0].w
+			weighted = weighted[1:]
 		}
 
-		// max
-		if cur.next == nil {
-			cur.next = est.observe(v, 1, 0, nil)
-			continue
+		// the items below v come before it
+		for len(old) > 0 && old[0].v < v {
+			merged = append(merged, old[0])
+			old = old[1:]
 		}
+		merged = est.insert(merged, old, v, w)
+	}
+	merged = append(merged, old...)
+
+	est.items, est.spare = merged, est.items[:0]
+}
 
-		cur.next = est.observe(v, 1, est.invariant(rank, est.observations)-1, cur.next)
+// insert adds v with the weight of w observations between the merged items,
+// which rank below v but for an item of v last, and the items not merged
+// yet, which rank above v but for an item of v first.
+func (est *Estimator) insert(merged, rest []item, v, w float64) []item {
+	est.observations += w
+
+	// An observation equal to a retained value shifts that value's rank
+	// bounds by exactly one, so count it there instead of inserting a
+	// new item.  Runs of equal values then never occupy more than one.
+	if len(merged) > 0 && merged[len(merged)-1].v == v {
+		merged[len(merged)-1].rank += w
+		merged[len(merged)-1].copies += w
+		return merged
+	}
+	if len(rest) > 0 && rest[0].v == v {
+		rest[0].rank += w
+		rest[0].copies += w
+		return merged
+	}
+
+	// A new minimum or maximum is exact.  A displaced minimum keeps its
+	// delta of 0: it was the exact minimum, minimums only ever gain copies
+	// of their own value, so every value below it is now counted by the
+	// new one.
+	if len(merged) == 0 || len(rest) == 0 {
+		return append(merged, item{v: v, rank: w, copies: w})
 	}
+
+	// The new value ranks below every copy of its successor's value, so
+	// its upper bound is that far below the successor's: Δ = g' + Δ' - c'.
+	// Without repeated values this is g' + Δ' - 1, at most the paper's
+	// ƒ-1 while the successor satisfies the invariant, and unlike ƒ-1 it
+	// stays correct when the successor's rank moved across a Known target
+	// where ƒ drops.  Subtracting the copies keeps a value inserted before
+	// a run of equal values as exact as the run.
+	next := &rest[0]
+	return append(merged, item{v: v, rank: w, delta: next.rank + next.delta - next.copies, copies: w})
 }
 
+// compress merges items into their successors where the invariant allows.
+//
+// Only items between the first and the last are ever removed, and a merge
+// keeps the successor's value, so the minimum and maximum are retained
+// exactly with a delta of 0.  A merge only widens the successor, which
+// never allows a merge the pass already declined, so one pass reaches a
+// fixed point.  Above the cap of WithMaxItems, further passes merge items
+// beyond the invariant.
 func (est *Estimator) compress() {
-	rank := 0.0
-	cur := est.head
-	for cur != nil && cur.next != nil {
-		if cur.rank+cur.next.rank+cur.next.delta <= est.invariant(rank, est.observations) {
-			// merge with previous/head
-			removed := cur.next
+	if len(est.items) == 0 {
+		return
+	}
+
+	var min, max float64
+	if debug {
+		min, max = est.extremes()
+	}
+
+	est.compressions++
+	items := len(est.items)
+
+	est.bounds.reset(est.estimates(), est.observations)
+	est.compressWithin(0)
+	if est.maxItems > 0 && len(est.items) > est.maxItems {
+		// Items wider than the invariant, up to a budget doubling from
+		// the width that fits the observations into maxItems, until they
+		// do.  No item is wider than the observations, so the last pass
+		// leaves only the minimum and maximum.
+		budget := math.Ceil(2 * est.observations / float64(est.maxItems))
+		forced := false
+		for len(est.items) > est.maxItems && budget <= 2*est.observations {
+			before := len(est.items)
+			est.compressWithin(budget)
+			forced = forced || len(est.items) < before
+			budget *= 2
+		}
+		if forced {
+			est.degradation = math.Max(est.degradation, est.widest())
+		}
+	}
+	est.removed = items - len(est.items)
+	if ratio := float64(items) / float64(len(est.items)); est.compressions == 1 {
+		est.ratio = ratio
+	} else {
+		est.ratio += (ratio - est.ratio) / 8
+	}
 
-			cur.v = removed.v
-			cur.rank += removed.rank
-			cur.delta = removed.delta
-			cur.next = removed.next
+	if debug {
+		if newMin, newMax := est.extremes(); newMin != min || newMax != max {
+			panic("quantile: compress changed the minimum or maximum")
+		}
+	}
+	est.compressed(items, len(est.items))
+}
 
-			est.recycle(removed)
+// widest returns the largest g + Δ of the items as a fraction of the
+// observations.
+func (est *Estimator) widest() float64 {
+	widest := 0.0
+	for i := range est.items {
+		widest = math.Max(widest, est.items[i].rank+est.items[i].delta)
+	}
+	return widest / est.observations
+}
+
+// compressWithin is a compression pass merging items up to the width of the
+// invariant, or of budget where that is wider.
+func (est *Estimator) compressWithin(budget float64) {
+	items := est.items
+	if len(items) < 3 {
+		return
+	}
+
+	// Items are kept by compacting them to the front: items[:kept] are
+	// kept, items[i] is the item being considered, and the items after it
+	// are untouched.  rank is the sum of the ranks of the kept items.
+	kept := 1
+	rank := items[0].rank
+	for i := 1; i < len(items)-1; i++ {
+		cur, next := &items[i], &items[i+1]
+		width := cur.rank + next.rank + next.delta
+		if width <= math.Max(budget, est.bounds.over(rank, width)) {
+			// merge into next, which now starts at rank
+			next.rank += cur.rank
+			continue
 		}
 		rank += cur.rank
-		cur = cur.next
+		items[kept] = *cur
+		kept++
 	}
+	items[kept] = items[len(items)-1]
+	est.items = items[:kept+1]
+}
+
+// debug enables internal assertions
+var debug = false
+
+// extremes returns the first and last values, which compress must not change.
+func (est *Estimator) extremes() (min, max float64) {
+	return est.items[0].v, est.items[len(est.items)-1].v
 }
 
+// flush commits the buffer.  Without new values it leaves the summary alone,
+// so repeated queries see the same items.
 func (est *Estimator) flush() {
-	sort.Float64Slice(est.buffer).Sort()
-	est.update(est.buffer)
+	if len(est.buffer) == 0 && len(est.weighted) == 0 {
+		return
+	}
+
+	est.commit(est.buffer, est.weighted)
 	est.buffer = est.buffer[0:0]
+	est.weighted = est.weighted[0:0]
+	est.pending = 0
+}
+
+// commit sorts a batch and weighted values, merges them into the summary and
+// compresses it.
+func (est *Estimator) commit(batch []float64, weighted []weightedValue) {
+	sort.Float64s(batch)
+	sort.Slice(weighted, func(i, j int) bool { return weighted[i].v < weighted[j].v })
+	est.commitSorted(batch, weighted)
+}
+
+// commitSorted merges a sorted batch and sorted weighted values into the
+// summary and compresses it.
+func (est *Estimator) commitSorted(batch []float64, weighted []weightedValue) {
+	est.flushes++
+	batchSize := len(batch) + len(weighted)
+	est.update(batch, weighted)
 	est.compress()
+
+	if debug {
+		if err := est.DebugValidate(); err != nil {
+			panic(err)
+		}
+	}
+	est.flushed(batchSize)
+}
+
+// DebugValidate walks the summary and reports the first item that breaks its
+// invariants: values in order, positive widths and non-negative deltas, all
+// finite, upper rank bounds below the successor's copies, 0 deltas at the
+// minimum and maximum and widths adding up to the observations, which Count
+// must be able to report.  The error wraps ErrCorruptData.  It is meant for
+// tests and debugging.
+//
+// Widths and bounds are compared exactly while they are whole numbers, and
+// within rounding once decayed to fractions of observations.
+//
+// Deltas are not checked against ƒ.  An item's rank can drift across a Known
+// target where ƒ drops, so a delta within ƒ when assigned need not stay
+// within it, while the successor's bounds limit it at all times.
+func (est *Estimator) DebugValidate() error {
+	items := est.items
+	if len(items) > 0 && items[0].delta != 0 {
+		return wrapf(ErrCorruptData, "item 0 (v=%g): minimum has delta %f", items[0].v, items[0].delta)
+	}
+
+	rank := 0.0
+	for i := range items {
+		cur := &items[i]
+		var next *item
+		if i+1 < len(items) {
+			next = &items[i+1]
+		}
+		switch {
+		case cur.v != cur.v:
+			return wrapf(ErrCorruptData, "item %d: NaN value", i)
+		case math.IsInf(cur.rank, 0) || math.IsNaN(cur.rank):
+			return wrapf(ErrCorruptData, "item %d (v=%g): width %f is not finite", i, cur.v, cur.rank)
+		case math.IsInf(cur.delta, 0) || math.IsNaN(cur.delta):
+			return wrapf(ErrCorruptData, "item %d (v=%g): delta %f is not finite", i, cur.v, cur.delta)
+		case next != nil && next.v < cur.v:
+			return wrapf(ErrCorruptData, "item %d (v=%g): followed by smaller v=%g", i, cur.v, next.v)
+		case !(cur.rank > 0):
+			return wrapf(ErrCorruptData, "item %d (v=%g): width %f is not positive", i, cur.v, cur.rank)
+		case !(cur.delta >= 0):
+			return wrapf(ErrCorruptData, "item %d (v=%g): negative delta %f", i, cur.v, cur.delta)
+		case !(cur.copies > 0 && cur.copies <= cur.rank):
+			return wrapf(ErrCorruptData, "item %d (v=%g): %f copies outside width %f", i, cur.v, cur.copies, cur.rank)
+		case next != nil && cur.delta+next.copies > (next.rank+next.delta)*(1+0x1p-40):
+			return wrapf(ErrCorruptData, "item %d (v=%g): delta %f reaches the upper bound of v=%g (width %f, delta %f, copies %f)", i, cur.v, cur.delta, next.v, next.rank, next.delta, next.copies)
+		case next == nil && cur.delta != 0:
+			return wrapf(ErrCorruptData, "item %d (v=%g): maximum has delta %f", i, cur.v, cur.delta)
+		}
+		rank += cur.rank
+	}
+
+	// the comparison below is false for NaN and infinities
+	if n := est.observations; math.IsNaN(n) || math.IsInf(n, 0) || n < 0 || n >= maxObservations {
+		return wrapf(ErrCorruptData, "%f observations outside the count", n)
+	}
+	if math.IsNaN(rank) || math.IsInf(rank, 0) {
+		return wrapf(ErrCorruptData, "widths of %d items add up to %f", len(items), rank)
+	}
+	if math.Abs(rank-est.observations) > est.observations*0x1p-40 {
+		return wrapf(ErrCorruptData, "widths of %d items add up to %f, want %f observations", len(items), rank, est.observations)
+	}
+	return nil
 }
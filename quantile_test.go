@@ -5,26 +5,41 @@
 package quantile
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"math/rand"
+	"reflect"
 	"runtime"
 	"sort"
+	"strings"
 	"testing"
 	"testing/quick"
+
+	"github.com/streadway/quantile/quantiletest"
 )
 
+func init() {
+	// validate the summary after every flush in the tests
+	debug = true
+}
+
 func withinError(t *testing.T, fn Estimate, q, e float64) func(N uint32) bool {
 	return func(N uint32) bool {
+		// validating summaries of up to a million values after every flush
+		// would dominate the suite, the other tests cover the structure
+		debug = false
+		defer func() { debug = true }()
+
 		n := int(N % 1000000)
 		est := New(fn)
-		obs := make([]float64, 0, n)
-
-		for i := 0; i < n; i++ {
-			s := rand.NormFloat64()*1.0 + 0.0
-			obs = append(obs, s)
-			est.Add(s)
+		obs := quantiletest.Normal(0, 1).Generate(n, int64(N))
+		for _, v := range obs {
+			est.Add(v)
 		}
 
-		if est.Samples() != n {
+		if est.Count() != int64(n) {
 			return false
 		}
 
@@ -33,7 +48,8 @@ func withinError(t *testing.T, fn Estimate, q, e float64) func(N uint32) bool {
 		// "v" the estimate
 		estimate := est.Get(q)
 		if n == 0 {
-			return estimate == 0
+			// no data is NaN, not an estimate of 0
+			return math.IsNaN(estimate)
 		}
 
 		// A[⌈(φ − ε)n⌉] ≤ v ≤ A[⌈(φ + ε)n⌉]
@@ -55,14 +71,14 @@ func withinError(t *testing.T, fn Estimate, q, e float64) func(N uint32) bool {
 		}
 
 		t.Logf("delta: %d ex: %f min: %f (%f) max: %f (%f) est: %f n: %d l: %d",
-			upper-lower, obs[exact], min, obs[0], max, obs[len(obs)-1], estimate, n, est.items)
+			upper-lower, obs[exact], min, obs[0], max, obs[len(obs)-1], estimate, n, len(est.items))
 
 		fits := (min <= estimate && estimate <= max)
 
 		if !fits {
-			for cur := est.head; cur != nil; cur = cur.next {
-				t.Log(cur)
-			}
+			var dump strings.Builder
+			est.WriteTo(&dump)
+			t.Log(dump.String())
 		}
 
 		return fits
@@ -70,6 +86,10 @@ func withinError(t *testing.T, fn Estimate, q, e float64) func(N uint32) bool {
 }
 
 func TestErrorKnownd(t *testing.T) {
+	// quick.Check is unlikely to draw it
+	if !withinError(t, Known(0.99, 0.0001), 0.99, 0.0001)(0) {
+		t.Error("want NaN from no values")
+	}
 	if err := quick.Check(withinError(t, Known(0.99, 0.0001), 0.99, 0.0001), nil); err != nil {
 		t.Error(err)
 	}
@@ -86,33 +106,72 @@ func BenchmarkQuantileEstimator(b *testing.B) {
 
 	// Warmup
 	b.StopTimer()
-	for i := 0; i < 10000; i++ {
-		est.Add(rand.NormFloat64()*1.0 + 0.0)
+	values := quantiletest.Normal(0, 1).Generate(1<<16, 1)
+	for _, v := range values[:10000] {
+		est.Add(v)
 	}
 	b.StartTimer()
+	b.ReportAllocs()
 
 	var pre runtime.MemStats
 	runtime.ReadMemStats(&pre)
 
 	for i := 0; i < b.N; i++ {
-		est.Add(rand.NormFloat64()*1.0 + 0.0)
+		est.Add(values[i&(len(values)-1)])
 	}
 
 	var post runtime.MemStats
 	runtime.ReadMemStats(&post)
 
-	b.Logf("allocs: %d items: %d 0.01: %f 0.50: %f 0.99: %f", post.TotalAlloc-pre.TotalAlloc, est.items, est.Get(0.01), est.Get(0.50), est.Get(0.99))
+	b.Logf("allocs: %d items: %d 0.01: %f 0.50: %f 0.99: %f", post.TotalAlloc-pre.TotalAlloc, len(est.items), est.Get(0.01), est.Get(0.50), est.Get(0.99))
+}
+
+// Targets at every decile retain thousands of items, the summary a flush
+// merges into and compresses.
+func BenchmarkDeciles(b *testing.B) {
+	debug = false
+	defer func() { debug = true }()
+	var invariants []Estimate
+	for q := 0.1; q < 1; q += 0.1 {
+		invariants = append(invariants, Known(q, 0.0005))
+	}
+	est := New(invariants...)
+	for _, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		est.Add(v)
+	}
+
+	values := quantiletest.Normal(0, 1).Generate(1<<16, 2)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		est.Add(values[i&(len(values)-1)])
+	}
+	b.ReportMetric(float64(len(est.items)), "items")
 }
 
 func TestQueryEmptyStreamShouldNotPanic(t *testing.T) {
 	est := New(Known(0.99, 0.0001))
-	if val := est.Get(0.99); val != 0 {
-		t.Fatalf("expected 0, got %f", val)
+	if val := est.Get(0.99); !math.IsNaN(val) {
+		t.Fatalf("expected NaN, got %f", val)
 	}
 
 	est = New(Unknown(0.0001))
-	if val := est.Get(0.99); val != 0 {
-		t.Fatalf("expected 0, got %f", val)
+	if val := est.Get(0.99); !math.IsNaN(val) {
+		t.Fatalf("expected NaN, got %f", val)
+	}
+
+	// empty again after a Reset, with or without a flush before it
+	for _, flush := range []bool{false, true} {
+		est.Add(0)
+		if flush {
+			est.Flush()
+		}
+		est.Reset()
+		if val := est.Get(0.99); !math.IsNaN(val) {
+			t.Fatalf("flush=%t: expected NaN after Reset, got %f", flush, val)
+		}
+		if val := est.Peek(0.99); !math.IsNaN(val) {
+			t.Fatalf("flush=%t: expected NaN from Peek after Reset, got %f", flush, val)
+		}
 	}
 }
 
@@ -123,3 +182,3024 @@ func TestQueryEarlyStreamWithDirtyBuffer(t *testing.T) {
 		t.Fatalf("got %f, want %f", got, want)
 	}
 }
+
+func TestAddDropsNaN(t *testing.T) {
+	quantiles := []float64{0, 0.01, 0.5, 0.95, 0.99, 1}
+	values := quantiletest.Normal(0, 1).Generate(10000, 1)
+
+	for _, at := range []int{0, 1, 511, 512, 5000, len(values)} {
+		clean, dirty := New(Known(0.5, 0.01), Known(0.99, 0.001)), New(Known(0.5, 0.01), Known(0.99, 0.001))
+		for i, v := range values {
+			if i == at {
+				// a run of NaN lands inside a single buffered batch
+				for j := 0; j < 3; j++ {
+					dirty.Add(math.NaN())
+				}
+			}
+			clean.Add(v)
+			dirty.Add(v)
+		}
+		if at == len(values) {
+			for j := 0; j < 3; j++ {
+				dirty.Add(math.NaN())
+			}
+		}
+
+		if got, want := dirty.NaNCount(), 3; got != want {
+			t.Fatalf("NaN at %d: want %d dropped, got %d", at, want, got)
+		}
+		if got, want := dirty.Count(), clean.Count(); got != want {
+			t.Fatalf("NaN at %d: want %d samples, got %d", at, want, got)
+		}
+		for _, q := range quantiles {
+			if got, want := dirty.Get(q), clean.Get(q); got != want {
+				t.Fatalf("NaN at %d: q=%f want %f, got %f", at, q, want, got)
+			}
+		}
+	}
+}
+
+func TestOnlyNaN(t *testing.T) {
+	est := New(Known(0.99, 0.001))
+	est.Add(math.NaN())
+	if got := est.Get(0.99); !math.IsNaN(got) {
+		t.Fatalf("want empty estimate NaN, got %f", got)
+	}
+	if got := est.Count(); got != 0 {
+		t.Fatalf("want no samples, got %d", got)
+	}
+}
+
+func addWithInf(est *Estimator, n int, seed int64) {
+	for i, v := range quantiletest.Normal(0, 1).Generate(n, seed) {
+		switch i % 1000 {
+		case 7:
+			est.Add(math.Inf(1))
+		case 13:
+			est.Add(math.Inf(-1))
+		}
+		est.Add(v)
+	}
+}
+
+func TestKeepInf(t *testing.T) {
+	est := New(Known(0.01, 0.001), Known(0.5, 0.01), Known(0.99, 0.001))
+	addWithInf(est, 100000, 1)
+
+	if got, want := est.InfCount(), 200; got != want {
+		t.Fatalf("want %d infinities, got %d", want, got)
+	}
+	if got, want := est.Count(), int64(100200); got != want {
+		t.Fatalf("want infinities kept as samples, got %d", got)
+	}
+	for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99} {
+		if v := est.Get(q); math.IsInf(v, 0) {
+			t.Fatalf("q=%f: want finite interior quantile, got %f", q, v)
+		}
+	}
+}
+
+func TestDropInf(t *testing.T) {
+	estimates := []Estimate{Known(0.01, 0.001), Known(0.5, 0.01), Known(0.99, 0.001)}
+	dirty := NewWithOptions([]Option{WithInfPolicy(DropInf)}, estimates...)
+	clean := New(estimates...)
+
+	addWithInf(dirty, 100000, 1)
+	for _, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		clean.Add(v)
+	}
+
+	if got, want := dirty.InfCount(), 200; got != want {
+		t.Fatalf("want %d infinities, got %d", want, got)
+	}
+	if got, want := dirty.Count(), clean.Count(); got != want {
+		t.Fatalf("want %d samples, got %d", want, got)
+	}
+	for _, q := range []float64{0, 0.01, 0.5, 0.99, 1} {
+		if got, want := dirty.Get(q), clean.Get(q); got != want {
+			t.Fatalf("q=%f: want %f, got %f", q, want, got)
+		}
+	}
+}
+
+func TestOnlyInf(t *testing.T) {
+	est := New(Known(0.5, 0.01))
+	est.Add(math.Inf(1))
+	est.Add(math.Inf(-1))
+	if v := est.Get(0); !math.IsInf(v, -1) {
+		t.Fatalf("want -Inf, got %f", v)
+	}
+	if v := est.Get(1); !math.IsInf(v, 1) {
+		t.Fatalf("want +Inf, got %f", v)
+	}
+}
+
+// NaN and ±Inf mixed into Add and AddBatch leave the values kept within
+// the tolerance of their exact quantiles under either policy.
+func TestNonFiniteWithinRankError(t *testing.T) {
+	targets := map[float64]float64{0.01: 0.001, 0.5: 0.01, 0.99: 0.001}
+	var estimates []Estimate
+	for q, e := range targets {
+		estimates = append(estimates, Known(q, e))
+	}
+
+	for _, policy := range []InfPolicy{KeepInf, DropInf} {
+		est := NewWithOptions([]Option{WithInfPolicy(policy)}, estimates...)
+		var exact quantiletest.Exact
+		add := func(v float64) {
+			if v == v && (policy == KeepInf || !math.IsInf(v, 0)) {
+				exact.Add(v)
+			}
+		}
+
+		r := rand.New(rand.NewSource(1))
+		nonFinite := []float64{math.NaN(), math.Inf(1), math.Inf(-1)}
+		for i := 0; i < 50000; i++ {
+			v := r.NormFloat64()
+			if i%97 == 0 {
+				v = nonFinite[i%len(nonFinite)]
+			}
+			est.Add(v)
+			add(v)
+
+			if i%10000 == 0 {
+				batch := []float64{r.NormFloat64(), math.NaN(), math.Inf(1), r.NormFloat64(), math.Inf(-1)}
+				est.AddBatch(batch)
+				for _, v := range batch {
+					add(v)
+				}
+			}
+		}
+
+		if err := est.DebugValidate(); err != nil {
+			t.Fatalf("policy %d: %v", policy, err)
+		}
+		if got, want := est.Count(), int64(exact.Samples()); got != want {
+			t.Fatalf("policy %d: want %d samples, got %d", policy, want, got)
+		}
+		for q, e := range targets {
+			quantiletest.AssertWithinRankError(t, &exact, est, q, e)
+		}
+	}
+}
+
+func TestLowBiased(t *testing.T) {
+	const tolerance = 0.01
+	check := func(seed int64) bool {
+		values := quantiletest.LogNormal(0, 1).Generate(20000, seed)
+		low, known := New(LowBiased(tolerance)), New(Known(0.01, 0.01*tolerance), Known(0.05, 0.05*tolerance))
+		for _, v := range values {
+			low.Add(v)
+			known.Add(v)
+		}
+
+		exact := quantiletest.NewExact(values)
+		for _, q := range []float64{0.01, 0.05} {
+			if !quantiletest.AssertWithinRankError(t, exact, low, q, q*tolerance) {
+				return false
+			}
+		}
+		if low.Size() > 2*known.Size() {
+			t.Errorf("want items comparable to the targets, got %d and %d", low.Size(), known.Size())
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, &quick.Config{MaxCount: 20}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKnownExtremes(t *testing.T) {
+	for _, q := range []float64{0, 1} {
+		f := Known(q, 0.01)
+		if d := f.Delta(500, 1000); math.IsInf(d, 0) || math.IsNaN(d) {
+			t.Fatalf("Known(%g, 0.01): want a finite invariant, got %f", q, d)
+		}
+
+		alone, median := New(f), New(f, Known(0.5, 0.01))
+		obs := quantiletest.Normal(0, 1).Generate(10000, 1)
+		for _, v := range obs {
+			alone.Add(v)
+			median.Add(v)
+		}
+		sort.Float64s(obs)
+
+		for _, est := range []*Estimator{alone, median} {
+			if est.Get(0) != obs[0] || est.Get(1) != obs[len(obs)-1] {
+				t.Fatalf("Known(%g, 0.01): want the exact extremes %f and %f, got %f and %f", q, obs[0], obs[len(obs)-1], est.Get(0), est.Get(1))
+			}
+		}
+		// constraining nothing else, the median's target applies alone
+		if err := quantiletest.RankError(obs, 0.5, median.Get(0.5)); err > median.GuaranteedError(0.5) {
+			t.Fatalf("Known(%g, 0.01): median rank error %f exceeds %f", q, err, median.GuaranteedError(0.5))
+		}
+	}
+}
+
+func TestHighBiased(t *testing.T) {
+	const tolerance = 0.01
+	for seed := int64(1); seed <= 5; seed++ {
+		values := quantiletest.Pareto(1.5).Generate(100000, seed)
+		est := New(HighBiased(tolerance))
+		for _, v := range values {
+			est.Add(v)
+		}
+		if err := est.DebugValidate(); err != nil {
+			t.Fatal(err)
+		}
+
+		exact := quantiletest.NewExact(values)
+		for _, q := range []float64{0.9, 0.99, 0.995, 0.999, 0.9995, 0.9999} {
+			quantiletest.AssertWithinRankError(t, exact, est, q, (1-q)*tolerance)
+		}
+	}
+}
+
+func TestUpdateRankAfterNewMinimum(t *testing.T) {
+	est := New(Known(0.99, 0.001))
+	r := rand.New(rand.NewSource(1))
+	below := 0
+	for i := 0; i < 10000; i++ {
+		v := r.Float64()
+		if v < 0.5 {
+			below++
+		}
+		est.Add(v)
+	}
+	est.flush()
+
+	// a new minimum is prepended before the second value is inserted
+	min, v := est.items[0].v-1, 0.5
+	est.update([]float64{min, v}, nil)
+
+	// min and every value below v rank before it
+	want := float64(below + 2)
+	rank := 0.0
+	for i := range est.items {
+		cur := &est.items[i]
+		rank += cur.rank
+		if cur.v == v {
+			if want < rank || want > rank+cur.delta {
+				t.Fatalf("want rank %f within [%f, %f]", want, rank, rank+cur.delta)
+			}
+			return
+		}
+	}
+	t.Fatalf("inserted value %f not found", v)
+}
+
+// TestHeadDisplacement feeds batches that each start below the minimum, so
+// every flush displaces the head with values left to insert behind it.
+func TestHeadDisplacement(t *testing.T) {
+	for _, inv := range [][]Estimate{{Unknown(0.01)}, {Known(0.01, 0.001), Known(0.5, 0.01)}} {
+		est := New(inv...)
+		r := rand.New(rand.NewSource(1))
+		var obs []float64
+		min := 0.0
+		for batch := 0; batch < 100; batch++ {
+			for i := 0; i < 512; i++ {
+				var v float64
+				if i%2 == 0 {
+					// a new minimum, not sorted within the batch
+					min -= r.Float64()
+					v = min
+				} else {
+					v = r.Float64() * 1000
+				}
+				obs = append(obs, v)
+				est.Add(v)
+			}
+			est.flush()
+
+			sorted := append([]float64(nil), obs...)
+			sort.Float64s(sorted)
+			rank := 0.0
+			for i := range est.items {
+				cur := &est.items[i]
+				rank += cur.rank
+				if got := float64(sort.SearchFloat64s(sorted, cur.v) + 1); got < rank || got > rank+cur.delta {
+					t.Fatalf("%v batch %d: %g ranks %f, outside [%f, %f]", inv, batch, cur.v, got, rank, rank+cur.delta)
+				}
+			}
+			for _, q := range []float64{0, 0.001, 0.01, 0.1} {
+				if err, bound := quantiletest.RankError(sorted, q, est.Get(q)), est.GuaranteedError(q); err > bound {
+					t.Fatalf("%v batch %d q=%f: rank error %f exceeds %f", inv, batch, q, err, bound)
+				}
+			}
+		}
+	}
+}
+
+// TestBoundsContainRanks checks every retained value's true rank against its
+// bounds.  Items near a Known target drift across it, where ƒ drops, which
+// left values inserted with the paper's ƒ-1 ranking above their bounds.
+func TestBoundsContainRanks(t *testing.T) {
+	invariants := []Estimate{Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001), Known(0.999, 0.0001)}
+	for seed := int64(0); seed < 20; seed++ {
+		est := New(invariants...)
+		r := rand.New(rand.NewSource(seed))
+		obs := make([]float64, 30000)
+		for i := range obs {
+			obs[i] = math.Pow(1-r.Float64(), -1/1.5)
+			est.Add(obs[i])
+		}
+		est.flush()
+		sort.Float64s(obs)
+
+		rank := 0.0
+		for i := range est.items {
+			cur := &est.items[i]
+			rank += cur.rank
+			if got := float64(sort.SearchFloat64s(obs, cur.v) + 1); got < rank || got > rank+cur.delta {
+				t.Fatalf("seed %d: %g ranks %f, outside [%f, %f]", seed, cur.v, got, rank, rank+cur.delta)
+			}
+		}
+	}
+}
+
+func TestMonotonicQuantiles(t *testing.T) {
+	distributions := map[string]func(r *rand.Rand, i int) float64{
+		"normal":      func(r *rand.Rand, i int) float64 { return r.NormFloat64() },
+		"exponential": func(r *rand.Rand, i int) float64 { return r.ExpFloat64() },
+		"uniform":     func(r *rand.Rand, i int) float64 { return r.Float64() },
+		"descending":  func(r *rand.Rand, i int) float64 { return float64(-i) },
+	}
+	invariants := [][]Estimate{
+		{Known(0.95, 0.005), Known(0.99, 0.001)},
+		{Known(0.5, 0.05), Known(0.99, 0.02)},
+		{Unknown(0.01)},
+	}
+
+	for name, next := range distributions {
+		for _, inv := range invariants {
+			r := rand.New(rand.NewSource(1))
+			est := New(inv...)
+			for i := 1; i <= 30000; i++ {
+				est.Add(next(r, i))
+				if i%3000 != 0 {
+					continue
+				}
+				prev := est.Get(0)
+				for q := 0; q <= 1000; q++ {
+					v := est.Get(float64(q) / 1000)
+					if v < prev {
+						t.Fatalf("%s %v n=%d: Get(%f) = %f below the previous quantile's %f", name, inv, i, float64(q)/1000, v, prev)
+					}
+					prev = v
+				}
+			}
+		}
+	}
+}
+
+func TestGetOK(t *testing.T) {
+	est := New(Known(0.5, 0.01))
+	if v, ok := est.GetOK(0.5); ok || !math.IsNaN(v) {
+		t.Fatalf("want no estimate from an empty estimator, got %f, %v", v, ok)
+	}
+
+	// NaN is dropped, so still nothing was observed
+	est.Add(math.NaN())
+	if _, ok := est.GetOK(0.5); ok {
+		t.Fatalf("want no estimate after only NaN")
+	}
+
+	est.Add(0)
+	if v, ok := est.GetOK(0.5); !ok || v != 0 {
+		t.Fatalf("want estimate 0, got %f, %v", v, ok)
+	}
+}
+
+func TestLowCardinalityStaysSmall(t *testing.T) {
+	for _, distinct := range []int{1, 3, 10, 50} {
+		est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+		r := rand.New(rand.NewSource(1))
+		counts := make([]int, distinct)
+		n := 200000
+		for i := 0; i < n; i++ {
+			// quantized timings, skewed towards the fast end
+			v := int(r.ExpFloat64()*float64(distinct)/4) % distinct
+			counts[v]++
+			est.Add(float64(v + 1))
+		}
+
+		for _, q := range []float64{0.5, 0.99} {
+			got := est.Get(q)
+			below, atOrBelow := 0, 0
+			for v, c := range counts {
+				if float64(v+1) < got {
+					below += c
+				}
+				if float64(v+1) <= got {
+					atOrBelow += c
+				}
+			}
+			// got occupies ranks below+1 through atOrBelow
+			if float64(below+1) > (q+0.01)*float64(n) || float64(atOrBelow) < (q-0.01)*float64(n) {
+				t.Errorf("%d distinct: q=%f got %f spanning ranks %d..%d", distinct, q, got, below+1, atOrBelow)
+			}
+		}
+
+		if len(est.items) > 2*distinct {
+			t.Errorf("%d distinct values retained %d items", distinct, len(est.items))
+		}
+	}
+}
+
+func TestNewWithoutInvariants(t *testing.T) {
+	var zero Estimator
+	implicit, explicit := New(), New(Unknown(0.1))
+	// an empty slice, as from configuration without targets
+	empty := New([]Estimate{}...)
+	checked, err := NewChecked()
+	if err != nil {
+		t.Fatalf("want NewChecked() to select the default, got %v", err)
+	}
+
+	obs := quantiletest.Normal(0, 1).Generate(10000, 1)
+	for _, v := range obs {
+		zero.Add(v)
+		implicit.Add(v)
+		explicit.Add(v)
+		empty.Add(v)
+		checked.Add(v)
+	}
+	sort.Float64s(obs)
+
+	for _, q := range []float64{0.1, 0.5, 0.9, 0.99} {
+		want := explicit.Get(q)
+		for name, est := range map[string]*Estimator{"New()": implicit, "New([]Estimate{}...)": empty, "NewChecked()": checked} {
+			if got := est.Get(q); got != want {
+				t.Errorf("q=%f: want %s to equal New(Unknown(0.1)) %f, got %f", q, name, want, got)
+			}
+		}
+
+		// the zero value flushes at different times, but holds the same bound
+		lower := obs[int((q-0.1)*float64(len(obs)))]
+		upper := obs[int(math.Min((q+0.1)*float64(len(obs)), float64(len(obs)-1)))]
+		if got := zero.Get(q); got < lower || got > upper {
+			t.Errorf("q=%f: want zero Estimator within [%f, %f], got %f", q, lower, upper, got)
+		}
+	}
+}
+
+func TestEstimates(t *testing.T) {
+	// a quantile whose float is not the decimal it was computed from
+	odd := 0.1 + 0.2
+	est := New(Known(0.5, 0.01), Unknown(0.01), Known(odd, 0.01), Known(0.999, 0.0001), Known(1, 0.1))
+	quantiles := []float64{0.5, odd, 0.999, 1}
+
+	estimates := est.Estimates()
+	if len(estimates) != len(quantiles) {
+		t.Fatalf("want an estimate of every Known target, got %v", estimates)
+	}
+	for _, q := range quantiles {
+		if v, ok := estimates[q]; !ok || !math.IsNaN(v) {
+			t.Fatalf("q=%g: want NaN without values, got %v", q, estimates)
+		}
+	}
+
+	for _, v := range quantiletest.LogNormal(0, 1).Generate(100000, 1) {
+		est.Add(v)
+	}
+	flushes := est.Stats().Flushes
+	estimates = est.Estimates()
+	if got := est.Stats().Flushes; got != flushes+1 {
+		t.Fatalf("want one flush, got %d", got-flushes)
+	}
+	snapshot := est.Snapshot().Estimates()
+	for _, q := range quantiles {
+		if got, want := estimates[q], est.Get(q); got != want || snapshot[q] != want {
+			t.Errorf("q=%g: want Get %f, got %f and %f from the snapshot", q, want, got, snapshot[q])
+		}
+	}
+
+	if got := New(Unknown(0.01)).Estimates(); got == nil || len(got) != 0 {
+		t.Fatalf("want an empty map without Known targets, got %v", got)
+	}
+}
+
+func TestGuaranteedError(t *testing.T) {
+	est := New(Known(0.95, 0.001), Known(0.99, 0.001))
+	if got := est.GuaranteedError(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN for an empty estimator, got %f", got)
+	}
+
+	for seed := int64(0); seed < 10; seed++ {
+		est := New(Known(0.95, 0.001), Known(0.99, 0.001))
+		obs := quantiletest.Normal(0, 1).Generate(20000, seed)
+		for _, v := range obs {
+			est.Add(v)
+		}
+		sort.Float64s(obs)
+
+		for q := 0.05; q < 1; q += 0.05 {
+			if got, bound := quantiletest.RankError(obs, q, est.Get(q)), est.GuaranteedError(q); got > bound {
+				t.Errorf("seed %d q=%f: rank error %f exceeds guaranteed %f", seed, q, got, bound)
+			}
+		}
+
+		if low, high := est.GuaranteedError(0.5), est.GuaranteedError(0.99); low <= high {
+			t.Errorf("want the untargeted median less accurate than p99, got %f <= %f", low, high)
+		}
+	}
+}
+
+func TestErrorAt(t *testing.T) {
+	targets := []Estimate{Known(0.5, 0.01), Known(0.99, 0.001)}
+	est := New(targets...)
+	if got := est.ErrorAt(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN for an empty estimator, got %f", got)
+	}
+
+	capped := NewWithOptions([]Option{WithMaxItems(20)}, targets...)
+	for _, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		est.Add(v)
+		capped.Add(v)
+	}
+
+	errs := est.Errors()
+	if len(errs) != 2 || errs[0.5] > 0.01 || errs[0.99] > 0.001 || errs[0.5] == 0 {
+		t.Fatalf("want errors within the tolerances of 0.5 and 0.99, got %v", errs)
+	}
+	if got := capped.ErrorAt(0.5); got <= 0.01 || !capped.Degraded() {
+		t.Fatalf("want the forced merges to widen the error at 0.5 beyond 0.01, got %f", got)
+	}
+
+	// merging the item at the median into its successor widens the gap
+	// there by the item's width
+	midrank, rank := quantileRank(0.5, est.observations), 0.0
+	for i := range est.items {
+		if rank += est.items[i].rank; rank >= midrank {
+			est.items[i+1].rank += est.items[i].rank
+			est.items = append(est.items[:i], est.items[i+1:]...)
+			break
+		}
+	}
+	if got := est.ErrorAt(0.5); got <= errs[0.5] {
+		t.Fatalf("want the merge to widen the error at 0.5 beyond %f, got %f", errs[0.5], got)
+	}
+}
+
+func TestTinyStreamDeltas(t *testing.T) {
+	for _, inv := range []Estimate{Known(0.5, 0.01), Known(0.99, 0.001), Unknown(0.1)} {
+		for n := 1; n <= 50; n++ {
+			est := New(inv)
+			r := rand.New(rand.NewSource(int64(n)))
+			for i := 0; i < n; i++ {
+				est.Add(r.Float64())
+				// interleave queries so the list is built one value at a time
+				est.Get(0.5)
+			}
+
+			rank := 0.0
+			for i := range est.items {
+				cur := &est.items[i]
+				if f := est.invariant(rank, est.observations); cur.delta < 0 || cur.delta > math.Max(0, f-1) {
+					t.Fatalf("%v n=%d: delta %f at rank %f outside [0, %f]", inv, n, cur.delta, rank, math.Max(0, f-1))
+				}
+				rank += cur.rank
+			}
+		}
+	}
+}
+
+func TestBufferSizeIndependence(t *testing.T) {
+	targets := map[float64]float64{0.5: 0.01, 0.9: 0.005, 0.99: 0.001}
+	sizes := []int{1, 32, 512, 8192}
+
+	for seed := int64(0); seed < 5; seed++ {
+		ests := make([]*Estimator, len(sizes))
+		for i, size := range sizes {
+			ests[i] = New(Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001))
+			ests[i].buffer = make([]float64, 0, size)
+		}
+
+		obs := quantiletest.Normal(0, 1).Generate(50000, seed)
+		for _, v := range obs {
+			for _, est := range ests {
+				est.Add(v)
+			}
+		}
+		sort.Float64s(obs)
+
+		for q, e := range targets {
+			ranks := make([]float64, len(ests))
+			for i, est := range ests {
+				v := est.Get(q)
+				if err := quantiletest.RankError(obs, q, v); err > e {
+					t.Errorf("seed %d buffer %d: q=%f rank error %f exceeds %f", seed, sizes[i], q, err, e)
+				}
+				ranks[i] = quantiletest.RankOf(obs, v)
+			}
+			for i := range ranks {
+				for j := range ranks[:i] {
+					if d := math.Abs(ranks[i] - ranks[j]); d > 2*e {
+						t.Errorf("seed %d: q=%f buffers %d and %d differ by rank %f", seed, q, sizes[i], sizes[j], d)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBatchedUpdateMatchesSequential(t *testing.T) {
+	for _, inv := range []Estimate{Known(0.5, 0.01), Known(0.99, 0.001), Unknown(0.01)} {
+		batched, sequential := New(inv), New(inv)
+
+		// a compressed list to insert into
+		for _, v := range quantiletest.Normal(0, 1).Generate(20000, 1) {
+			batched.Add(v)
+			sequential.Add(v)
+		}
+		batched.flush()
+		sequential.flush()
+
+		batch := quantiletest.Sorted(quantiletest.Normal(0, 2)).Generate(4096, 2)
+
+		batched.update(batch, nil)
+		for _, v := range batch {
+			sequential.update([]float64{v}, nil)
+		}
+
+		if len(batched.items) != len(sequential.items) {
+			t.Fatalf("%v: batched and sequential summaries differ in length", inv)
+		}
+		for i, a := range batched.items {
+			if b := sequential.items[i]; a.v != b.v || a.rank != b.rank || a.delta != b.delta {
+				t.Fatalf("%v: batched item %+v differs from sequential %+v", inv, a, b)
+			}
+		}
+		if batched.observations != sequential.observations {
+			t.Fatalf("%v: want %f observations, got %f", inv, sequential.observations, batched.observations)
+		}
+	}
+}
+
+func TestGetOutOfRange(t *testing.T) {
+	est := New(Known(0.99, 0.001))
+	if _, err := est.GetErr(0.5); !errors.Is(err, ErrNoSamples) {
+		t.Fatalf("want ErrNoSamples from an empty estimator, got %v", err)
+	}
+	for i := 1; i <= 1000; i++ {
+		est.Add(float64(i))
+	}
+
+	for _, q := range []float64{math.Nextafter(1, 2), 99, -0.5, math.Nextafter(0, -1), math.NaN(), math.Inf(1)} {
+		if v, err := est.GetErr(q); !math.IsNaN(v) || !errors.Is(err, ErrInvalidQuantile) {
+			t.Errorf("q=%g: want NaN and ErrInvalidQuantile, got %f and %v", q, v, err)
+		}
+		if v := est.Get(q); !math.IsNaN(v) {
+			t.Errorf("q=%g: want NaN from Get, got %f", q, v)
+		}
+		if v, lo, hi := est.GetWithBounds(q); !math.IsNaN(v) || !math.IsNaN(lo) || !math.IsNaN(hi) {
+			t.Errorf("q=%g: want NaN from GetWithBounds, got %f in [%f, %f]", q, v, lo, hi)
+		}
+	}
+
+	// -0 is 0, the minimum
+	for q, want := range map[float64]float64{math.Copysign(0, -1): 1, 1: 1000, 0.99: 990} {
+		if v, err := est.GetErr(q); v != want || err != nil {
+			t.Errorf("q=%g: want %f, got %f and %v", q, want, v, err)
+		}
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	invariants := [][]Estimate{
+		{Unknown(0.01)},
+		{Known(0.5, 0.05), Known(0.9, 0.01), Known(0.99, 0.001)},
+		{Known(0.01, 0.001), Unknown(0.05)},
+	}
+
+	check := func(N uint16, seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		quantiles := []float64{0, 1, 0.5, 0.5, -1, 2, math.NaN(), 0.999, 0.001}
+		for i := 0; i < 20; i++ {
+			quantiles = append(quantiles, r.Float64())
+		}
+
+		for _, inv := range invariants {
+			est := New(inv...)
+			for i, v := range quantiletest.Normal(0, 1).Generate(int(N), seed) {
+				if i%3 == 0 {
+					// runs of equal values
+					v = math.Floor(v * 4)
+				}
+				est.Add(v)
+			}
+
+			all := est.GetAll(quantiles...)
+			for i, q := range quantiles {
+				if got, want := all[i], est.Get(q); got != want && !(math.IsNaN(got) && math.IsNaN(want)) {
+					t.Logf("%v n=%d q=%f: want %f from Get, got %f", inv, N, q, want, got)
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(check, nil); err != nil {
+		t.Error(err)
+	}
+
+	if got := New().GetAll(); len(got) != 0 {
+		t.Fatalf("want no estimates without quantiles, got %v", got)
+	}
+	if got := New().GetAll(0.5, 0.99); len(got) != 2 || !math.IsNaN(got[0]) || !math.IsNaN(got[1]) {
+		t.Fatalf("want NaN for every quantile of an empty estimator, got %v", got)
+	}
+}
+
+func BenchmarkGetAll(b *testing.B) {
+	quantiles := []float64{0.5, 0.9, 0.99, 0.999}
+	est := New(Known(0.5, 0.01), Known(0.9, 0.01), Known(0.99, 0.001), Known(0.999, 0.0001))
+	for _, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		est.Add(v)
+	}
+	est.flush()
+
+	b.Run("Get", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, q := range quantiles {
+				est.Get(q)
+			}
+		}
+	})
+	b.Run("GetAll", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			est.GetAll(quantiles...)
+		}
+	})
+}
+
+func TestCDF(t *testing.T) {
+	check := func(N uint16, seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		unknown, known := New(Unknown(0.01)), New(Known(0.5, 0.01))
+		obs := make([]float64, 1+int(N))
+		for i := range obs {
+			obs[i] = r.ExpFloat64()
+			if i%4 == 0 {
+				obs[i] = math.Floor(obs[i])
+			}
+			unknown.Add(obs[i])
+			known.Add(obs[i])
+		}
+		sort.Float64s(obs)
+		n := float64(len(obs))
+
+		exact := func(v float64) float64 {
+			return float64(sort.Search(len(obs), func(i int) bool { return obs[i] > v })) / n
+		}
+
+		for i := 0; i < 50; i++ {
+			v := obs[r.Intn(len(obs))]
+			if i%2 == 0 {
+				v = r.Float64() * 5
+			}
+			want := exact(v)
+			// relative to the rank, half an observation for the midpoint
+			if got := unknown.CDF(v); math.Abs(got-want) > 0.01*want+0.5/n {
+				t.Logf("unknown n=%d v=%f: want %f, got %f", len(obs), v, want, got)
+				return false
+			}
+		}
+		for _, v := range []float64{obs[len(obs)/2], obs[len(obs)/2-len(obs)/200], obs[len(obs)/2+len(obs)/200]} {
+			want := exact(v)
+			if got := known.CDF(v); math.Abs(got-want) > 0.01+0.5/n {
+				t.Logf("known n=%d v=%f: want %f, got %f", len(obs), v, want, got)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(check, nil); err != nil {
+		t.Error(err)
+	}
+
+	est := New()
+	if got := est.CDF(1); !math.IsNaN(got) {
+		t.Fatalf("want NaN for an empty estimator, got %f", got)
+	}
+	for i := 1; i <= 1000; i++ {
+		est.Add(float64(i))
+	}
+	for v, want := range map[float64]float64{0.5: 0, 1000: 1, 1e6: 1, math.Inf(1): 1, math.Inf(-1): 0} {
+		if got := est.CDF(v); got != want {
+			t.Fatalf("CDF(%f): want %f, got %f", v, want, got)
+		}
+	}
+	if got := est.CDF(math.NaN()); !math.IsNaN(got) {
+		t.Fatalf("want NaN for NaN, got %f", got)
+	}
+	est.Reset()
+	if got := est.CDF(1); !math.IsNaN(got) {
+		t.Fatalf("want NaN after Reset, got %f", got)
+	}
+}
+
+func TestGetWithBounds(t *testing.T) {
+	for _, inv := range [][]Estimate{{Unknown(0.01)}, {HighBiased(0.01)}, {Known(0.5, 0.01), Known(0.99, 0.001)}} {
+		check := func(N uint16, seed int64) bool {
+			r := rand.New(rand.NewSource(seed))
+			est := New(inv...)
+			obs := quantiletest.Normal(0, 1).Generate(1+int(N)%5000, seed)
+			for i := range obs {
+				if i%3 == 0 {
+					obs[i] = math.Floor(obs[i] * 4)
+				}
+				est.Add(obs[i])
+			}
+			sort.Float64s(obs)
+
+			for _, q := range []float64{0, 0.01, 0.1, 0.5, 0.9, 0.99, 1, r.Float64()} {
+				v, lo, hi := est.GetWithBounds(q)
+				exact := obs[int(math.Max(quantileRank(q, float64(len(obs))), 1))-1]
+				if !(lo <= exact && exact <= hi && lo <= v && v <= hi) || v != est.Get(q) {
+					t.Logf("%v n=%d q=%f: want %g and the estimate %g within [%g, %g]", inv, len(obs), q, exact, v, lo, hi)
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(check, nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	est := New(Known(0.5, 0.01))
+	if v, lo, hi := est.GetWithBounds(0.5); !math.IsNaN(v) || !math.IsNaN(lo) || !math.IsNaN(hi) {
+		t.Fatalf("want NaN bounds for an empty estimator, got %f in [%f, %f]", v, lo, hi)
+	}
+	for i := 1; i <= 10000; i++ {
+		est.Add(float64(i))
+	}
+	// the tolerance of 100 ranks, widened to the nearest retained values,
+	// whose neighbors are up to twice the tolerance apart
+	if v, lo, hi := est.GetWithBounds(0.5); lo < 4700 || hi > 5300 || lo > v || v > hi {
+		t.Fatalf("want a tight interval around the median, got %f in [%f, %f]", v, lo, hi)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	check := func(N uint16, seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		est := New(Unknown(0.01))
+		obs := make([]float64, 1+int(N))
+		for i := range obs {
+			obs[i] = math.Floor(r.ExpFloat64() * 4)
+			est.Add(obs[i])
+		}
+		sort.Float64s(obs)
+
+		bounds := []float64{0.5, 1, 2, 4, 8}
+		counts, err := est.Histogram(bounds)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		if len(counts) != len(bounds)+1 {
+			t.Logf("want %d counts, got %v", len(bounds)+1, counts)
+			return false
+		}
+		var total uint64
+		for i, b := range bounds {
+			total += counts[i]
+			// at or below the bound, within the rank error of the CDF
+			below := float64(sort.Search(len(obs), func(k int) bool { return obs[k] > b }))
+			if math.Abs(float64(total)-below) > 0.01*below+1 {
+				t.Logf("n=%d bound %g: want %f at or below, got %d", len(obs), b, below, total)
+				return false
+			}
+		}
+		if total += counts[len(bounds)]; total != uint64(est.Count()) {
+			t.Logf("n=%d: want counts adding up to %d, got %v", len(obs), est.Count(), counts)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, nil); err != nil {
+		t.Error(err)
+	}
+
+	// exact without merges, values on a bound counting below it
+	est := New(Unknown(0.0001))
+	if counts, err := est.Histogram([]float64{1}); err != nil || counts[0] != 0 || counts[1] != 0 {
+		t.Fatalf("want empty buckets for an empty estimator, got %v: %v", counts, err)
+	}
+	for i := 1; i <= 10; i++ {
+		est.Add(float64(i))
+	}
+	counts, err := est.Histogram([]float64{1, 5, 9.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 4, 4, 1}; fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Fatalf("want %v, got %v", want, counts)
+	}
+
+	for _, bounds := range [][]float64{nil, {2, 1}, {1, 1}, {math.NaN()}} {
+		if _, err := est.Histogram(bounds); !errors.Is(err, ErrInvalidBounds) {
+			t.Errorf("bounds %v: want ErrInvalidBounds, got %v", bounds, err)
+		}
+	}
+}
+
+func TestMixedKnownAndUnknown(t *testing.T) {
+	bounds := map[float64]float64{
+		0.99: 0.001, // Known
+		0.01: 0.01,  // Unknown everywhere else
+		0.25: 0.01,
+		0.5:  0.01,
+		0.75: 0.01,
+		0.9:  0.01,
+	}
+
+	check := func(N uint16, seed int64) bool {
+		est := New(Known(0.99, 0.001), Unknown(0.01))
+		obs := quantiletest.Normal(0, 1).Generate(1000+int(N), seed)
+		for _, v := range obs {
+			est.Add(v)
+		}
+		sort.Float64s(obs)
+
+		for q, e := range bounds {
+			if err, ok := quantiletest.WithinRankError(obs, q, est.Get(q), e); !ok {
+				t.Logf("n=%d q=%f: rank error %f exceeds %f", len(obs), q, err, e)
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(check, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKnownEndpoints(t *testing.T) {
+	for _, q := range []float64{0, 1e-9, 1 - 1e-9, 1} {
+		est := New(Known(q, 0.001), Known(0.5, 0.01))
+
+		for _, n := range []float64{1, 10, 1e6} {
+			for r := 0.0; r <= n; r += math.Max(1, n/100) {
+				if f := est.invariant(r, n); math.IsNaN(f) || math.IsInf(f, 0) {
+					t.Fatalf("Known(%g): invariant(%f, %f) = %f", q, r, n, f)
+				}
+			}
+		}
+
+		obs := quantiletest.Normal(0, 1).Generate(20000, 1)
+		for _, v := range obs {
+			est.Add(v)
+		}
+		exact := quantiletest.NewExact(obs)
+
+		quantiletest.AssertWithinRankError(t, exact, est, 0.5, 0.01)
+	}
+}
+
+func TestCompressKeepsExtremes(t *testing.T) {
+	for _, inv := range []Estimate{Known(0.5, 0.05), Known(0.99, 0.01), Unknown(0.05)} {
+		est := New(inv)
+		r := rand.New(rand.NewSource(1))
+		min, max := math.Inf(1), math.Inf(-1)
+		for i, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+			// rare outliers on both sides
+			switch i % 9973 {
+			case 17:
+				v = r.Float64() * 1e6
+			case 4021:
+				v = -r.Float64() * 1e6
+			}
+			min, max = math.Min(min, v), math.Max(max, v)
+			est.Add(v)
+
+			if i%1000 == 999 {
+				est.flush()
+				head, tail := est.extremes()
+				if head != min || tail != max {
+					t.Fatalf("%v after %d: want extremes %f, %f, got %f, %f", inv, i+1, min, max, head, tail)
+				}
+				if est.items[0].delta != 0 {
+					t.Fatalf("%v: want minimum with delta 0, got %f", inv, est.items[0].delta)
+				}
+				if got := est.Get(1); got != max {
+					t.Fatalf("%v after %d: want Get(1) %f, got %f", inv, i+1, max, got)
+				}
+			}
+		}
+		if got := est.Get(0); got != min {
+			t.Errorf("%v: want Get(0) %f, got %f", inv, min, got)
+		}
+	}
+}
+
+func TestMonotoneRunThenOutlier(t *testing.T) {
+	runs := map[string]struct {
+		value    func(i int) float64
+		outlier  float64
+		min, max float64
+	}{
+		"ascending then small":  {func(i int) float64 { return float64(i) }, -1, -1, 99999},
+		"descending then large": {func(i int) float64 { return float64(-i) }, 1, -99999, 1},
+		"constant then small":   {func(int) float64 { return 5 }, 4, 4, 5},
+	}
+	for name, run := range runs {
+		for _, inv := range []Estimate{Known(0.5, 0.05), Known(0.99, 0.001), Unknown(0.05), HighBiased(0.05)} {
+			est := New(inv)
+			for i := 0; i < 100000; i++ {
+				est.Add(run.value(i))
+			}
+			est.Get(0.5)
+			est.Add(run.outlier)
+
+			if got := est.Get(0); got != run.min {
+				t.Errorf("%s %v: want Get(0) %f, got %f", name, inv, run.min, got)
+			}
+			if got := est.Get(1); got != run.max {
+				t.Errorf("%s %v: want Get(1) %f, got %f", name, inv, run.max, got)
+			}
+		}
+	}
+}
+
+func TestUnknownGrid(t *testing.T) {
+	const e = 0.0001
+	quantiles := []float64{0, 0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999, 1}
+	distributions := []quantiletest.Distribution{
+		quantiletest.Normal(0, 1),
+		quantiletest.Exponential(),
+		quantiletest.Pareto(1.5),
+	}
+
+	for _, d := range distributions {
+		name := d.Name
+		check := func(N uint16, seed int64) bool {
+			est := New(Unknown(e))
+			obs := d.Generate(1000+int(N), seed)
+			for _, v := range obs {
+				est.Add(v)
+			}
+			sort.Float64s(obs)
+
+			for _, q := range quantiles {
+				if err, ok := quantiletest.WithinRankError(obs, q, est.Get(q), e); !ok {
+					t.Logf("%s n=%d q=%f: rank error %f exceeds %f", name, len(obs), q, err, e)
+					return false
+				}
+			}
+			return true
+		}
+
+		if err := quick.Check(check, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSignedRankErrorCentered(t *testing.T) {
+	cases := []struct {
+		invariants []Estimate
+		targets    map[float64]float64
+	}{
+		{[]Estimate{Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001)}, map[float64]float64{0.5: 0.01, 0.9: 0.005, 0.99: 0.001}},
+		{[]Estimate{Unknown(0.01)}, map[float64]float64{0.5: 0.01, 0.9: 0.01, 0.99: 0.01}},
+	}
+
+	const streams = 200
+	for _, c := range cases {
+		sum := map[float64]float64{}
+		for seed := int64(0); seed < streams; seed++ {
+			est := New(c.invariants...)
+			obs := quantiletest.Normal(0, 1).Generate(10000, seed)
+			for _, v := range obs {
+				est.Add(v)
+			}
+			sort.Float64s(obs)
+			for q := range c.targets {
+				sum[q] += quantiletest.RankOf(obs, est.Get(q)) - q
+			}
+		}
+
+		// single estimates may use the whole tolerance, their mean should not
+		for q, e := range c.targets {
+			if mean := sum[q] / streams; math.Abs(mean) > e/4 {
+				t.Errorf("%v q=%f: mean signed rank error %f, want within %f", c.invariants, q, mean, e/4)
+			}
+		}
+	}
+}
+
+func TestInterleavedGet(t *testing.T) {
+	targets := map[float64]float64{0.5: 0.01, 0.9: 0.005, 0.99: 0.001}
+	invariants := []Estimate{Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001)}
+
+	for seed := int64(0); seed < 5; seed++ {
+		interleaved, batched := New(invariants...), New(invariants...)
+		obs := quantiletest.Normal(0, 1).Generate(20000, seed)
+		for _, v := range obs {
+			interleaved.Add(v)
+			// every flush commits a single value
+			interleaved.Get(0.5)
+			batched.Add(v)
+		}
+		sort.Float64s(obs)
+
+		for q, e := range targets {
+			a, b := interleaved.Get(q), batched.Get(q)
+			if err, ok := quantiletest.WithinRankError(obs, q, a, e); !ok {
+				t.Errorf("seed %d q=%f: interleaved rank error %f exceeds %f", seed, q, err, e)
+			}
+			if err, ok := quantiletest.WithinRankError(obs, q, b, e); !ok {
+				t.Errorf("seed %d q=%f: batched rank error %f exceeds %f", seed, q, err, e)
+			}
+			if d := math.Abs(quantiletest.RankOf(obs, a) - quantiletest.RankOf(obs, b)); d > 2*e {
+				t.Errorf("seed %d q=%f: interleaved and batched are %f apart, want within %f", seed, q, d, 2*e)
+			}
+		}
+	}
+}
+
+// orderings arrange sorted values the way replayed or batched data arrives.
+var orderings = map[string]func(sorted []float64) []float64{
+	"ascending": func(sorted []float64) []float64 {
+		return append([]float64(nil), sorted...)
+	},
+	"descending": func(sorted []float64) []float64 {
+		out := make([]float64, len(sorted))
+		for i, v := range sorted {
+			out[len(out)-1-i] = v
+		}
+		return out
+	},
+	// up through every other value, then down through the rest
+	"organ-pipe": func(sorted []float64) []float64 {
+		out := make([]float64, 0, len(sorted))
+		for i := 0; i < len(sorted); i += 2 {
+			out = append(out, sorted[i])
+		}
+		for i := len(sorted) - 1 - len(sorted)%2; i > 0; i -= 2 {
+			out = append(out, sorted[i])
+		}
+		return out
+	},
+	// smallest, largest, second smallest, second largest, ...
+	"alternating": func(sorted []float64) []float64 {
+		out := make([]float64, 0, len(sorted))
+		for lo, hi := 0, len(sorted)-1; lo <= hi; lo, hi = lo+1, hi-1 {
+			out = append(out, sorted[lo])
+			if lo != hi {
+				out = append(out, sorted[hi])
+			}
+		}
+		return out
+	},
+}
+
+func TestOrderings(t *testing.T) {
+	targets := map[float64]float64{0.01: 0.001, 0.5: 0.01, 0.9: 0.005, 0.99: 0.001}
+	invariants := []Estimate{Known(0.01, 0.001), Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001)}
+
+	for name, order := range orderings {
+		check := func(N uint16, seed int64) bool {
+			obs := quantiletest.Sorted(quantiletest.Normal(0, 1)).Generate(1000+int(N), seed)
+
+			est := New(invariants...)
+			for _, v := range order(obs) {
+				est.Add(v)
+			}
+
+			for q, e := range targets {
+				if err, ok := quantiletest.WithinRankError(obs, q, est.Get(q), e); !ok {
+					t.Logf("%s n=%d q=%f: rank error %f exceeds %f", name, len(obs), q, err, e)
+					return false
+				}
+			}
+			return true
+		}
+
+		if err := quick.Check(check, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func BenchmarkOrderings(b *testing.B) {
+	sorted := quantiletest.Sorted(quantiletest.Normal(0, 1)).Generate(100000, 1)
+
+	for name, order := range orderings {
+		values := order(sorted)
+		b.Run(name, func(b *testing.B) {
+			est := New(Known(0.01, 0.001), Known(0.5, 0.01), Known(0.99, 0.001))
+			for i := 0; i < b.N; i++ {
+				est.Add(values[i%len(values)])
+			}
+		})
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	targets := map[float64]float64{0.01: 0.001, 0.5: 0.01, 0.9: 0.005, 0.99: 0.001}
+	invariants := []Estimate{Known(0.01, 0.001), Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001)}
+
+	for _, d := range quantiletest.Distributions() {
+		for seed := int64(0); seed < 3; seed++ {
+			obs := d.Generate(50000, seed)
+			est := New(invariants...)
+			for _, v := range obs {
+				est.Add(v)
+			}
+			exact := quantiletest.NewExact(obs)
+			for q, e := range targets {
+				if !quantiletest.AssertWithinRankError(t, exact, est, q, e) {
+					t.Logf("%s seed %d", d.Name, seed)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkDistributions(b *testing.B) {
+	invariants := map[string][]Estimate{
+		"unknown": {Unknown(0.001)},
+		"known":   {Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001)},
+	}
+	for _, d := range quantiletest.Distributions() {
+		values := d.Generate(100000, 1)
+		for name, inv := range invariants {
+			b.Run(d.Name+"/"+name, func(b *testing.B) {
+				est := New(inv...)
+				for i := 0; i < b.N; i++ {
+					est.Add(values[i%len(values)])
+				}
+				b.ReportMetric(float64(len(est.items)), "items")
+			})
+		}
+	}
+}
+
+func TestHeavyTails(t *testing.T) {
+	targets := map[float64]float64{0.5: 0.01, 0.9: 0.005, 0.99: 0.001, 0.999: 0.0001}
+	invariants := []Estimate{Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001), Known(0.999, 0.0001)}
+	distributions := []quantiletest.Distribution{
+		quantiletest.LogNormal(0, 2),
+		quantiletest.Pareto(1.5),
+	}
+
+	for _, d := range distributions {
+		name := d.Name
+		worst := map[float64]float64{}
+		check := func(N uint16, seed int64) bool {
+			est := New(invariants...)
+			obs := d.Generate(10000+int(N), seed)
+			for _, v := range obs {
+				est.Add(v)
+			}
+			sort.Float64s(obs)
+
+			for q, e := range targets {
+				v := est.Get(q)
+				if err, ok := quantiletest.WithinRankError(obs, q, v, e); !ok {
+					t.Logf("%s n=%d q=%f: rank error %f exceeds %f", name, len(obs), q, err, e)
+					return false
+				}
+				exact := obs[int(quantileRank(q, float64(len(obs))))-1]
+				worst[q] = math.Max(worst[q], math.Abs(v-exact)/exact)
+			}
+			return true
+		}
+
+		if err := quick.Check(check, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		// rank bounds say nothing about values in a steep tail
+		for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+			t.Logf("%s q=%g: worst relative value error %.3f", name, q, worst[q])
+		}
+	}
+}
+
+func TestSumMean(t *testing.T) {
+	est := New(Known(0.5, 0.01))
+	if est.Sum() != 0 || !math.IsNaN(est.Mean()) {
+		t.Fatalf("want a sum of 0 and a NaN mean when empty, got %f and %f", est.Sum(), est.Mean())
+	}
+
+	// whole numbers sum exactly, through buffering, flushes and compression
+	sum := 0.0
+	for i := 1; i <= 10000; i++ {
+		est.Add(float64(i % 100))
+		sum += float64(i % 100)
+		if i == 100 && (len(est.buffer) != 100 || est.Sum() != sum) {
+			t.Fatalf("want the buffered sum %f, got %f", sum, est.Sum())
+		}
+		if i%1000 == 0 {
+			est.Get(0.5)
+		}
+	}
+	est.Add(math.NaN())
+	if est.Sum() != sum || est.Mean() != sum/10000 {
+		t.Fatalf("want sum %f and mean %f, got %f and %f", sum, sum/10000, est.Sum(), est.Mean())
+	}
+
+	other := New(Known(0.5, 0.01))
+	other.Add(-sum)
+	if err := est.Merge(other); err != nil || est.Sum() != 0 || est.Mean() != 0 {
+		t.Fatalf("want the merged sum 0, got %f and mean %f (%v)", est.Sum(), est.Mean(), err)
+	}
+
+	est.Reset()
+	if est.Sum() != 0 || !math.IsNaN(est.Mean()) {
+		t.Fatalf("want a sum of 0 and a NaN mean after Reset, got %f and %f", est.Sum(), est.Mean())
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	est := New(Known(0.5, 0.01))
+	if !math.IsNaN(est.Min()) || !math.IsNaN(est.Max()) {
+		t.Fatalf("want NaN for a fresh estimator, got %f and %f", est.Min(), est.Max())
+	}
+
+	est.Add(3)
+	if est.Min() != 3 || est.Max() != 3 {
+		t.Fatalf("want the single sample 3 for both, got %f and %f", est.Min(), est.Max())
+	}
+
+	values := quantiletest.Normal(0, 1).Generate(10000, 1)
+	exact := quantiletest.NewExact(append([]float64{3}, values...))
+	for _, v := range values {
+		est.Add(v)
+	}
+	est.Add(math.NaN())
+	if est.Min() != exact.Get(0) || est.Max() != exact.Get(1) {
+		t.Fatalf("want %f and %f, got %f and %f", exact.Get(0), exact.Get(1), est.Min(), est.Max())
+	}
+	// the extremes are still buffered, unlike the summary
+	buffered := len(est.buffer)
+	est.Add(-100)
+	est.Add(100)
+	if est.Min() != -100 || est.Max() != 100 || len(est.buffer) != buffered+2 {
+		t.Fatalf("want the buffered -100 and 100 without a flush, got %f and %f", est.Min(), est.Max())
+	}
+	if est.Get(0) != est.Min() || est.Get(1) != est.Max() {
+		t.Fatalf("want Get(0) and Get(1) to agree, got %f and %f", est.Get(0), est.Get(1))
+	}
+
+	est.Reset()
+	if !math.IsNaN(est.Min()) || !math.IsNaN(est.Max()) {
+		t.Fatalf("want NaN after Reset, got %f and %f", est.Min(), est.Max())
+	}
+	est.Add(5)
+	if est.Min() != 5 || est.Max() != 5 {
+		t.Fatalf("want 5 after Reset, got %f and %f", est.Min(), est.Max())
+	}
+
+	inf := NewWithOptions([]Option{WithInfPolicy(DropInf)})
+	inf.Add(math.Inf(1))
+	inf.Add(1)
+	inf.Add(math.Inf(-1))
+	if inf.Min() != 1 || inf.Max() != 1 {
+		t.Fatalf("want dropped Inf left out, got %f and %f", inf.Min(), inf.Max())
+	}
+	inf = New()
+	inf.Add(math.Inf(1))
+	inf.Add(1)
+	if inf.Min() != 1 || !math.IsInf(inf.Max(), 1) {
+		t.Fatalf("want a kept Inf as the maximum, got %f and %f", inf.Min(), inf.Max())
+	}
+}
+
+func TestMergeMinMax(t *testing.T) {
+	a, b, empty := New(), New(), New()
+	a.Add(1)
+	a.Add(2)
+	b.Add(-1)
+	b.Add(0)
+
+	if err := a.Merge(empty); err != nil || a.Min() != 1 || a.Max() != 2 {
+		t.Fatalf("want an empty merge to keep 1 and 2, got %f and %f (%v)", a.Min(), a.Max(), err)
+	}
+	if err := empty.Merge(b); err != nil || empty.Min() != -1 || empty.Max() != 0 {
+		t.Fatalf("want -1 and 0 merged into an empty estimator, got %f and %f (%v)", empty.Min(), empty.Max(), err)
+	}
+	if err := a.Merge(b); err != nil || a.Min() != -1 || a.Max() != 2 {
+		t.Fatalf("want -1 and 2, got %f and %f (%v)", a.Min(), a.Max(), err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	var zero Estimator
+	if got := zero.Count(); got != 0 {
+		t.Fatalf("want 0 for the zero Estimator, got %d", got)
+	}
+
+	est := New(Known(0.99, 0.001))
+	if got := est.Count(); got != 0 {
+		t.Fatalf("want 0 for a fresh estimator, got %d", got)
+	}
+
+	// buffered but not flushed
+	for i := 0; i < 100; i++ {
+		est.Add(float64(i))
+	}
+	est.Add(math.NaN())
+	if len(est.buffer) != 100 || est.observations != 0 {
+		t.Fatalf("want 100 buffered values, got %d buffered and %f observations", len(est.buffer), est.observations)
+	}
+	if got := est.Count(); got != 100 {
+		t.Fatalf("want 100 buffered values counted, got %d", got)
+	}
+	if len(est.buffer) != 100 {
+		t.Fatal("Count flushed the buffer")
+	}
+
+	// Get flushes, Add buffers again past a full buffer
+	est.Get(0.5)
+	for i := 0; i < 1000; i++ {
+		est.Add(float64(i))
+	}
+	if got := est.Count(); got != 1100 {
+		t.Fatalf("want 1100 after flushes, got %d", got)
+	}
+	est.Get(0.99)
+	if got := est.Count(); got != 1100 {
+		t.Fatalf("want 1100 after Get, got %d", got)
+	}
+
+	est.Reset()
+	if got := est.Count(); got != 0 {
+		t.Fatalf("want 0 after Reset, got %d", got)
+	}
+}
+
+func TestSamples(t *testing.T) {
+	var zero Estimator
+	if got := zero.Samples(); len(got) != 0 {
+		t.Fatalf("want no samples for the zero Estimator, got %v", got)
+	}
+
+	for _, invariants := range [][]Estimate{{Unknown(0.01)}, {Known(0.5, 0.01), Known(0.99, 0.001)}} {
+		est := New(invariants...)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 100000; i++ {
+			est.Add(float64(r.Intn(1000)))
+		}
+		est.Add(1e6)
+
+		samples := est.Samples()
+		if len(samples) != len(est.items) {
+			t.Fatalf("%v: want %d samples, got %d", invariants, len(est.items), len(samples))
+		}
+		if len(est.buffer) != 0 {
+			t.Fatalf("%v: Samples did not flush", invariants)
+		}
+
+		width := 0.0
+		for i, s := range samples {
+			if i > 0 && s.Value <= samples[i-1].Value {
+				t.Fatalf("%v: sample %d of %v after %v", invariants, i, s.Value, samples[i-1].Value)
+			}
+			width += s.Width
+		}
+		if width != float64(est.Count()) {
+			t.Fatalf("%v: want widths summing to %d, got %f", invariants, est.Count(), width)
+		}
+		if samples[0].Value != 0 || samples[len(samples)-1].Value != 1e6 {
+			t.Fatalf("%v: want samples from 0 to 1e6, got %v to %v", invariants, samples[0].Value, samples[len(samples)-1].Value)
+		}
+
+		// a copy, changing it changes nothing
+		want := est.Get(0.99)
+		for i := range samples {
+			samples[i] = Sample{Value: -1, Width: 1e9}
+		}
+		if got := est.Get(0.99); got != want {
+			t.Fatalf("%v: changing the samples moved Get(0.99) from %v to %v", invariants, want, got)
+		}
+		if again := est.Samples(); again[0].Value != 0 {
+			t.Fatalf("%v: samples aliased the summary, got %v", invariants, again[0])
+		}
+	}
+}
+
+func TestResetMatchesFresh(t *testing.T) {
+	invariants := []Estimate{Known(0.5, 0.01), Known(0.99, 0.001)}
+	est := New(invariants...)
+
+	// a first epoch unlike the second, leaving the slices full of its items
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50000; i++ {
+		est.Add(1e6 * r.ExpFloat64())
+		if i%1000 == 0 {
+			est.Add(math.NaN())
+			est.Get(0.5)
+		}
+	}
+	// leave values in the buffer
+	est.Add(-1e9)
+
+	est.Reset()
+	if got := est.Count(); got != 0 {
+		t.Fatalf("want no samples after Reset, got %d", got)
+	}
+	if got := est.NaNCount(); got != 0 {
+		t.Fatalf("want no NaN after Reset, got %d", got)
+	}
+	if v := est.Get(0.5); !math.IsNaN(v) {
+		t.Fatalf("want the empty estimate NaN after Reset, got %f", v)
+	}
+
+	fresh := New(invariants...)
+	r = rand.New(rand.NewSource(2))
+	for i := 0; i < 30000; i++ {
+		v := r.Float64()
+		est.Add(v)
+		fresh.Add(v)
+	}
+
+	for q := 0; q <= 100; q++ {
+		if got, want := est.Get(float64(q)/100), fresh.Get(float64(q)/100); got != want {
+			t.Fatalf("q=%f: want %f as from a fresh estimator, got %f", float64(q)/100, want, got)
+		}
+	}
+	if len(est.items) != len(fresh.items) || est.observations != fresh.observations {
+		t.Fatalf("want %d items of %f observations, got %d of %f", len(fresh.items), fresh.observations, len(est.items), est.observations)
+	}
+}
+
+func TestResetReusesItems(t *testing.T) {
+	est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+	values := quantiletest.Normal(0, 1).Generate(20000, 1)
+	exact := quantiletest.NewExact(append([]float64(nil), values...))
+
+	cycle := func() {
+		for _, v := range values {
+			est.Add(v)
+		}
+		est.Get(0.5)
+		est.Reset()
+	}
+	// the first cycle grows the slices
+	cycle()
+
+	if allocs := testing.AllocsPerRun(5, cycle); allocs > 0 {
+		t.Fatalf("want no allocations once the slices are grown, got %f per cycle", allocs)
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, v := range values {
+			est.Add(v)
+		}
+		for q, e := range map[float64]float64{0.5: 0.01, 0.99: 0.001} {
+			quantiletest.AssertWithinRankError(t, exact, est, q, e)
+		}
+		est.Reset()
+	}
+}
+
+// checkFinite walks the summary asserting that no rank, delta or invariant
+// has become Inf or NaN and that values stay sorted.
+func checkFinite(t *testing.T, est *Estimator) {
+	rank := 0.0
+	prev := math.Inf(-1)
+	for i := range est.items {
+		cur := &est.items[i]
+		f := est.invariant(rank, est.observations)
+		for _, x := range []float64{cur.rank, cur.delta, f} {
+			if math.IsInf(x, 0) || math.IsNaN(x) {
+				t.Fatalf("at rank %f: rank %f, delta %f, invariant %f", rank, cur.rank, cur.delta, f)
+			}
+		}
+		if cur.v < prev {
+			t.Fatalf("at rank %f: value %g below its predecessor %g", rank, cur.v, prev)
+		}
+		prev = cur.v
+		rank += cur.rank
+	}
+}
+
+func TestExtremeMagnitudes(t *testing.T) {
+	streams := map[string]func(r *rand.Rand) float64{
+		"huge and tiny": func(r *rand.Rand) float64 {
+			if r.Intn(2) == 0 {
+				return 1e300 * r.Float64()
+			}
+			return 1e-300 * r.Float64()
+		},
+		"mixed sign": func(r *rand.Rand) float64 {
+			return math.Copysign(math.MaxFloat64*r.Float64(), r.NormFloat64())
+		},
+		"subnormal": func(r *rand.Rand) float64 {
+			return 5e-324 * float64(r.Intn(1000)-500)
+		},
+		"log uniform": func(r *rand.Rand) float64 {
+			return math.Pow(10, 600*r.Float64()-300)
+		},
+	}
+	targets := map[float64]float64{1e-9: 0.001, 0.5: 0.01, 0.99: 0.001, 1 - 1e-9: 0.001}
+	known := []Estimate{Known(1e-9, 0.001), Known(0.5, 0.01), Known(0.99, 0.001), Known(1-1e-9, 0.001)}
+	invariants := [][]Estimate{
+		known,
+		// a coefficient that overflows to Inf
+		append([]Estimate{Known(5e-324, 0.5)}, known...),
+		// and one that underflows to 0, which allows no merges at all
+		{Known(0.5, 1e-300)},
+	}
+
+	for name, next := range streams {
+		for _, inv := range invariants {
+			est := New(inv...)
+			r := rand.New(rand.NewSource(1))
+			var exact quantiletest.Exact
+			for i := 0; i < 20000; i++ {
+				v := next(r)
+				exact.Add(v)
+				est.Add(v)
+				if i%5000 == 0 {
+					est.flush()
+					checkFinite(t, est)
+				}
+			}
+			est.flush()
+			checkFinite(t, est)
+
+			for q, e := range targets {
+				if !quantiletest.AssertWithinRankError(t, &exact, est, q, e) {
+					t.Logf("%s %v", name, inv)
+				}
+			}
+		}
+	}
+}
+
+func TestObservationCount(t *testing.T) {
+	check := func(ops []uint8, seed int64) bool {
+		est := New(Known(0.5, 0.05), Known(0.99, 0.01))
+		r := rand.New(rand.NewSource(seed))
+		adds := 0
+		for _, op := range ops {
+			switch {
+			case op < 200:
+				// runs of values, some repeated
+				for i := 0; i < int(op); i++ {
+					est.Add(math.Floor(r.NormFloat64() * 10))
+					adds++
+				}
+			case op < 250:
+				est.Get(r.Float64())
+			default:
+				est.Reset()
+				adds = 0
+			}
+
+			width := 0.0
+			for i := range est.items {
+				cur := &est.items[i]
+				width += cur.rank
+			}
+			if est.Count() != int64(adds) || est.observations != width || int(est.observations)+len(est.buffer) != adds {
+				t.Logf("after %d adds: %d samples, %f observations, %f width, %d buffered", adds, est.Count(), est.observations, width, len(est.buffer))
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(check, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInvariantOver(t *testing.T) {
+	est := New(Known(0.5, 0.01), Known(0.9, 0.005), Unknown(0.02))
+	for _, n := range []float64{10, 100, 1000} {
+		for r := 0.0; r <= n; r++ {
+			for w := 0.0; r+w <= n && w < 100; w++ {
+				want := math.Inf(1)
+				for x := r; x <= r+w; x++ {
+					want = math.Min(want, est.invariant(x, n))
+				}
+				if got := est.invariantOver(r, w, n); got != want {
+					t.Fatalf("n=%f: ƒ over %f to %f is %f, want the minimum %f", n, r, r+w, got, want)
+				}
+			}
+		}
+	}
+}
+
+// bounds caches invariant and invariantOver for one n, and must compute the
+// same floors.
+func TestBoundsMatchInvariant(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	estimate := func() Estimate {
+		switch r.Intn(5) {
+		case 0:
+			return Unknown(r.Float64() / 10)
+		case 1:
+			return HighBiased(r.Float64() / 10)
+		case 2:
+			// including 0 and 1 and tolerances clamped by Known
+			return Known(math.Round(r.Float64()*100)/100, r.Float64()/10)
+		case 3:
+			return highBiasedEstimate{}
+		}
+		return Known(r.Float64(), r.Float64()/100)
+	}
+
+	var b bounds
+	for i := 0; i < 2000; i++ {
+		est := &Estimator{}
+		for j := r.Intn(6); j > 0; j-- {
+			est.invariants = append(est.invariants, estimate())
+		}
+		n := math.Floor(math.Exp(r.Float64() * 20))
+		b.reset(est.estimates(), n)
+
+		for k := 0; k < 50; k++ {
+			rank := math.Floor(r.Float64() * n)
+			width := math.Floor(r.Float64() * (n - rank))
+			if k%2 == 0 && len(b.ranks) > 0 {
+				// around the target ranks where Known switches slopes
+				rank = math.Max(0, b.ranks[r.Intn(len(b.ranks))]+float64(r.Intn(3)-1))
+				width = math.Max(0, math.Min(n-rank, float64(r.Intn(10))))
+			}
+			if got, want := b.at(rank), est.invariant(rank, n); got != want {
+				t.Fatalf("%v n=%f: ƒ at %f is %f, want %f", est.invariants, n, rank, got, want)
+			}
+			if got, want := b.over(rank, width), est.invariantOver(rank, width, n); got != want {
+				t.Fatalf("%v n=%f: ƒ over %f to %f is %f, want %f", est.invariants, n, rank, rank+width, got, want)
+			}
+		}
+	}
+}
+
+func TestExactWhileSmall(t *testing.T) {
+	for _, inv := range [][]Estimate{
+		{Unknown(0.001)},
+		{Known(0.5, 0.001)},
+		{Known(0.5, 0.001), Known(0.99, 0.0001)},
+	} {
+		for _, distinct := range []int{10, 100, 1 << 30} {
+			for _, flushEach := range []bool{false, true} {
+				for n := 1; n <= 400; n++ {
+					est := New(inv...)
+
+					// exact as long as the invariant allows no error at any rank
+					largest := 0.0
+					for r := 0; r <= n; r++ {
+						largest = math.Max(largest, est.invariant(float64(r), float64(n)))
+					}
+					if largest >= 1 {
+						break
+					}
+
+					r := rand.New(rand.NewSource(int64(n)))
+					obs := make([]float64, n)
+					for i := range obs {
+						obs[i] = float64(r.Intn(distinct))
+						est.Add(obs[i])
+						if flushEach {
+							// later values land beside runs already summarized
+							est.flush()
+						}
+					}
+					sort.Float64s(obs)
+
+					for q := 0; q <= 200; q++ {
+						// the order statistic at rank ⌈q·n⌉
+						k := int(math.Max(1, quantileRank(float64(q)/200, float64(n))))
+						if got, want := est.Get(float64(q)/200), obs[k-1]; got != want {
+							t.Fatalf("%v flush each %t n=%d q=%f: want %f, got %f", inv, flushEach, n, float64(q)/200, want, got)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// fuzzOps encodes the values as Adds for the fuzz corpus, with a Get of a
+// different quantile after every 50.
+func fuzzOps(values []float64) []byte {
+	var ops []byte
+	for i, v := range values {
+		ops = append(ops, 0, byte(int16(v)>>8), byte(int16(v)))
+		if i%50 == 49 {
+			ops = append(ops, 2, byte(i))
+		}
+	}
+	return ops
+}
+
+func FuzzEstimator(f *testing.F) {
+	// small enough for the fuzzer to mutate quickly
+	sorted := make([]float64, 200)
+	for i := range sorted {
+		sorted[i] = float64(i - 100)
+	}
+	for _, order := range orderings {
+		f.Add(fuzzOps(order(sorted)))
+	}
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		est := New(Known(0.5, 0.01), Known(0.99, 0.001), Unknown(0.05))
+		var obs []float64
+		for len(ops) > 0 {
+			op := ops[0] % 4
+			ops = ops[1:]
+			switch {
+			case op == 0 && len(ops) >= 2:
+				// small integers, so values repeat
+				v := float64(int16(uint16(ops[0])<<8 | uint16(ops[1])))
+				ops = ops[2:]
+				est.Add(v)
+				obs = append(obs, v)
+			case op == 1 && len(ops) >= 8:
+				v := math.Float64frombits(binary.BigEndian.Uint64(ops))
+				ops = ops[8:]
+				est.Add(v)
+				if v == v {
+					obs = append(obs, v)
+				}
+			case op == 2 && len(ops) >= 1:
+				q := float64(ops[0]) / 255
+				ops = ops[1:]
+				v := est.Get(q)
+				if len(obs) == 0 {
+					continue
+				}
+				sorted := append([]float64(nil), obs...)
+				sort.Float64s(sorted)
+				if err, bound := quantiletest.RankError(sorted, q, v), est.GuaranteedError(q); err > bound {
+					t.Fatalf("n=%d q=%f: rank error %f of %g exceeds %f", len(obs), q, err, v, bound)
+				}
+			case op == 3:
+				est.Reset()
+				obs = obs[:0]
+			default:
+				ops = nil
+			}
+
+			if err := est.DebugValidate(); err != nil {
+				t.Fatal(err)
+			}
+			if est.Count() != int64(len(obs)) {
+				t.Fatalf("want %d samples, got %d", len(obs), est.Count())
+			}
+		}
+	})
+}
+
+func TestDebugValidate(t *testing.T) {
+	tail := func(est *Estimator) *item {
+		return &est.items[len(est.items)-1]
+	}
+	corruptions := map[string]func(est *Estimator){
+		"order":    func(est *Estimator) { est.items[1].v = est.items[0].v - 1 },
+		"width":    func(est *Estimator) { est.items[1].rank = 0 },
+		"delta":    func(est *Estimator) { est.items[1].delta = -1 },
+		"copies":   func(est *Estimator) { est.items[1].copies = est.items[1].rank + 1 },
+		"bound":    func(est *Estimator) { est.items[1].delta = est.items[2].rank + est.items[2].delta },
+		"minimum":  func(est *Estimator) { est.items[0].delta = 1 },
+		"maximum":  func(est *Estimator) { tail(est).delta = 1 },
+		"observed": func(est *Estimator) { est.observations++ },
+		"NaN":      func(est *Estimator) { est.items[1].v = math.NaN() },
+		"infinite width": func(est *Estimator) {
+			est.items[1].rank = math.Inf(1)
+			est.observations = math.Inf(1)
+		},
+		"NaN width": func(est *Estimator) {
+			est.items[1].rank = math.NaN()
+			est.observations = math.NaN()
+		},
+		"infinite delta": func(est *Estimator) { est.items[1].delta = math.Inf(1) },
+		"NaN observed":   func(est *Estimator) { est.observations = math.NaN() },
+		"uncountable": func(est *Estimator) {
+			tail(est).rank += 0x1p63
+			est.observations += 0x1p63
+		},
+	}
+
+	for name, corrupt := range corruptions {
+		est := New(Unknown(0.01))
+		for i := 0; i < 1000; i++ {
+			est.Add(float64(i % 37))
+		}
+		est.flush()
+		if err := est.DebugValidate(); err != nil {
+			t.Fatalf("%s: valid summary reported %v", name, err)
+		}
+
+		corrupt(est)
+		if err := est.DebugValidate(); err == nil {
+			t.Errorf("%s: corruption not reported", name)
+		} else {
+			t.Logf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestMergeSymmetric(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	a, b := New(Known(0.5, 0.01), Unknown(0.02)), New(Known(0.5, 0.01), Unknown(0.02))
+	ab, ba := New(Known(0.5, 0.01), Unknown(0.02)), New(Known(0.5, 0.01), Unknown(0.02))
+	for i, v := range quantiletest.Normal(0, 1).Generate(20000, 1) {
+		if i%3 == 0 {
+			// overlapping values on both sides
+			v = math.Floor(v * 10)
+		}
+		if r.Intn(2) == 0 {
+			a.Add(v)
+			ab.Add(v)
+		} else {
+			b.Add(v)
+			ba.Add(v)
+		}
+	}
+
+	if err := ab.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := ba.Merge(a); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ab.items) != len(ba.items) {
+		t.Fatalf("a.Merge(b) has %d items, b.Merge(a) %d", len(ab.items), len(ba.items))
+	}
+	for i, x := range ab.items {
+		if y := ba.items[i]; x != y {
+			t.Fatalf("a.Merge(b) has %v where b.Merge(a) has %v", x, y)
+		}
+	}
+}
+
+func TestMergeShardOrder(t *testing.T) {
+	unknown := map[float64]float64{0.01: 0.01, 0.1: 0.01, 0.5: 0.01, 0.9: 0.01, 0.99: 0.01}
+	known := map[float64]float64{0.5: 0.01, 0.99: 0.001}
+	cases := []struct {
+		invariants []Estimate
+		targets    map[float64]float64
+	}{
+		{[]Estimate{Unknown(0.01)}, unknown},
+		{[]Estimate{Known(0.5, 0.01), Known(0.99, 0.001)}, known},
+	}
+	r := rand.New(rand.NewSource(1))
+
+	for _, c := range cases {
+		for _, k := range []int{2, 5, 16} {
+			obs := make([]float64, 50000)
+			shards := make([][]float64, k)
+			for i := range obs {
+				obs[i] = r.ExpFloat64()
+				shard := r.Intn(k)
+				shards[shard] = append(shards[shard], obs[i])
+			}
+			sort.Float64s(obs)
+
+			var estimates []map[float64]float64
+			for order := 0; order < 10; order++ {
+				merged := New(c.invariants...)
+				for _, i := range r.Perm(k) {
+					shard := New(c.invariants...)
+					for _, v := range shards[i] {
+						shard.Add(v)
+					}
+					if err := merged.Merge(shard); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if merged.Count() != int64(len(obs)) {
+					t.Fatalf("%d shards: want %d samples, got %d", k, len(obs), merged.Count())
+				}
+
+				got := make(map[float64]float64, len(c.targets))
+				for q := range c.targets {
+					got[q] = merged.Get(q)
+				}
+				estimates = append(estimates, got)
+			}
+
+			for _, x := range estimates {
+				for _, y := range estimates {
+					for q, epsilon := range c.targets {
+						if d := quantiletest.RankDistance(obs, x[q], y[q]); d > 2*epsilon {
+							t.Errorf("%v %d shards q=%f: estimates %f and %f are %f ranks apart", c.invariants, k, q, x[q], y[q], d)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMergeUnion(t *testing.T) {
+	targets := map[float64]float64{0.01: 0.001, 0.5: 0.01, 0.9: 0.005, 0.99: 0.001}
+	cases := []struct {
+		name       string
+		invariants []Estimate
+		a, b       quantiletest.Distribution
+	}{
+		// error relative to the rank adds up across shards whatever their
+		// distributions
+		{"unknown", []Estimate{Unknown(0.001)}, quantiletest.Normal(0, 1), quantiletest.LogNormal(0, 1)},
+		// a target rank of the union is near the target rank of each shard
+		// only while the shards are alike
+		{"known", []Estimate{Known(0.01, 0.001), Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001)},
+			quantiletest.Normal(0, 1), quantiletest.Normal(0, 1)},
+	}
+
+	for _, c := range cases {
+		check := func(N, M uint16, seed int64) bool {
+			a, b, union := New(c.invariants...), New(c.invariants...), New(c.invariants...)
+			xs := c.a.Generate(1000+int(N), seed)
+			ys := c.b.Generate(1000+int(M), seed+1)
+			for _, v := range xs {
+				a.Add(v)
+				union.Add(v)
+			}
+			for _, v := range ys {
+				b.Add(v)
+				union.Add(v)
+			}
+			if err := a.Merge(b); err != nil {
+				t.Log(err)
+				return false
+			}
+
+			obs := append(xs, ys...)
+			sort.Float64s(obs)
+			for q, e := range targets {
+				if err, ok := quantiletest.WithinRankError(obs, q, a.Get(q), e); !ok {
+					t.Logf("%s n=%d+%d q=%f: merged rank error %f exceeds %f", c.name, len(xs), len(ys), q, err, e)
+					return false
+				}
+				if d := quantiletest.RankDistance(obs, a.Get(q), union.Get(q)); d > 2*e {
+					t.Logf("%s n=%d+%d q=%f: merged and union %f apart", c.name, len(xs), len(ys), q, d)
+					return false
+				}
+			}
+			return a.Count() == union.Count()
+		}
+
+		if err := quick.Check(check, &quick.Config{MaxCount: 20}); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestMergeLeavesOtherUsable(t *testing.T) {
+	a, b := New(Unknown(0.01)), New(Unknown(0.01))
+	for _, v := range quantiletest.Exponential().Generate(5000, 1) {
+		b.Add(v)
+	}
+	before := make([]float64, 0, 101)
+	for q := 0; q <= 100; q++ {
+		before = append(before, b.Get(float64(q)/100))
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	for q := 0; q <= 100; q++ {
+		if got := b.Get(float64(q) / 100); got != before[q] {
+			t.Fatalf("q=%f: merging changed the other from %f to %f", float64(q)/100, before[q], got)
+		}
+	}
+
+	b.Add(1e9)
+	if b.Get(1) != 1e9 || a.Get(1) == 1e9 || b.Count() != 5001 || a.Count() != 5000 {
+		t.Fatal("want the other independent of the receiver after the merge")
+	}
+}
+
+func TestMergeTargetMismatch(t *testing.T) {
+	a := New(Known(0.5, 0.01), Known(0.99, 0.001))
+	a.Add(1)
+
+	// the same targets in another order merge
+	if err := a.Merge(New(Known(0.99, 0.001), Known(0.5, 0.01))); err != nil {
+		t.Fatal(err)
+	}
+	// so does the zero Estimator into one with the default
+	var zero Estimator
+	if err := zero.Merge(New()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, other := range []*Estimator{
+		New(Known(0.5, 0.01)),
+		New(Known(0.5, 0.01), Known(0.99, 0.0001)),
+		New(Unknown(0.01)),
+	} {
+		other.Add(2)
+		if err := a.Merge(other); !errors.Is(err, ErrTargetMismatch) {
+			t.Fatalf("want ErrTargetMismatch, got %v", err)
+		}
+	}
+	if a.Count() != 1 {
+		t.Fatalf("want failed merges to leave 1 value, got %d", a.Count())
+	}
+}
+
+func TestMergeItself(t *testing.T) {
+	est := New()
+	est.Add(1)
+	if err := est.Merge(est); err == nil {
+		t.Fatal("want an error merging an estimator into itself")
+	}
+	if got := est.Count(); got != 1 {
+		t.Fatalf("want 1 sample after the failed merge, got %d", got)
+	}
+}
+
+func TestMergeEmpty(t *testing.T) {
+	est, empty := New(), New()
+	if err := est.Merge(empty); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := est.GetOK(0.5); ok {
+		t.Fatal("merging two empty estimators observed values")
+	}
+
+	empty.Add(1)
+	empty.Add(2)
+	if err := est.Merge(empty); err != nil {
+		t.Fatal(err)
+	}
+	if got := est.Get(1); got != 2 || empty.Get(1) != 2 {
+		t.Fatalf("want the maximum 2 on both sides, got %f and %f", got, empty.Get(1))
+	}
+}
+
+func TestMergeSamples(t *testing.T) {
+	invariants := []Estimate{Known(0.1, 0.01), Known(0.99, 0.001)}
+	a, b, merged := New(invariants...), New(invariants...), New(invariants...)
+	var exact quantiletest.Exact
+	for i, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		exact.Add(v)
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+
+	if err := merged.Merge(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := merged.MergeSamples(b.Samples()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := merged.Count(), int64(100000); got != want {
+		t.Fatalf("want %d merged values, got %d", want, got)
+	}
+	if merged.Min() != exact.Get(0) || merged.Max() != exact.Get(1) {
+		t.Fatalf("want extremes %f and %f, got %f and %f", exact.Get(0), exact.Get(1), merged.Min(), merged.Max())
+	}
+	for _, q := range []float64{0.1, 0.99} {
+		quantiletest.AssertWithinRankError(t, &exact, merged, q, merged.GuaranteedError(q))
+	}
+
+	// merging the samples is merging the estimator
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []float64{0, 0.1, 0.5, 0.99, 1} {
+		if got, want := merged.Get(q), a.Get(q); got != want {
+			t.Fatalf("q=%f: want %f as merged by Merge, got %f", q, want, got)
+		}
+	}
+}
+
+func TestMergeSamplesInvalid(t *testing.T) {
+	for _, samples := range [][]Sample{
+		{{Value: 2, Width: 1}, {Value: 1, Width: 1}},
+		{{Value: 1, Width: 0}, {Value: 2, Width: 1}},
+		{{Value: 1, Width: 1, Delta: 3}, {Value: 2, Width: 1}},
+		{{Value: 1, Width: 1}, {Value: 2, Width: 1, Delta: 3}},
+		{{Value: 1, Width: 1}, {Value: 2, Width: 1, Delta: -1}, {Value: 3, Width: 1}},
+		{{Value: 1, Width: 1}, {Value: 2, Width: 1, Delta: 3}, {Value: 3, Width: 1}},
+		{{Value: math.NaN(), Width: 1}},
+		{{Value: math.Inf(-1), Width: 1}, {Value: 2, Width: 1}},
+		{{Value: 1, Width: 1}, {Value: math.Inf(1), Width: 1}},
+		{{Value: 1, Width: math.Inf(1)}, {Value: 2, Width: 1}},
+		{{Value: 1, Width: math.NaN()}, {Value: 2, Width: 1}},
+		{{Value: 1, Width: -1}, {Value: 2, Width: 1}},
+		{{Value: 1, Width: 1}, {Value: 2, Width: 1, Delta: math.Inf(1)}, {Value: 3, Width: 1}},
+		{{Value: 1, Width: 1}, {Value: 2, Width: 1, Delta: math.NaN()}, {Value: 3, Width: 1}},
+		{{Value: 1, Width: 0x1p62}, {Value: 2, Width: 0x1p62}},
+		{{Value: 1, Width: 1e300}, {Value: 2, Width: 1e300}},
+	} {
+		est := New()
+		est.Add(1)
+		if err := est.MergeSamples(samples); !errors.Is(err, ErrCorruptData) {
+			t.Fatalf("%v: want ErrCorruptData, got %v", samples, err)
+		}
+		if est.Count() != 1 || est.Sum() != 1 || est.InfCount() != 0 {
+			t.Fatalf("%v: want the failed merge to leave 1 value, got %d", samples, est.Count())
+		}
+	}
+
+	est := New(Unknown(0.01))
+	if err := est.MergeSamples(New().Samples()); err != nil {
+		t.Fatalf("want no error merging no samples, got %v", err)
+	}
+	if err := est.MergeSamples([]Sample{{Value: 1, Width: 1}}); err != nil {
+		t.Fatalf("want no error merging a sample, got %v", err)
+	}
+}
+
+// weightedRankError is how far the cumulative weights occupied by v are from
+// quantile of the total weight, as a fraction of it.
+func weightedRankError(values []weighted, quantile, v float64) float64 {
+	total, below, upto := 0.0, 0.0, 0.0
+	for _, x := range values {
+		total += x.w
+		if x.v < v {
+			below += x.w
+		}
+		if x.v <= v {
+			upto += x.w
+		}
+	}
+	rank := quantile * total
+	switch {
+	case rank < below:
+		return (below - rank) / total
+	case rank > upto:
+		return (rank - upto) / total
+	}
+	return 0
+}
+
+func TestAddWeighted(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		weight    func(r *rand.Rand) float64
+		estimates []Estimate
+	}{
+		{"whole", func(r *rand.Rand) float64 { return float64(r.Intn(20) + 1) }, []Estimate{Unknown(0.01)}},
+		{"fractional", func(r *rand.Rand) float64 { return r.ExpFloat64() }, []Estimate{Unknown(0.01)}},
+		{"targeted", func(r *rand.Rand) float64 { return 0.1 + r.Float64() }, []Estimate{Known(0.5, 0.01), Known(0.99, 0.001)}},
+	} {
+		r := rand.New(rand.NewSource(1))
+		est := New(c.estimates...)
+		var values []weighted
+		total, sum := 0.0, 0.0
+		for _, v := range quantiletest.Normal(0, 1).Generate(50000, 1) {
+			// some values plain, some repeated
+			if r.Intn(4) == 0 {
+				est.Add(v)
+				values = append(values, weighted{v: v, w: 1})
+				total++
+				sum += v
+				continue
+			}
+			v = math.Round(v*100) / 100
+			w := c.weight(r)
+			if err := est.AddWeighted(v, w); err != nil {
+				t.Fatal(err)
+			}
+			values = append(values, weighted{v: v, w: w})
+			total += w
+			sum += v * w
+		}
+
+		if got, want := est.Count(), int64(total); got != want {
+			t.Errorf("%s: want a count of %d, got %d", c.name, want, got)
+		}
+		if got := est.Mean(); math.Abs(got-sum/total) > 1e-9 {
+			t.Errorf("%s: want the weighted mean %f, got %f", c.name, sum/total, got)
+		}
+		for _, q := range []float64{0, 0.01, 0.1, 0.5, 0.9, 0.99, 1} {
+			v := est.Get(q)
+			// one observation of slack for the rounding of q·n
+			if err, want := weightedRankError(values, q, v), est.GuaranteedError(q)+1/total; err > want {
+				t.Errorf("%s: q=%g: estimate %g has weighted rank error %g, want at most %g", c.name, q, v, err, want)
+			}
+		}
+		if err := est.DebugValidate(); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+}
+
+func TestAddWeightedItem(t *testing.T) {
+	est := New()
+	if err := est.AddWeighted(5, 2.5); err != nil {
+		t.Fatal(err)
+	}
+	if got := est.Count(); got != 2 {
+		t.Fatalf("want the buffered weight 2.5 counted as 2, got %d", got)
+	}
+	if est.Min() != 5 || est.Max() != 5 || est.Sum() != 12.5 {
+		t.Fatalf("want extremes 5 and sum 12.5, got %f, %f and %f", est.Min(), est.Max(), est.Sum())
+	}
+	if got := est.Stats().Buffered; got != 1 {
+		t.Fatalf("want 1 buffered value, got %d", got)
+	}
+
+	est.Add(1)
+	est.Add(9)
+	if got := est.Get(0.5); got != 5 {
+		t.Fatalf("want the median 5, got %f", got)
+	}
+	if len(est.items) != 3 || est.items[1].rank != 2.5 || est.items[1].copies != 2.5 || est.observations != 4.5 {
+		t.Fatalf("want one item of width 2.5 among 4.5 observations, got %d items, width %f, %f observations", len(est.items), est.items[1].rank, est.observations)
+	}
+	if est.pending != 0 || len(est.weighted) != 0 {
+		t.Fatalf("want the weighted buffer flushed, got %d values of weight %f", len(est.weighted), est.pending)
+	}
+}
+
+func TestAddWeightedInvalid(t *testing.T) {
+	est := New()
+	for _, w := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if err := est.AddWeighted(1, w); !errors.Is(err, ErrInvalidWeight) {
+			t.Fatalf("weight %f: want ErrInvalidWeight, got %v", w, err)
+		}
+	}
+	if err := est.AddWeighted(math.NaN(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if est.Count() != 0 || est.NaNCount() != 1 {
+		t.Fatalf("want no values and 1 NaN, got %d and %d", est.Count(), est.NaNCount())
+	}
+
+	est = NewWithOptions([]Option{WithInfPolicy(DropInf)})
+	if err := est.AddWeighted(math.Inf(1), 2); err != nil || est.Count() != 0 || est.InfCount() != 1 {
+		t.Fatalf("want the Inf dropped and counted, got %d values and %d Infs (%v)", est.Count(), est.InfCount(), err)
+	}
+}
+
+func TestAddWeightedUncountable(t *testing.T) {
+	est := New()
+	est.Add(3)
+	for _, w := range []float64{1e300, maxObservations} {
+		if err := est.AddWeighted(1, w); !errors.Is(err, ErrInvalidWeight) {
+			t.Fatalf("weight %g: want ErrInvalidWeight, got %v", w, err)
+		}
+	}
+	if err := est.AddWeighted(2, 0x1p62); err != nil {
+		t.Fatal(err)
+	}
+	if err := est.AddWeighted(1, 0x1p62); !errors.Is(err, ErrInvalidWeight) {
+		t.Fatalf("want ErrInvalidWeight past 2^63 in total, got %v", err)
+	}
+
+	// past 2^53 the count is as exact as a float64
+	if got := est.Count(); got < 1<<62 || got > 1<<62+1 {
+		t.Fatalf("want a count of 2^62+1, got %d", got)
+	}
+	if err := est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := est.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := New()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count() != est.Count() || decoded.Get(0.5) != 2 || decoded.Max() != 3 {
+		t.Fatalf("want %d values with the median 2 and maximum 3 decoded, got %d, %g and %g",
+			est.Count(), decoded.Count(), decoded.Get(0.5), decoded.Max())
+	}
+}
+
+func TestAddN(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+	exact := quantiletest.NewExact(nil)
+	var total int64
+	for i := 0; i < 2000; i++ {
+		// response sizes, a few of them repeated thousands of times
+		v := math.Round(r.ExpFloat64() * 1000)
+		n := uint64(r.Intn(10))
+		if r.Intn(20) == 0 {
+			n = uint64(1000 + r.Intn(5000))
+		}
+		est.AddN(v, n)
+		for j := uint64(0); j < n; j++ {
+			exact.Add(v)
+		}
+		total += int64(n)
+		if est.Count() != total {
+			t.Fatalf("want a count of exactly %d, got %d", total, est.Count())
+		}
+	}
+
+	for _, q := range []float64{0, 0.5, 0.99, 1} {
+		got := est.Get(q)
+		if e := exact.RankError(q, got); e > est.GuaranteedError(q) {
+			t.Errorf("q=%g: estimate %g has rank error %g, want at most %g", q, got, e, est.GuaranteedError(q))
+		}
+	}
+	if err := est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+
+	est = New()
+	est.AddN(1, 0)
+	est.AddN(math.NaN(), 3)
+	if est.Count() != 0 || est.NaNCount() != 3 {
+		t.Fatalf("want no values and 3 NaNs, got %d and %d", est.Count(), est.NaNCount())
+	}
+	est.AddN(5, 10000)
+	est.AddN(1, 1)
+	if got := est.Get(0.5); got != 5 || est.Sum() != 50001 {
+		t.Fatalf("want the median 5 and sum 50001, got %f and %f", got, est.Sum())
+	}
+	if len(est.items) != 2 || est.items[1].rank != 10000 || est.items[1].copies != 10000 {
+		t.Fatalf("want one item of width 10000, got %+v", est.items)
+	}
+}
+
+func TestAddNBounds(t *testing.T) {
+	est := New()
+	est.AddN(1, 10)
+	est.AddN(2, math.MaxUint64)
+	if got, want := est.Count(), int64(maxWeight); got != want {
+		t.Fatalf("want the count capped at %d, got %d", want, got)
+	}
+	est.AddN(3, math.MaxUint64)
+	est.AddN(4, 2)
+	if got, want := est.Count(), int64(maxWeight); got != want || est.Max() != 2 {
+		t.Fatalf("want a full estimator to add nothing, got a count of %d and maximum %g", got, est.Max())
+	}
+	if err := est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := est.Get(1); got != 2 {
+		t.Fatalf("want the maximum 2, got %g", got)
+	}
+
+	est.AddN(math.NaN(), math.MaxUint64)
+	est.AddN(math.NaN(), math.MaxUint64)
+	est.AddN(math.Inf(1), math.MaxUint64)
+	est.AddN(math.Inf(-1), math.MaxUint64)
+	if est.NaNCount() != math.MaxInt || est.InfCount() != math.MaxInt {
+		t.Fatalf("want the NaN and infinity counts to saturate, got %d and %d", est.NaNCount(), est.InfCount())
+	}
+}
+
+func BenchmarkAddN(b *testing.B) {
+	debug = false
+	defer func() { debug = true }()
+
+	// a size repeated 10000 times, buffered once rather than flushing
+	// almost 20 full buffers
+	b.Run("Add", func(b *testing.B) {
+		est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 10000; j++ {
+				est.Add(float64(i % 100))
+			}
+		}
+	})
+	b.Run("AddN", func(b *testing.B) {
+		est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+		for i := 0; i < b.N; i++ {
+			est.AddN(float64(i%100), 10000)
+		}
+	})
+}
+
+func TestDuplicateBoundaries(t *testing.T) {
+	mixtures := [][]float64{
+		// fractions of the values 0, 1, 2, ...
+		{0.9, 0.1},
+		{0.5, 0.5},
+		{0.1, 0.9},
+		{0.3, 0.3, 0.4},
+		{0.2, 0.6, 0.2},
+	}
+	invariants := [][]Estimate{
+		{Unknown(0.01)},
+		{Known(0.5, 0.01), Known(0.9, 0.01)},
+	}
+
+	const n = 100000
+	for _, mix := range mixtures {
+		obs := make([]float64, 0, n)
+		var boundaries []int
+		for v, f := range mix {
+			for i := 0; i < int(f*n); i++ {
+				obs = append(obs, float64(v))
+			}
+			boundaries = append(boundaries, len(obs))
+		}
+
+		for _, inv := range invariants {
+			est := New(inv...)
+			for _, i := range rand.New(rand.NewSource(1)).Perm(len(obs)) {
+				est.Add(obs[i])
+			}
+
+			for _, k := range boundaries {
+				for _, rank := range []int{k - 1000, k - 1, k, k + 1, k + 1000} {
+					if rank < 1 || rank > len(obs) {
+						continue
+					}
+					q := float64(rank) / float64(len(obs))
+					if got, want := est.Get(q), obs[rank-1]; got != want {
+						t.Errorf("%v %v: Get(%f) at rank %d of boundary %d: want %f, got %f", mix, inv, q, rank, k, want, got)
+					}
+				}
+			}
+		}
+	}
+
+	// the example from the tie-breaking request
+	est := New(Unknown(0.01))
+	for i := 0; i < n; i++ {
+		est.Add(float64(i % 10 / 9))
+	}
+	for q, want := range map[float64]float64{0.5: 0, 0.89: 0, 0.9: 0, 0.91: 1, 0.95: 1} {
+		if got := est.Get(q); got != want {
+			t.Errorf("90%% zeros: Get(%f) want %f, got %f", q, want, got)
+		}
+	}
+}
+
+func TestRepeatedGetStable(t *testing.T) {
+	targets := []float64{0.5, 0.9, 0.99}
+	est := New(Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001))
+	r := rand.New(rand.NewSource(1))
+	for i := 1; i <= 50000; i++ {
+		est.Add(r.ExpFloat64())
+		if i%7919 != 0 {
+			continue
+		}
+
+		for _, q := range targets {
+			first := est.Get(q)
+			for burst := 1; burst < 10; burst++ {
+				if got := est.Get(q); math.Float64bits(got) != math.Float64bits(first) {
+					t.Fatalf("n=%d q=%f: call %d returned %g after %g", i, q, burst+1, got, first)
+				}
+			}
+		}
+
+		// a compress pass reaches its fixed point, so another finds nothing
+		items := len(est.items)
+		est.compress()
+		if len(est.items) != items {
+			t.Fatalf("n=%d: second compress went from %d to %d items", i, items, len(est.items))
+		}
+	}
+}
+
+func TestQuantileRankBoundaries(t *testing.T) {
+	// q·n lands just above a whole rank in floating point for all of these
+	percents := []int{7, 14, 28, 50, 55, 56}
+
+	for _, n := range []int{100, 10000} {
+		var inv []Estimate
+		for _, p := range percents {
+			inv = append(inv, Known(float64(p)/100, 0.001))
+		}
+
+		// runs of equal values that end exactly at every target rank
+		obs := make([]float64, n)
+		for i := range obs {
+			for _, p := range percents {
+				if i+1 > p*n/100 {
+					obs[i]++
+				}
+			}
+		}
+
+		for seed := int64(0); seed < 5; seed++ {
+			est := New(inv...)
+			for _, i := range rand.New(rand.NewSource(seed)).Perm(n) {
+				est.Add(obs[i])
+			}
+
+			for j, p := range percents {
+				q := float64(p) / 100
+				if got, want := est.Get(q), float64(j); got != want {
+					t.Errorf("n=%d seed %d: Get(%f) at the end of run %d: got %f", n, seed, q, j, got)
+				}
+				if got, want := est.Get(q+1/float64(n)), float64(j+1); got != want {
+					t.Errorf("n=%d seed %d: Get(%f) after run %d: want %f, got %f", n, seed, q+1/float64(n), j, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestAddTarget(t *testing.T) {
+	invariants := []Estimate{Known(0.5, 0.01)}
+	est, without := New(invariants...), New(invariants...)
+	exact := quantiletest.NewExact(nil)
+	values := quantiletest.LogNormal(0, 1).Generate(402000, 1)
+	const before = 2000
+	for _, v := range values[:before] {
+		est.Add(v)
+		without.Add(v)
+		exact.Add(v)
+	}
+
+	if err := est.AddTarget(Known(0.999, 0.0001)); err != nil {
+		t.Fatal(err)
+	}
+	if len(invariants) != 1 || cap(invariants) != 1 {
+		t.Fatalf("want the invariants passed to New left alone, got %v", invariants)
+	}
+	for _, v := range values[before:] {
+		est.Add(v)
+		without.Add(v)
+		exact.Add(v)
+	}
+
+	// the observations before the call were compressed to Known(0.5, 0.01)
+	// alone, which allows items of 2·0.01/0.5 of them at the rank of 0.999,
+	// and Get to miss half of that
+	n := float64(len(values))
+	want := 0.0001 + 0.02*before/n
+	if e := exact.RankError(0.999, est.Get(0.999)); e > want {
+		t.Errorf("want the rank error of 0.999 within %g, got %g", want, e)
+	}
+	if e := exact.RankError(0.999, without.Get(0.999)); e <= want {
+		t.Errorf("want the estimator without the target less accurate than %g, got %g", want, e)
+	}
+	if err := est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := est.AddTarget(Known(99.9, 0.0001)); !errors.Is(err, ErrInvalidQuantile) {
+		t.Fatalf("want ErrInvalidQuantile for the quantile 99.9, got %v", err)
+	}
+	if err := est.AddTarget(Known(0.999, 0.0001)); err != nil || len(est.invariants) != 2 {
+		t.Fatalf("want a target added twice kept once, got %v and %v", est.invariants, err)
+	}
+
+	// a zero Estimator keeps its default invariant
+	var zero Estimator
+	if err := zero.AddTarget(Known(0.9, 0.01)); err != nil || len(zero.invariants) != 2 || len(defaultInvariants) != 1 {
+		t.Fatalf("want the default and the new target, got %v and %v", zero.invariants, err)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	est := New(Known(0.99, 0.001), Unknown(0.01), Known(0.9, 0.5), HighBiased(0.02), Known(1, 0.1))
+	est.AddTarget(Known(0.5, 0.05))
+
+	want := []struct{ q, tolerance float64 }{
+		{0.99, 0.001}, {math.NaN(), 0.01}, {0.9, 0.5}, {math.NaN(), 0.02}, {1, 0}, {0.5, 0.05},
+	}
+	targets := est.Targets()
+	if len(targets) != len(want) {
+		t.Fatalf("want %d targets, got %v", len(want), targets)
+	}
+	for i, f := range targets {
+		target, ok := f.(Target)
+		if !ok {
+			t.Fatalf("target %d: want a Target, got %T", i, f)
+		}
+		if q := target.Quantile(); !(q == want[i].q || q != q && want[i].q != want[i].q) || target.Tolerance() != want[i].tolerance {
+			t.Errorf("target %d: want %g and %g, got %g and %g", i, want[i].q, want[i].tolerance, q, target.Tolerance())
+		}
+	}
+
+	// copies, which rebuild the same estimates
+	targets[0] = Unknown(0.1)
+	if est.Targets()[0].(Target).Quantile() != 0.99 {
+		t.Fatalf("want Targets to return a copy")
+	}
+	if f := est.Targets()[2].(Target); !reflect.DeepEqual(Known(f.Quantile(), f.Tolerance()), f) {
+		t.Fatalf("want the clamped Known rebuilt from its tolerance, got %v", f)
+	}
+
+	var zero Estimator
+	if targets := zero.Targets(); len(targets) != 1 || targets[0].(Target).Tolerance() != 0.1 {
+		t.Fatalf("want the default Unknown(0.1) of a zero Estimator, got %v", targets)
+	}
+}
+
+func TestKnownClamped(t *testing.T) {
+	for _, c := range []struct{ q, e, clamped float64 }{
+		{0.01, 0.05, 0.01},
+		{0.99, 0.05, 0.01},
+		{0.5, 0.7, 0.5},
+		{0.001, 0.01, 0.001},
+	} {
+		est := New(Known(c.q, c.e))
+		if warnings := est.Warnings(); len(warnings) != 1 {
+			t.Errorf("Known(%g, %g): want 1 warning, got %v", c.q, c.e, warnings)
+		} else {
+			t.Log(warnings[0])
+		}
+
+		obs := quantiletest.Normal(0, 1).Generate(20000, 1)
+		for _, v := range obs {
+			est.Add(v)
+		}
+		sort.Float64s(obs)
+
+		if err := quantiletest.RankError(obs, c.q, est.Get(c.q)); err > c.clamped {
+			t.Errorf("Known(%g, %g): rank error %f exceeds the clamped %f", c.q, c.e, err, c.clamped)
+		}
+		for rank := 0.0; rank <= est.observations; rank++ {
+			if f := est.invariant(rank, est.observations); f > est.observations {
+				t.Fatalf("Known(%g, %g): invariant %f at rank %f exceeds %f observations", c.q, c.e, f, rank, est.observations)
+			}
+		}
+	}
+
+	if warnings := New(Known(0.01, 0.01), Known(0.5, 0.05), Unknown(0.9)).Warnings(); len(warnings) != 0 {
+		t.Errorf("want no warnings for tolerances within the boundaries, got %v", warnings)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	est := New(Unknown(0.01))
+	est.Flush()
+	if s := est.Stats(); s.Flushes != 0 || len(est.items) != 0 {
+		t.Fatalf("want flushing nothing to do nothing, got %+v", s)
+	}
+
+	for i := 0; i < 100; i++ {
+		est.Add(float64(i))
+	}
+	est.Flush()
+	if s := est.Stats(); s.Flushes != 1 || s.Buffered != 0 || s.Observations != 100 {
+		t.Fatalf("want the 100 buffered values flushed, got %+v", s)
+	}
+	est.Flush()
+	est.Get(0.5)
+	if s := est.Stats(); s.Flushes != 1 || s.Compressions != 1 {
+		t.Fatalf("want no further flush, got %+v", s)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+	if got := est.Peek(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN from an empty estimator, got %f", got)
+	}
+
+	for _, v := range quantiletest.Normal(0, 1).Generate(10300, 1) {
+		est.Add(v)
+	}
+	est.AddWeighted(3, 5)
+
+	items := append([]item(nil), est.items...)
+	buffered, observations, stats := len(est.buffer), est.observations, est.Stats()
+
+	var peeked []float64
+	for q := 0; q <= 100; q++ {
+		peeked = append(peeked, est.Peek(float64(q)/100))
+	}
+
+	for i := range est.items {
+		if i >= len(items) || est.items[i] != items[i] {
+			t.Fatalf("item %d changed by Peek", i)
+		}
+	}
+	if len(est.items) != len(items) || len(est.buffer) != buffered || est.observations != observations || est.Stats() != stats {
+		t.Fatalf("want %d items, %d buffered and %f observations unchanged, got %d, %d and %f",
+			len(items), buffered, observations, len(est.items), len(est.buffer), est.observations)
+	}
+
+	// the same as Get, before and after the flush
+	for q := 0; q <= 100; q++ {
+		if got := est.Get(float64(q) / 100); got != peeked[q] {
+			t.Fatalf("q=%f: want Peek %f to match Get %f", float64(q)/100, peeked[q], got)
+		}
+		if got := est.Peek(float64(q) / 100); got != peeked[q] {
+			t.Fatalf("q=%f: want Peek %f of the flushed estimator, got %f", float64(q)/100, peeked[q], got)
+		}
+	}
+}
+
+func TestAddBatch(t *testing.T) {
+	for _, invariants := range [][]Estimate{{Unknown(0.01)}, {Known(0.5, 0.01), Known(0.99, 0.001)}} {
+		for _, d := range []quantiletest.Distribution{quantiletest.Normal(0, 1), quantiletest.LowCardinality(20)} {
+			values := d.Generate(100000, 1)
+			est := New(invariants...)
+			// buffered values are flushed first
+			for _, v := range values[:1000] {
+				est.Add(v)
+			}
+			batch := append([]float64(nil), values[1000:]...)
+			batch = append(batch, math.NaN(), math.Inf(1))
+			est.AddBatch(batch)
+			if batch[0] != values[1000] || len(est.buffer) != 0 {
+				t.Fatalf("%v: want the batch unmodified and merged", invariants)
+			}
+
+			exact := quantiletest.NewExact(append(values, math.Inf(1)))
+			if est.Count() != 100001 || est.NaNCount() != 1 || est.InfCount() != 1 || est.Min() != exact.Get(0) || est.Max() != math.Inf(1) {
+				t.Fatalf("%v %s: want 100001 values from %f to +Inf, got %d from %f to %f", invariants, d.Name, exact.Get(0), est.Count(), est.Min(), est.Max())
+			}
+			for _, q := range []float64{0, 0.01, 0.1, 0.5, 0.9, 0.99, 1} {
+				quantiletest.AssertWithinRankError(t, exact, est, q, est.GuaranteedError(q))
+			}
+			if err := est.DebugValidate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	est := New()
+	est.AddBatch(nil)
+	est.AddBatch([]float64{math.NaN()})
+	if est.Count() != 0 || len(est.items) != 0 {
+		t.Fatalf("want nothing added, got %d values", est.Count())
+	}
+	est.AddBatch([]float64{2, 2, 2})
+	if len(est.items) != 1 || est.Get(0.5) != 2 {
+		t.Fatalf("want one item of 2, got %d items", len(est.items))
+	}
+}
+
+func BenchmarkAddBatch(b *testing.B) {
+	debug = false
+	defer func() { debug = true }()
+	values := quantiletest.Normal(0, 1).Generate(1000000, 1)
+
+	// sorting dominates both, AddBatch saves inserting and recycling an item
+	// per value
+
+	b.Run("Add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+			for _, v := range values {
+				est.Add(v)
+			}
+			est.Get(0.99)
+		}
+	})
+	b.Run("AddBatch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+			est.AddBatch(values)
+			est.Get(0.99)
+		}
+	})
+}
+
+func TestAddSorted(t *testing.T) {
+	values := quantiletest.Sorted(quantiletest.LogNormal(0, 1)).Generate(10*bufferSize, 1)
+
+	// a buffer at a time, the same summary as Add
+	added, sorted := New(Known(0.5, 0.01), Known(0.99, 0.001)), New(Known(0.5, 0.01), Known(0.99, 0.001))
+	for i := 0; i < len(values); i += bufferSize {
+		for _, v := range values[i : i+bufferSize] {
+			added.Add(v)
+		}
+		sorted.AddSorted(values[i : i+bufferSize])
+	}
+	if len(sorted.items) != len(added.items) {
+		t.Fatalf("want %d items like Add, got %d", len(added.items), len(sorted.items))
+	}
+	for i := range added.items {
+		if sorted.items[i] != added.items[i] {
+			t.Fatalf("item %d: want %+v like Add, got %+v", i, added.items[i], sorted.items[i])
+		}
+	}
+	if s := sorted.Stats(); s.Flushes != 10 || sorted.Count() != int64(len(values)) {
+		t.Fatalf("want 10 flushes of %d values, got %+v", len(values), s)
+	}
+
+	// buffered values first, dropped and unsorted values without touching
+	// the slice
+	est := NewWithOptions([]Option{WithInfPolicy(DropInf)}, Unknown(0.01))
+	est.Add(2.5)
+	batch := []float64{4, 3, math.NaN(), 1, math.Inf(1), 2}
+	est.AddSorted(batch)
+	if est.Count() != 5 || est.NaNCount() != 1 || est.InfCount() != 1 {
+		t.Fatalf("want 5 values, 1 NaN and 1 Inf, got %d, %d and %d", est.Count(), est.NaNCount(), est.InfCount())
+	}
+	for i, want := range []float64{1, 2, 2.5, 3, 4} {
+		if got := est.Get(float64(i+1) / 5); got != want {
+			t.Fatalf("rank %d: want %f, got %f", i+1, want, got)
+		}
+	}
+	if batch[0] != 4 || batch[5] != 2 {
+		t.Fatalf("want the batch left alone, got %v", batch)
+	}
+	est.AddSorted([]float64{math.NaN()})
+	if est.Stats().Flushes != 2 {
+		t.Fatalf("want no flush of dropped values, got %+v", est.Stats())
+	}
+}
+
+func BenchmarkAddSorted(b *testing.B) {
+	debug = false
+	defer func() { debug = true }()
+	values := quantiletest.Sorted(quantiletest.Normal(0, 1)).Generate(1000000, 1)
+
+	// pre-sorted values a buffer at a time, sorted again by Add
+	b.Run("Add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+			for _, v := range values {
+				est.Add(v)
+			}
+			est.Get(0.99)
+		}
+	})
+	b.Run("AddSorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+			for j := 0; j < len(values); j += bufferSize {
+				est.AddSorted(values[j:min(j+bufferSize, len(values))])
+			}
+			est.Get(0.99)
+		}
+	})
+}
+
+func BenchmarkGetFlushed(b *testing.B) {
+	debug = false
+	defer func() { debug = true }()
+	est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+	for _, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		est.Add(v)
+	}
+	values := quantiletest.Normal(0, 1).Generate(1<<16, 2)
+	next := 0
+
+	// a Get with a nearly full buffer, flushed or not beforehand
+	for _, flush := range []bool{false, true} {
+		b.Run(fmt.Sprintf("flushed=%t", flush), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for len(est.buffer) < cap(est.buffer)-1 {
+					est.Add(values[next&(len(values)-1)])
+					next++
+				}
+				if flush {
+					est.Flush()
+				}
+				b.StartTimer()
+				est.Get(0.99)
+			}
+		})
+	}
+}
+
+func TestExactBelowBuffer(t *testing.T) {
+	for _, invariants := range [][]Estimate{{Unknown(0.1)}, {Known(0.5, 0.05), Known(0.9, 0.01)}} {
+		est := New(invariants...)
+		exact := quantiletest.NewExact(nil)
+		values := quantiletest.Exponential().Generate(bufferSize, 1)
+
+		for i, v := range values[:bufferSize-1] {
+			est.Add(v)
+			exact.Add(v)
+			if i%50 != 0 && i < bufferSize-2 {
+				continue
+			}
+
+			qs := []float64{0, 0.01, 0.1, 0.25, 0.5, 0.9, 0.99, 1}
+			all := est.GetAll(qs...)
+			for j, q := range qs {
+				want := exact.Get(q)
+				if got := est.Peek(q); got != want {
+					t.Fatalf("%v n=%d q=%f: want Peek of the order statistic %f, got %f", invariants, i+1, q, want, got)
+				}
+				if got := est.Get(q); got != want {
+					t.Fatalf("%v n=%d q=%f: want the order statistic %f, got %f", invariants, i+1, q, want, got)
+				}
+				if all[j] != want {
+					t.Fatalf("%v n=%d q=%f: want GetAll of the order statistic %f, got %f", invariants, i+1, q, want, all[j])
+				}
+				if v, lo, hi := est.GetWithBounds(q); v != want || lo != want || hi != want {
+					t.Fatalf("%v n=%d q=%f: want exact bounds of %f, got %f in [%f, %f]", invariants, i+1, q, want, v, lo, hi)
+				}
+			}
+			if got := est.Get(2); !math.IsNaN(got) {
+				t.Fatalf("%v: want NaN for the quantile 2, got %f", invariants, got)
+			}
+			if s := est.Stats(); s.Flushes != 0 || len(est.items) != 0 {
+				t.Fatalf("%v n=%d: want the values kept in the buffer, got %+v", invariants, i+1, s)
+			}
+		}
+
+		// the value filling the buffer seeds the summary with all of them
+		est.Add(values[bufferSize-1])
+		exact.Add(values[bufferSize-1])
+		if s := est.Stats(); s.Flushes != 1 || s.Buffered != 0 || s.Observations != bufferSize {
+			t.Fatalf("%v: want the full buffer flushed, got %+v", invariants, s)
+		}
+		for _, q := range []float64{0, 0.5, 0.9, 1} {
+			got := est.Get(q)
+			if e := exact.RankError(q, got); e > est.GuaranteedError(q) {
+				t.Fatalf("%v q=%f: want %f within %f of the rank, got %f off", invariants, q, got, est.GuaranteedError(q), e)
+			}
+		}
+		if got, want := est.Get(1), exact.Get(1); got != want {
+			t.Fatalf("%v: want the maximum %f after the flush, got %f", invariants, want, got)
+		}
+	}
+}
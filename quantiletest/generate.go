@@ -0,0 +1,179 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantiletest
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// Distribution generates deterministic streams of values.  The same seed and
+// size produce the same stream on every machine.
+type Distribution struct {
+	// Name identifies the distribution and its shape in test output.
+	Name string
+
+	next     func(r *rand.Rand, i, n int) float64
+	quantile func(q float64) float64
+	sorted   bool
+}
+
+// Fill overwrites values with a stream drawn from seed.
+func (d Distribution) Fill(values []float64, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	for i := range values {
+		values[i] = d.next(r, i, len(values))
+	}
+	if d.sorted {
+		sort.Float64s(values)
+	}
+}
+
+// Generate returns a stream of n values drawn from seed.
+func (d Distribution) Generate(n int, seed int64) []float64 {
+	values := make([]float64, n)
+	d.Fill(values, seed)
+	return values
+}
+
+// Quantile returns the analytic quantile of the distribution, the smallest
+// value whose cumulative probability is at least q.
+func (d Distribution) Quantile(q float64) float64 {
+	return d.quantile(math.Max(0, math.Min(q, 1)))
+}
+
+// Normal is the normal distribution.
+func Normal(mean, stddev float64) Distribution {
+	return Distribution{
+		Name: "normal",
+		next: func(r *rand.Rand, _, _ int) float64 { return mean + stddev*r.NormFloat64() },
+		quantile: func(q float64) float64 {
+			return mean + stddev*math.Sqrt2*math.Erfinv(2*q-1)
+		},
+	}
+}
+
+// LogNormal is the distribution of e raised to a normal value.
+func LogNormal(mu, sigma float64) Distribution {
+	normal := Normal(mu, sigma)
+	return Distribution{
+		Name:     "lognormal",
+		next:     func(r *rand.Rand, i, n int) float64 { return math.Exp(normal.next(r, i, n)) },
+		quantile: func(q float64) float64 { return math.Exp(normal.quantile(q)) },
+	}
+}
+
+// Exponential is the exponential distribution with rate 1.
+func Exponential() Distribution {
+	return Distribution{
+		Name:     "exponential",
+		next:     func(r *rand.Rand, _, _ int) float64 { return r.ExpFloat64() },
+		quantile: func(q float64) float64 { return -math.Log1p(-q) },
+	}
+}
+
+// Pareto is the Pareto distribution with a minimum of 1 and shape alpha.
+// Shapes of 2 and below have infinite variance.
+func Pareto(alpha float64) Distribution {
+	return Distribution{
+		Name:     "pareto",
+		next:     func(r *rand.Rand, _, _ int) float64 { return math.Pow(1-r.Float64(), -1/alpha) },
+		quantile: func(q float64) float64 { return math.Pow(1-q, -1/alpha) },
+	}
+}
+
+// Bimodal is an even mixture of two unit normal distributions whose means are
+// separation apart, centered on 0.
+func Bimodal(separation float64) Distribution {
+	cdf := func(x float64) float64 {
+		lo := math.Erfc(-(x+separation/2)/math.Sqrt2) / 2
+		hi := math.Erfc(-(x-separation/2)/math.Sqrt2) / 2
+		return (lo + hi) / 2
+	}
+	return Distribution{
+		Name: "bimodal",
+		next: func(r *rand.Rand, _, _ int) float64 {
+			if r.Intn(2) == 0 {
+				return r.NormFloat64() - separation/2
+			}
+			return r.NormFloat64() + separation/2
+		},
+		quantile: func(q float64) float64 {
+			if q == 0 || q == 1 {
+				return math.Inf(int(2*q - 1))
+			}
+			// the inverse of the mixture has no closed form
+			lo, hi := -separation/2-40, separation/2+40
+			for i := 0; i < 100; i++ {
+				mid := (lo + hi) / 2
+				if cdf(mid) < q {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			return hi
+		},
+	}
+}
+
+// Constant repeats v.
+func Constant(v float64) Distribution {
+	return Distribution{
+		Name:     "constant",
+		next:     func(*rand.Rand, int, int) float64 { return v },
+		quantile: func(float64) float64 { return v },
+	}
+}
+
+// LowCardinality draws uniformly from the integers 0 through k-1.
+func LowCardinality(k int) Distribution {
+	return Distribution{
+		Name: "low cardinality " + strconv.Itoa(k),
+		next: func(r *rand.Rand, _, _ int) float64 { return float64(r.Intn(k)) },
+		quantile: func(q float64) float64 {
+			return math.Max(0, math.Ceil(q*float64(k))-1)
+		},
+	}
+}
+
+// Sawtooth rises from 0 to 1 in teeth equal ramps, with jitter inside each
+// step.  Every tooth revisits the ranks the previous one filled, which
+// defeats estimators that assume arrival order is random.
+func Sawtooth(teeth int) Distribution {
+	return Distribution{
+		Name: "sawtooth",
+		next: func(r *rand.Rand, i, n int) float64 {
+			width := (n + teeth - 1) / teeth
+			return (float64(i%width) + r.Float64()) / float64(width)
+		},
+		quantile: func(q float64) float64 { return q },
+	}
+}
+
+// Sorted returns d with every stream in ascending order.
+func Sorted(d Distribution) Distribution {
+	d.Name = "sorted " + d.Name
+	d.sorted = true
+	return d
+}
+
+// Distributions returns one of each distribution with the shapes the tests
+// and benchmarks use.
+func Distributions() []Distribution {
+	return []Distribution{
+		Normal(0, 1),
+		LogNormal(0, 2),
+		Exponential(),
+		Pareto(1.5),
+		Bimodal(6),
+		Constant(1),
+		LowCardinality(10),
+		Sorted(Normal(0, 1)),
+		Sawtooth(10),
+	}
+}
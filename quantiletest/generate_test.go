@@ -0,0 +1,65 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantiletest
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDistributionsMatchQuantiles(t *testing.T) {
+	const n = 100000
+	for _, d := range Distributions() {
+		exact := NewExact(d.Generate(n, 1))
+		for _, q := range []float64{0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999} {
+			// sampling error at n=100000 is below 0.002
+			if err := exact.RankError(q, d.Quantile(q)); err > 0.005 {
+				t.Errorf("%s q=%g: analytic %g has empirical rank error %f (empirical %g)",
+					d.Name, q, d.Quantile(q), err, exact.Get(q))
+			}
+		}
+	}
+}
+
+func TestDistributionsDeterministic(t *testing.T) {
+	for _, d := range Distributions() {
+		a, b := d.Generate(1000, 7), d.Generate(1000, 7)
+		if !reflect.DeepEqual(a, b) {
+			t.Errorf("%s: the same seed generated different streams", d.Name)
+		}
+		if d.Name == "constant" {
+			continue
+		}
+		if reflect.DeepEqual(a, d.Generate(1000, 8)) {
+			t.Errorf("%s: different seeds generated the same stream", d.Name)
+		}
+	}
+}
+
+func TestSorted(t *testing.T) {
+	values := Sorted(Exponential()).Generate(1000, 1)
+	if !sort.Float64sAreSorted(values) {
+		t.Fatal("want a sorted stream")
+	}
+	unsorted := Exponential().Generate(1000, 1)
+	sort.Float64s(unsorted)
+	if !reflect.DeepEqual(values, unsorted) {
+		t.Fatal("want the values of the unsorted stream")
+	}
+}
+
+func TestSawtooth(t *testing.T) {
+	values := Sawtooth(4).Generate(100, 1)
+	drops := 0
+	for i := 1; i < len(values); i++ {
+		if values[i] < values[i-1] {
+			drops++
+		}
+	}
+	if drops != 3 {
+		t.Fatalf("want 4 ascending teeth, got %d drops", drops)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+// Package quantiletest provides an exact quantile reference and assertions
+// for testing estimators against it.
+package quantiletest
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+// Estimator is the query side of quantile.Estimator.
+type Estimator interface {
+	Get(quantile float64) float64
+}
+
+// Exact keeps every value and answers quantiles exactly, with the rank
+// convention of quantile.Estimator.Get.  The zero Exact is empty and ready to
+// use.
+type Exact struct {
+	values []float64
+	sorted bool
+}
+
+// NewExact returns an Exact holding values.  It sorts values in place on the
+// first query.
+func NewExact(values []float64) *Exact {
+	return &Exact{values: values}
+}
+
+// Add adds a value.
+func (e *Exact) Add(value float64) {
+	e.values = append(e.values, value)
+	e.sorted = false
+}
+
+// Samples returns the number of values added.
+func (e *Exact) Samples() int {
+	return len(e.values)
+}
+
+// Sorted returns the values in ascending order.  The slice is shared with
+// the Exact until the next Add.
+func (e *Exact) Sorted() []float64 {
+	if !e.sorted {
+		sort.Float64s(e.values)
+		e.sorted = true
+	}
+	return e.values
+}
+
+// Get returns the value at rank ⌈quantile·n⌉, the minimum for quantiles of 0
+// and below, or NaN if no values have been added.
+func (e *Exact) Get(quantile float64) float64 {
+	values := e.Sorted()
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	rank := Rank(quantile, len(values))
+	return values[rank-1]
+}
+
+// RankError returns how far the ranks v occupies are from the rank of
+// quantile, as a fraction of the values.
+func (e *Exact) RankError(quantile, v float64) float64 {
+	return RankError(e.Sorted(), quantile, v)
+}
+
+// Rank is the rank of quantile among n values, ⌈quantile·n⌉ clamped to
+// [1, n].  Like quantile.Estimator.Get, a product within floating point
+// error of a whole rank is that rank.
+func Rank(quantile float64, n int) int {
+	r := quantile * float64(n)
+	rank := math.Ceil(r)
+	if whole := math.Round(r); math.Abs(r-whole) <= float64(n)*0x1p-52 {
+		rank = whole
+	}
+	return int(math.Max(1, math.Min(rank, float64(n))))
+}
+
+// RankError returns how far the ranks v occupies in sorted are from
+// quantile·n, as a fraction of n.  Within the ranks of v it is 0.
+func RankError(sorted []float64, quantile, v float64) float64 {
+	n := float64(len(sorted))
+	below := float64(sort.SearchFloat64s(sorted, v))
+	atOrBelow := float64(sort.Search(len(sorted), func(i int) bool { return sorted[i] > v }))
+	target := quantile * n
+	switch {
+	case target < below+1:
+		return (below + 1 - target) / n
+	case target > atOrBelow:
+		return (target - atOrBelow) / n
+	}
+	return 0
+}
+
+// RankOf returns the midpoint of the ranks v occupies in sorted, as a
+// fraction of len(sorted).
+func RankOf(sorted []float64, v float64) float64 {
+	below := sort.SearchFloat64s(sorted, v)
+	atOrBelow := sort.Search(len(sorted), func(i int) bool { return sorted[i] > v })
+	return float64(below+1+atOrBelow) / 2 / float64(len(sorted))
+}
+
+// RankDistance returns the number of ranks between the values x and y in
+// sorted, as a fraction of all values.
+func RankDistance(sorted []float64, x, y float64) float64 {
+	if x > y {
+		x, y = y, x
+	}
+	atOrBelowX := sort.Search(len(sorted), func(i int) bool { return sorted[i] > x })
+	belowY := sort.SearchFloat64s(sorted, y)
+	return math.Max(0, float64(belowY+1-atOrBelowX)) / float64(len(sorted))
+}
+
+// RankSlack is the rank error of one rank among n values.  The bounds of an
+// estimator are on quantile·n, which falls between ranks, so the
+// assertions allow one rank of slack beyond epsilon.
+func RankSlack(n int) float64 {
+	return 1 / float64(n)
+}
+
+// WithinRankError returns the RankError of v in sorted and whether it is
+// within epsilon, plus the RankSlack of len(sorted).
+func WithinRankError(sorted []float64, quantile, v, epsilon float64) (float64, bool) {
+	err := RankError(sorted, quantile, v)
+	return err, err <= epsilon+RankSlack(len(sorted))
+}
+
+// AssertWithinRankError reports an error on t unless est.Get(quantile) is
+// within epsilon of the rank of quantile in exact, plus the RankSlack of its
+// values, and returns whether it is.
+func AssertWithinRankError(t testing.TB, exact *Exact, est Estimator, quantile, epsilon float64) bool {
+	t.Helper()
+	v := est.Get(quantile)
+	if err, ok := WithinRankError(exact.Sorted(), quantile, v, epsilon); !ok {
+		t.Errorf("q=%g of %d values: estimate %g has rank error %g, want at most %g and one rank (exact %g)",
+			quantile, exact.Samples(), v, err, epsilon, exact.Get(quantile))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantiletest
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExactGet(t *testing.T) {
+	var e Exact
+	if got := e.Get(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN when empty, got %f", got)
+	}
+
+	for _, v := range []float64{5, 1, 4, 2, 3, 3, 3, 6, 7, 8} {
+		e.Add(v)
+	}
+	// 1 2 3 3 3 4 5 6 7 8
+	for q, want := range map[float64]float64{
+		-1: 1, 0: 1, 0.1: 1, 0.11: 2, 0.3: 3, 0.5: 3, 0.51: 4, 0.7: 5, 1: 8, 2: 8,
+	} {
+		if got := e.Get(q); got != want {
+			t.Errorf("Get(%f): want %f, got %f", q, want, got)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	// 0.07·100 is 7.000000000000001 in floating point
+	for _, c := range []struct {
+		q    float64
+		n    int
+		want int
+	}{
+		{0.07, 100, 7},
+		{0.071, 100, 8},
+		{0.5, 10, 5},
+		{0, 10, 1},
+		{1, 10, 10},
+	} {
+		if got := Rank(c.q, c.n); got != c.want {
+			t.Errorf("Rank(%f, %d): want %d, got %d", c.q, c.n, c.want, got)
+		}
+	}
+}
+
+func TestRankError(t *testing.T) {
+	sorted := []float64{1, 2, 3, 3, 3, 4, 5, 6, 7, 8}
+	for _, c := range []struct{ q, v, want float64 }{
+		{0.5, 3, 0},   // 3 occupies ranks 3 through 5
+		{0.3, 3, 0},   // rank 3
+		{0.8, 3, 0.3}, // 3 below rank 8 by 3
+		{0.1, 3, 0.2}, // rank 1, 3 starts at 3
+	} {
+		if got := RankError(sorted, c.q, c.v); got != c.want {
+			t.Errorf("RankError(%f, %f): want %f, got %f", c.q, c.v, c.want, got)
+		}
+	}
+
+	if got := RankDistance(sorted, 2, 5); got != 0.5 {
+		t.Errorf("RankDistance(2, 5): want 0.5, got %f", got)
+	}
+	if got := RankDistance(sorted, 3, 3); got != 0 {
+		t.Errorf("RankDistance(3, 3): want 0, got %f", got)
+	}
+}
+
+type fixed float64
+
+type recorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *recorder) Helper()                                   {}
+func (r *recorder) Errorf(format string, args ...interface{}) { r.failed = true }
+
+func (f fixed) Get(float64) float64 { return float64(f) }
+
+func TestAssertWithinRankError(t *testing.T) {
+	e := NewExact([]float64{5, 4, 3, 2, 1})
+	if !AssertWithinRankError(t, e, fixed(3), 0.6, 0) {
+		t.Fatal("the exact value at rank 3 failed")
+	}
+
+	if !AssertWithinRankError(t, e, fixed(4), 0.6, 0) {
+		t.Fatal("an estimate one rank away failed")
+	}
+
+	r := &recorder{TB: t}
+	if AssertWithinRankError(r, e, fixed(5), 0.6, 0.1) || !r.failed {
+		t.Fatal("want an error for an estimate 2 ranks away with a tolerance of half a rank")
+	}
+	if _, ok := WithinRankError(e.Sorted(), 0.6, 5, 0.2); !ok {
+		t.Fatal("want an estimate 2 ranks away within a tolerance of 1 rank")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"time"
+)
+
+// Rotating estimates quantiles of the values added during about the last
+// maxAge, forgetting older ones abruptly, like the summaries of the
+// Prometheus client.  It is not safe for concurrent use.
+//
+// Every value is added to each of ageBuckets estimators, the epochs.  The
+// oldest epoch answers queries, and every maxAge/ageBuckets it is discarded
+// and starts over as the newest, so queries cover between maxAge minus one
+// epoch's duration and maxAge.  Compared to Windowed, reads query a single
+// estimator instead of merging, for ageBuckets times the memory and time
+// of Add.
+type Rotating struct {
+	epochs []*Estimator
+	oldest int
+	width  time.Duration
+
+	// when the oldest epoch is discarded
+	expires time.Time
+
+	now func() time.Time
+}
+
+// NewRotating allocates an estimator over the last maxAge of ageBuckets
+// epochs, each tolerating the minimum of the invariants like New.
+func NewRotating(maxAge time.Duration, ageBuckets int, invariants ...Estimate) *Rotating {
+	if ageBuckets < 1 {
+		ageBuckets = 1
+	}
+	r := &Rotating{
+		epochs: make([]*Estimator, ageBuckets),
+		width:  maxAge / time.Duration(ageBuckets),
+		now:    time.Now,
+	}
+	if r.width <= 0 {
+		r.width = 1
+	}
+	for i := range r.epochs {
+		r.epochs[i] = New(invariants...)
+	}
+	r.expires = r.now().Add(r.width)
+	return r
+}
+
+// rotate discards the epochs that expired by now.  After an idle time of
+// more than maxAge all of them are.
+func (r *Rotating) rotate() {
+	now := r.now()
+	if now.Before(r.expires) {
+		return
+	}
+
+	steps := int(now.Sub(r.expires)/r.width) + 1
+	r.expires = r.expires.Add(time.Duration(steps) * r.width)
+	if steps > len(r.epochs) {
+		steps = len(r.epochs)
+	}
+	for ; steps > 0; steps-- {
+		r.epochs[r.oldest].Reset()
+		r.oldest = (r.oldest + 1) % len(r.epochs)
+	}
+}
+
+// Add adds value to every epoch.
+func (r *Rotating) Add(value float64) {
+	r.rotate()
+	for _, est := range r.epochs {
+		est.Add(value)
+	}
+}
+
+// Get estimates quantile from the oldest epoch, or returns NaN if no values
+// were added during it.
+func (r *Rotating) Get(quantile float64) float64 {
+	r.rotate()
+	return r.epochs[r.oldest].Get(quantile)
+}
+
+// Snapshot copies the oldest epoch.
+func (r *Rotating) Snapshot() *Snapshot {
+	r.rotate()
+	return r.epochs[r.oldest].Snapshot()
+}
+
+// Count returns the number of values added during the oldest epoch.
+func (r *Rotating) Count() int64 {
+	r.rotate()
+	return r.epochs[r.oldest].Count()
+}
+
+// Reset discards the values of every epoch.
+func (r *Rotating) Reset() {
+	for _, est := range r.epochs {
+		est.Reset()
+	}
+	r.oldest = 0
+	r.expires = r.now().Add(r.width)
+}
@@ -0,0 +1,86 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+// newFakeRotating returns a Rotating whose clock the tests advance instead
+// of waiting.
+func newFakeRotating(maxAge time.Duration, ageBuckets int, invariants ...Estimate) (*Rotating, *time.Time) {
+	clock := time.Unix(1e9, 0)
+	r := NewRotating(maxAge, ageBuckets, invariants...)
+	r.now = func() time.Time { return clock }
+	r.expires = clock.Add(r.width)
+	return r, &clock
+}
+
+func TestRotatingForgetsOutlier(t *testing.T) {
+	r, clock := newFakeRotating(10*time.Minute, 5, Known(0.99, 0.001))
+
+	// an outlier burst, then a normal value every second
+	for i := 0; i < 100; i++ {
+		r.Add(1000)
+	}
+	normal := quantiletest.Normal(1, 0.1).Generate(20*60, 1)
+	for i, v := range normal {
+		*clock = clock.Add(time.Second)
+		r.Add(v)
+
+		// the oldest epoch started with the outlier until MaxAge passed
+		if got := r.Get(0.99); i < 10*60-1 && got != 1000 {
+			t.Fatalf("second %d: want the outlier within MaxAge, got p99 %f", i+1, got)
+		} else if i >= 10*60 && got == 1000 {
+			t.Fatalf("second %d: want the outlier forgotten after MaxAge, got p99 %f", i+1, got)
+		}
+	}
+
+	// the oldest epoch covers 8 to 10 minutes
+	count := r.Count()
+	if want := int64(10 * 60); count < want-2*60 || count > want {
+		t.Fatalf("want between %d and %d values, got %d", want-2*60, want, count)
+	}
+	exact := quantiletest.NewExact(normal[len(normal)-int(count):])
+	quantiletest.AssertWithinRankError(t, exact, r, 0.99, 0.001)
+}
+
+func TestRotatingIdle(t *testing.T) {
+	r, clock := newFakeRotating(time.Minute, 3, Unknown(0.01))
+	if got := r.Get(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN before any value, got %f", got)
+	}
+	r.Add(1)
+	r.Add(2)
+	r.Add(3)
+
+	*clock = clock.Add(30 * time.Second)
+	if got := r.Get(0.5); got != 2 {
+		t.Fatalf("want the median 2 from the epoch after one rotation, got %f", got)
+	}
+	s := r.Snapshot()
+	if got := s.Count(); got != 3 {
+		t.Fatalf("want 3 values in the snapshot, got %d", got)
+	}
+
+	// every epoch expired
+	*clock = clock.Add(time.Hour)
+	if got := r.Count(); got != 0 {
+		t.Fatalf("want no values after an idle hour, got %d", got)
+	}
+	r.Add(4)
+	if got := r.Get(0.5); got != 4 {
+		t.Fatalf("want 4, got %f", got)
+	}
+
+	r.Reset()
+	if got := r.Count(); got != 0 {
+		t.Fatalf("want no values after Reset, got %d", got)
+	}
+}
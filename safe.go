@@ -0,0 +1,153 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"sync"
+)
+
+// SafeEstimator is an Estimator safe for concurrent use.  Get flushes and
+// compresses, so even concurrent readers must be serialized, which
+// SafeEstimator does with a mutex around every call.  Uncontended, the lock
+// adds little to Add, whose cost is mostly the amortized flush.
+//
+// To read several quantiles of one consistent view, or to read without
+// holding up writers, take a Snapshot.
+type SafeEstimator struct {
+	mu  sync.Mutex
+	est *Estimator
+}
+
+// NewSafe allocates a SafeEstimator tolerating the minimum of the invariants
+// like New.
+func NewSafe(invariants ...Estimate) *SafeEstimator {
+	return &SafeEstimator{est: New(invariants...)}
+}
+
+// NewSafeWithOptions allocates a SafeEstimator like NewWithOptions.  Hooks
+// run with the lock held and must not call back into the SafeEstimator.
+func NewSafeWithOptions(options []Option, invariants ...Estimate) *SafeEstimator {
+	return &SafeEstimator{est: NewWithOptions(options, invariants...)}
+}
+
+// Add adds value like Estimator.Add.
+func (s *SafeEstimator) Add(value float64) {
+	s.mu.Lock()
+	s.est.Add(value)
+	s.mu.Unlock()
+}
+
+// Flush merges the buffered values like Estimator.Flush.
+func (s *SafeEstimator) Flush() {
+	s.mu.Lock()
+	s.est.Flush()
+	s.mu.Unlock()
+}
+
+// AddTarget adds an invariant like Estimator.AddTarget.
+func (s *SafeEstimator) AddTarget(f Estimate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.AddTarget(f)
+}
+
+// Get estimates quantile like Estimator.Get.
+func (s *SafeEstimator) Get(quantile float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Get(quantile)
+}
+
+// GetErr estimates quantile like Estimator.GetErr.
+func (s *SafeEstimator) GetErr(quantile float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.GetErr(quantile)
+}
+
+// GetWithBounds estimates quantile and its interval like
+// Estimator.GetWithBounds.
+func (s *SafeEstimator) GetWithBounds(quantile float64) (v, lo, hi float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.GetWithBounds(quantile)
+}
+
+// Estimates estimates every Known target like Estimator.Estimates.
+func (s *SafeEstimator) Estimates() map[float64]float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Estimates()
+}
+
+// GetOK estimates quantile like Estimator.GetOK.
+func (s *SafeEstimator) GetOK(quantile float64) (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.GetOK(quantile)
+}
+
+// Count returns the number of values observed like Estimator.Count.
+func (s *SafeEstimator) Count() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Count()
+}
+
+// Size returns the number of retained items like Estimator.Size.
+func (s *SafeEstimator) Size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Size()
+}
+
+// Buffered returns the number of buffered values like Estimator.Buffered.
+func (s *SafeEstimator) Buffered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Buffered()
+}
+
+// Min returns the smallest value observed like Estimator.Min.
+func (s *SafeEstimator) Min() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Min()
+}
+
+// Max returns the largest value observed like Estimator.Max.
+func (s *SafeEstimator) Max() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Max()
+}
+
+// Mean returns the mean of the values observed like Estimator.Mean.
+func (s *SafeEstimator) Mean() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Mean()
+}
+
+// Stats describes the wrapped estimator like Estimator.Stats.
+func (s *SafeEstimator) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Stats()
+}
+
+// Snapshot copies the summary like Estimator.Snapshot.
+func (s *SafeEstimator) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.est.Snapshot()
+}
+
+// Reset discards all observations like Estimator.Reset.
+func (s *SafeEstimator) Reset() {
+	s.mu.Lock()
+	s.est.Reset()
+	s.mu.Unlock()
+}
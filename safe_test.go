@@ -0,0 +1,103 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+// run with -race to check the locking
+func TestSafeEstimatorConcurrent(t *testing.T) {
+	const writers, perWriter = 8, 20000
+	est := NewSafe(Known(0.5, 0.01), Known(0.99, 0.001))
+
+	var all []float64
+	streams := make([][]float64, writers)
+	for i := range streams {
+		streams[i] = quantiletest.Normal(0, 1).Generate(perWriter, int64(i))
+		all = append(all, streams[i]...)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for _, stream := range streams {
+		wg.Add(1)
+		go func(stream []float64) {
+			defer wg.Done()
+			for _, v := range stream {
+				est.Add(v)
+			}
+		}(stream)
+	}
+
+	// a reader querying while the writers run
+	read := make(chan int64)
+	go func() {
+		var last int64
+		for {
+			select {
+			case <-done:
+				read <- last
+				return
+			default:
+			}
+			est.Get(0.99)
+			s := est.Snapshot()
+			if s.Count() < last {
+				t.Errorf("count went back from %d to %d", last, s.Count())
+			}
+			last = s.Count()
+			est.Min()
+			est.Stats()
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	<-read
+
+	if got := est.Count(); got != writers*perWriter {
+		t.Fatalf("want %d values, got %d", writers*perWriter, got)
+	}
+	exact := quantiletest.NewExact(all)
+	for q, e := range map[float64]float64{0.5: 0.01, 0.99: 0.001} {
+		quantiletest.AssertWithinRankError(t, exact, est, q, e)
+	}
+	if est.Min() != exact.Get(0) || est.Max() != exact.Get(1) {
+		t.Fatalf("want min %f and max %f, got %f and %f", exact.Get(0), exact.Get(1), est.Min(), est.Max())
+	}
+
+	est.Reset()
+	if est.Count() != 0 {
+		t.Fatal("want no values after Reset")
+	}
+}
+
+func BenchmarkSafeEstimatorAdd(b *testing.B) {
+	values := quantiletest.Normal(0, 1).Generate(1<<16, 1)
+	b.Run("unsafe", func(b *testing.B) {
+		est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+		for i := 0; i < b.N; i++ {
+			est.Add(values[i&(len(values)-1)])
+		}
+	})
+	b.Run("uncontended", func(b *testing.B) {
+		est := NewSafe(Known(0.5, 0.01), Known(0.99, 0.001))
+		for i := 0; i < b.N; i++ {
+			est.Add(values[i&(len(values)-1)])
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		est := NewSafe(Known(0.5, 0.01), Known(0.99, 0.001))
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				est.Add(values[i&(len(values)-1)])
+			}
+		})
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Sharded spreads concurrent Adds over independent estimators so writers
+// rarely wait for each other, merging them when queried.  It is safe for
+// concurrent use.
+//
+// Each processor adds to a shard of its own, so a shard observes the values
+// of the goroutines that ran there.  The merged summary keeps the tolerance
+// of Unknown invariants, and of Known ones when the writers add values of
+// one distribution, such as the latencies of one handler.
+type Sharded struct {
+	invariants []Estimate
+	next       uint32
+	shards     []shard
+
+	// hands out shards per processor, so writers share no counter
+	free sync.Pool
+}
+
+type shard struct {
+	mu  sync.Mutex
+	est *Estimator
+
+	// keeps neighboring shards off each other's cache lines
+	_ [48]byte
+}
+
+// NewSharded allocates n estimators tolerating the minimum of the
+// invariants like New.  A good n is the number of concurrent writers, such
+// as GOMAXPROCS.
+func NewSharded(n int, invariants ...Estimate) *Sharded {
+	if n < 1 {
+		n = 1
+	}
+	s := &Sharded{
+		invariants: invariants,
+		shards:     make([]shard, n),
+	}
+	for i := range s.shards {
+		s.shards[i].est = New(invariants...)
+	}
+	// only processors without a shard in the pool take the next one
+	s.free.New = func() interface{} {
+		return &s.shards[atomic.AddUint32(&s.next, 1)%uint32(len(s.shards))]
+	}
+	return s
+}
+
+// Add adds value to the shard of the current processor.
+func (s *Sharded) Add(value float64) {
+	sh := s.free.Get().(*shard)
+	sh.mu.Lock()
+	sh.est.Add(value)
+	sh.mu.Unlock()
+	s.free.Put(sh)
+}
+
+// Snapshot merges the shards into a read-only summary of all values added
+// so far.  Each shard is locked only while it is merged.
+func (s *Sharded) Snapshot() *Snapshot {
+	merged := New(s.invariants...)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		// the shards share the invariants, so the merge cannot fail
+		merged.Merge(sh.est)
+		sh.mu.Unlock()
+	}
+	return merged.Snapshot()
+}
+
+// Get estimates quantile over all shards.  It merges them on every call,
+// so to read several quantiles take one Snapshot instead.
+func (s *Sharded) Get(quantile float64) float64 {
+	return s.Snapshot().Get(quantile)
+}
+
+// Count returns the number of values observed by all shards.
+func (s *Sharded) Count() int64 {
+	var count int64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		count += sh.est.Count()
+		sh.mu.Unlock()
+	}
+	return count
+}
+
+// Reset discards the observations of every shard.
+func (s *Sharded) Reset() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		sh.est.Reset()
+		sh.mu.Unlock()
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+func TestSharded(t *testing.T) {
+	const writers, perWriter = 8, 25000
+	targets := map[float64]float64{0.01: 0.001, 0.5: 0.01, 0.9: 0.005, 0.99: 0.001}
+	est := NewSharded(4, Known(0.01, 0.001), Known(0.5, 0.01), Known(0.9, 0.005), Known(0.99, 0.001))
+
+	var all []float64
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		stream := quantiletest.LogNormal(0, 1).Generate(perWriter, int64(i))
+		all = append(all, stream...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, v := range stream {
+				est.Add(v)
+			}
+		}()
+	}
+	// a reader merging while the writers run
+	est.Get(0.5)
+	wg.Wait()
+
+	if got := est.Count(); got != writers*perWriter {
+		t.Fatalf("want %d values, got %d", writers*perWriter, got)
+	}
+	s := est.Snapshot()
+	if err := s.est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+	exact := quantiletest.NewExact(all)
+	for q, e := range targets {
+		quantiletest.AssertWithinRankError(t, exact, s, q, e)
+	}
+
+	est.Reset()
+	if est.Count() != 0 {
+		t.Fatal("want no values after Reset")
+	}
+	if _, ok := est.Snapshot().GetOK(0.5); ok {
+		t.Fatal("want an empty snapshot after Reset")
+	}
+}
+
+func TestShardedSingle(t *testing.T) {
+	for _, n := range []int{-1, 0, 1} {
+		est := NewSharded(n)
+		est.Add(2)
+		est.Add(1)
+		if len(est.shards) != 1 || est.Get(0) != 1 || est.Get(1) != 2 {
+			t.Fatalf("NewSharded(%d): want one shard holding 1 and 2", n)
+		}
+	}
+}
+
+// The shards scale with the processors available, compare with
+// -cpu 1,4,16 on a machine that has them.
+func BenchmarkShardedAdd(b *testing.B) {
+	values := quantiletest.Normal(0, 1).Generate(1<<16, 1)
+	invariants := []Estimate{Known(0.5, 0.01), Known(0.99, 0.001)}
+
+	b.Run("safe", func(b *testing.B) {
+		est := NewSafe(invariants...)
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				est.Add(values[i&(len(values)-1)])
+			}
+		})
+	})
+	for _, n := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("sharded%d", n), func(b *testing.B) {
+			est := NewSharded(n, invariants...)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					est.Add(values[i&(len(values)-1)])
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+// Snapshot is a read-only copy of an Estimator at one point in time.  Later
+// Adds to the estimator do not affect it, and it is safe for concurrent use.
+type Snapshot struct {
+	// a flushed estimator whose items live in one flat slice, so its Get
+	// neither flushes nor compresses
+	est Estimator
+}
+
+// Snapshot flushes the estimator and copies its summary.  The copy takes
+// one allocation for all items, not one per item.
+func (est *Estimator) Snapshot() *Snapshot {
+	est.flush()
+	return &Snapshot{est: est.clone()}
+}
+
+// clone copies the summary and the statistics kept besides it, but not the
+// buffer, into an estimator without hooks.  It only reads est.
+func (est *Estimator) clone() Estimator {
+	c := Estimator{
+		invariants:   est.invariants,
+		items:        append([]item(nil), est.items...),
+		observations: est.observations,
+		min:          est.min,
+		max:          est.max,
+		sum:          est.sum,
+		nans:         est.nans,
+		infs:         est.infs,
+	}
+	return c
+}
+
+// Get returns the estimate of quantile as Estimator.Get did when the
+// snapshot was taken.
+func (s *Snapshot) Get(quantile float64) float64 {
+	return s.est.Get(quantile)
+}
+
+// GetErr is Estimator.GetErr at the time of the snapshot.
+func (s *Snapshot) GetErr(quantile float64) (float64, error) {
+	return s.est.GetErr(quantile)
+}
+
+// GetWithBounds is Estimator.GetWithBounds at the time of the snapshot.
+func (s *Snapshot) GetWithBounds(quantile float64) (v, lo, hi float64) {
+	return s.est.GetWithBounds(quantile)
+}
+
+// GetOK is Estimator.GetOK at the time of the snapshot.
+func (s *Snapshot) GetOK(quantile float64) (float64, bool) {
+	return s.est.GetOK(quantile)
+}
+
+// Estimates is Estimator.Estimates at the time of the snapshot.
+func (s *Snapshot) Estimates() map[float64]float64 {
+	return s.est.Estimates()
+}
+
+// GuaranteedError is Estimator.GuaranteedError at the time of the snapshot.
+func (s *Snapshot) GuaranteedError(quantile float64) float64 {
+	return s.est.GuaranteedError(quantile)
+}
+
+// Count returns the number of values observed before the snapshot.
+func (s *Snapshot) Count() int64 {
+	return s.est.Count()
+}
+
+// Min returns the smallest value observed before the snapshot, or NaN.
+func (s *Snapshot) Min() float64 {
+	return s.est.Min()
+}
+
+// Max returns the largest value observed before the snapshot, or NaN.
+func (s *Snapshot) Max() float64 {
+	return s.est.Max()
+}
+
+// Sum returns the sum of the values observed before the snapshot.
+func (s *Snapshot) Sum() float64 {
+	return s.est.Sum()
+}
+
+// Mean returns the mean of the values observed before the snapshot, or NaN.
+func (s *Snapshot) Mean() float64 {
+	return s.est.Mean()
+}
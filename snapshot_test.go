@@ -0,0 +1,114 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+func TestSnapshot(t *testing.T) {
+	est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+	for _, v := range quantiletest.LogNormal(0, 1).Generate(10000, 1) {
+		est.Add(v)
+	}
+
+	s := est.Snapshot()
+	var want []float64
+	for q := 0; q <= 100; q++ {
+		want = append(want, est.Get(float64(q)/100))
+	}
+	count, min, max, mean := est.Count(), est.Min(), est.Max(), est.Mean()
+
+	// later adds compress the live summary, recycling its items
+	for _, v := range quantiletest.Exponential().Generate(50000, 2) {
+		est.Add(-v)
+	}
+	est.Reset()
+	est.Add(1)
+
+	for q := 0; q <= 100; q++ {
+		if got := s.Get(float64(q) / 100); got != want[q] {
+			t.Fatalf("q=%f: want %f from the time of the snapshot, got %f", float64(q)/100, want[q], got)
+		}
+	}
+	if s.Count() != count || s.Min() != min || s.Max() != max || s.Mean() != mean {
+		t.Fatalf("want count %d min %f max %f mean %f, got %d %f %f %f",
+			count, min, max, mean, s.Count(), s.Min(), s.Max(), s.Mean())
+	}
+	if err := s.est.DebugValidate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotSharesNothing(t *testing.T) {
+	est := New(Unknown(0.01))
+	for _, v := range quantiletest.Normal(0, 1).Generate(5000, 1) {
+		est.Add(v)
+	}
+	s := est.Snapshot()
+
+	live := map[*item]bool{}
+	for i := range est.items {
+		cur := &est.items[i]
+		live[cur] = true
+	}
+	for i := range s.est.items {
+		cur := &s.est.items[i]
+		if live[cur] {
+			t.Fatalf("snapshot shares the item of %f with the estimator", cur.v)
+		}
+	}
+}
+
+func TestSnapshotEmpty(t *testing.T) {
+	s := New().Snapshot()
+	if _, ok := s.GetOK(0.5); ok || s.Count() != 0 || !math.IsNaN(s.Min()) || !math.IsNaN(s.Mean()) {
+		t.Fatal("want an empty snapshot of an empty estimator")
+	}
+}
+
+func TestSnapshotAllocations(t *testing.T) {
+	est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+	for _, v := range quantiletest.Normal(0, 1).Generate(100000, 1) {
+		est.Add(v)
+	}
+	// the snapshot and its items
+	if allocs := testing.AllocsPerRun(10, func() { est.Snapshot() }); allocs > 2 {
+		t.Fatalf("want 2 allocations per snapshot, got %f", allocs)
+	}
+}
+
+func TestSnapshotConcurrentReaders(t *testing.T) {
+	est := New(Known(0.5, 0.01), Known(0.99, 0.001))
+	values := quantiletest.Normal(0, 1).Generate(20000, 1)
+	for _, v := range values[:10000] {
+		est.Add(v)
+	}
+	s := est.Snapshot()
+	want := s.Get(0.99)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if got := s.Get(0.99); got != want {
+					t.Errorf("want %f, got %f", want, got)
+					return
+				}
+			}
+		}()
+	}
+	// the estimator keeps going while the readers run
+	for _, v := range values[10000:] {
+		est.Add(v)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,58 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import "unsafe"
+
+// Stats describes the state and work of an Estimator.  The counters cover
+// the time since construction or the last Reset.
+type Stats struct {
+	// Observations is the number of values in the summary, not counting
+	// those still buffered.
+	Observations int
+
+	// Items is the number of values retained by the summary.
+	Items int
+
+	// Buffered is the number of values waiting for the next flush.
+	Buffered int
+
+	// Flushes counts the buffers merged into the summary.
+	Flushes int
+
+	// Compressions counts the compression passes over the summary, and
+	// LastRemoved the items the most recent one merged away.
+	// CompressionRatio averages the items before over the items after each
+	// pass, weighting the latest pass by 1/8.  The hook of WithOnCompress
+	// sees the counts of every pass.
+	Compressions     int
+	LastRemoved      int
+	CompressionRatio float64
+
+	// Bytes approximates the memory held by the estimator, its items,
+	// buffer and holdout.
+	Bytes int
+}
+
+// Stats returns the current Stats without flushing the buffer.
+func (est *Estimator) Stats() Stats {
+	held := 0
+	if est.holdout != nil {
+		held = cap(est.holdout.values)
+	}
+	return Stats{
+		Observations:     int(est.observations),
+		Items:            est.Size(),
+		Buffered:         est.Buffered(),
+		Flushes:          est.flushes,
+		Compressions:     est.compressions,
+		LastRemoved:      est.removed,
+		CompressionRatio: est.ratio,
+		Bytes: int(unsafe.Sizeof(*est)) +
+			(cap(est.items)+cap(est.spare))*int(unsafe.Sizeof(item{})) +
+			(cap(est.buffer)+held)*int(unsafe.Sizeof(float64(0))) +
+			cap(est.weighted)*int(unsafe.Sizeof(weightedValue{})),
+	}
+}
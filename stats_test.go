@@ -0,0 +1,130 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+func TestStats(t *testing.T) {
+	est := New(Unknown(0.01))
+	if got := est.Stats(); got.Observations != 0 || got.Items != 0 || got.Flushes != 0 || got.Compressions != 0 {
+		t.Fatalf("want empty stats for a new estimator, got %+v", got)
+	}
+
+	// one full buffer flushes on its own, the rest waits
+	for i := 0; i < 600; i++ {
+		est.Add(float64(i))
+	}
+	s := est.Stats()
+	if s.Observations != 512 || s.Buffered != 88 || s.Flushes != 1 || s.Compressions != 1 {
+		t.Fatalf("after 600 Adds: got %+v", s)
+	}
+	if s.Items != len(est.items) || s.LastRemoved != 512-s.Items {
+		t.Fatalf("want %d items after removing %d, got %+v", len(est.items), 512-len(est.items), s)
+	}
+
+	// Get flushes the rest once, repeating it does nothing
+	est.Get(0.5)
+	est.Get(0.5)
+	s = est.Stats()
+	if s.Observations != 600 || s.Buffered != 0 || s.Flushes != 2 || s.Compressions != 2 {
+		t.Fatalf("after Get: got %+v", s)
+	}
+
+	other := New(Unknown(0.01))
+	other.Add(1000)
+	if err := est.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if s := est.Stats(); s.Observations != 601 || s.Flushes != 2 || s.Compressions != 3 {
+		t.Fatalf("after Merge: got %+v", s)
+	}
+
+	items := len(est.items)
+	est.Reset()
+	s = est.Stats()
+	if s != (Stats{Bytes: s.Bytes}) {
+		t.Fatalf("after Reset of %d items: got %+v", items, s)
+	}
+	if s.Bytes < items*int(unsafe.Sizeof(item{})) {
+		t.Fatalf("%d bytes do not cover the storage of %d items kept by Reset", s.Bytes, items)
+	}
+}
+
+func TestSize(t *testing.T) {
+	est := New(Unknown(0.01))
+	if est.Size() != 0 || est.Buffered() != 0 {
+		t.Fatalf("want an empty estimator, got %d items and %d buffered", est.Size(), est.Buffered())
+	}
+
+	for i := 0; i < 600; i++ {
+		est.Add(float64(i))
+	}
+	est.AddWeighted(1, 3)
+	items := len(est.items)
+	if est.Size() != items || est.Buffered() != 89 {
+		t.Fatalf("want %d items and 89 buffered, got %d and %d", items, est.Size(), est.Buffered())
+	}
+	if est.Size() != items || est.Stats().Flushes != 1 {
+		t.Fatalf("want Size and Buffered not to flush, got %+v", est.Stats())
+	}
+
+	est.Get(0.5)
+	if est.Size() != len(est.items) || est.Size() == items || est.Buffered() != 0 {
+		t.Fatalf("want the flushed %d items and none buffered after Get, got %d and %d", len(est.items), est.Size(), est.Buffered())
+	}
+}
+
+func TestStorageReused(t *testing.T) {
+	// too exact to merge anything, so every item stays in the summary
+	est := New(Unknown(0.0001))
+	fill := func() {
+		for i := 0; i < 1000; i++ {
+			est.Add(float64(i))
+		}
+		est.flush()
+	}
+
+	fill()
+	if s := est.Stats(); s.Items != 1000 || s.CompressionRatio != 1 {
+		t.Fatalf("want every item retained, got %+v", s)
+	}
+
+	// Reset keeps the storage, so refilling it allocates nothing
+	if allocs := testing.AllocsPerRun(10, func() { est.Reset(); fill() }); allocs != 0 {
+		t.Fatalf("want no allocations refilling after Reset, got %f", allocs)
+	}
+}
+
+func TestColdAllocations(t *testing.T) {
+	// the item slices grow by doubling, so a cold estimator allocates a
+	// handful of times rather than once per retained item
+	values := quantiletest.Normal(0, 1).Generate(100000, 1)
+	allocs := testing.AllocsPerRun(1, func() {
+		est := New(Known(0.01, 0.001), Known(0.05, 0.01), Known(0.50, 0.01), Known(0.99, 0.001))
+		for _, v := range values {
+			est.Add(v)
+		}
+		est.Get(0.5)
+	})
+	if allocs > 64 {
+		t.Fatalf("want a handful of allocations for a cold estimator, got %f", allocs)
+	}
+}
+
+func TestCompressionRatio(t *testing.T) {
+	est := New(Unknown(0.1))
+	for i := 0; i < 100000; i++ {
+		est.Add(float64(i % 1000))
+	}
+	s := est.Stats()
+	if s.CompressionRatio <= 1 {
+		t.Fatalf("want compression removing items, got %+v", s)
+	}
+}
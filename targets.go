@@ -0,0 +1,176 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// AnalyzedObservations is the stream length the bounds of a TargetReport are
+// computed for.  Known estimates retain about the same number of items at
+// any length, Unknown grows with its logarithm.
+const AnalyzedObservations = 1e9
+
+// TargetReport describes the memory cost of a set of estimates.
+type TargetReport struct {
+	// Targets analyzes each estimate alone, in the order given.
+	Targets []TargetAnalysis
+
+	// Items bounds the items retained by the estimates combined, and Bytes
+	// the memory of an Estimator holding them.
+	Items float64
+	Bytes float64
+
+	// Minimal is the estimates without the redundant ones.  It allows the
+	// same error at every rank as all of them.
+	Minimal []Estimate
+}
+
+// TargetAnalysis describes one estimate of a TargetReport.
+type TargetAnalysis struct {
+	Estimate Estimate
+
+	// Items bounds the items retained by the estimate alone, and Bytes the
+	// memory of an Estimator holding them.
+	Items float64
+	Bytes float64
+
+	// DominatedBy is the index of an estimate that allows at most the same
+	// error at every rank, making this one redundant, or -1.
+	DominatedBy int
+}
+
+// AnalyzeTargets bounds the items the estimates retain at
+// AnalyzedObservations and finds the estimates another one makes redundant.
+//
+// After compression two neighboring items together are wider than ƒ, so
+// about 2/ƒ(r,n) items are retained per rank, at most one.  The bounds
+// integrate that over the ranks.
+func AnalyzeTargets(targets ...Estimate) TargetReport {
+	n := AnalyzedObservations
+	report := TargetReport{
+		Items: retained(targets, n),
+	}
+	report.Bytes = estimatedBytes(report.Items)
+
+	for i, f := range targets {
+		items := retained([]Estimate{f}, n)
+		a := TargetAnalysis{
+			Estimate:    f,
+			Items:       items,
+			Bytes:       estimatedBytes(items),
+			DominatedBy: -1,
+		}
+		for j, g := range targets {
+			if i != j && dominates(g, f, j < i, targets) {
+				a.DominatedBy = j
+				break
+			}
+		}
+		if a.DominatedBy < 0 {
+			report.Minimal = append(report.Minimal, f)
+		}
+		report.Targets = append(report.Targets, a)
+	}
+	return report
+}
+
+// WithMinimalTargets drops the invariants that another one makes redundant,
+// as reported by AnalyzeTargets.
+func WithMinimalTargets() Option {
+	return func(est *Estimator) {
+		est.invariants = AnalyzeTargets(est.invariants...).Minimal
+	}
+}
+
+func estimatedBytes(items float64) float64 {
+	est := New()
+	return float64(unsafe.Sizeof(*est)) +
+		2*items*float64(unsafe.Sizeof(item{})) +
+		float64(cap(est.buffer))*float64(unsafe.Sizeof(float64(0)))
+}
+
+// retained integrates the items per rank over ranks spaced logarithmically
+// from both ends and from every Known target rank, where ƒ changes fastest.
+func retained(invariants []Estimate, n float64) float64 {
+	est := &Estimator{invariants: invariants}
+	if len(invariants) == 0 {
+		est.invariants = defaultInvariants
+	}
+
+	ranks := []float64{0, n}
+	for d := 1.0; d < n; d *= math.Pow(10, 1.0/32) {
+		ranks = append(ranks, d, n-d)
+		for _, f := range est.invariants {
+			if t, ok := f.(target); ok && t.q > 0 && t.q < 1 {
+				ranks = append(ranks, t.q*n-d, t.q*n+d)
+			}
+		}
+	}
+	sort.Float64s(ranks)
+
+	perRank := func(rank float64) float64 {
+		return math.Min(1, 2/est.invariant(rank, n))
+	}
+
+	items := 0.0
+	prev := 0.0
+	for _, rank := range ranks {
+		if rank <= prev || rank > n {
+			continue
+		}
+		items += (rank - prev) * (perRank(prev) + perRank(rank)) / 2
+		prev = rank
+	}
+	return items
+}
+
+// dominates reports whether g allows at most the error of f at every rank,
+// checked at stream lengths up to AnalyzedObservations.  Of two estimates
+// allowing the same error the earlier one, first, dominates.
+//
+// Unknown, HighBiased and Known are linear between the ends and the target
+// ranks, so comparing them at those ranks, and just above the target ranks
+// where Known switches between its two slopes, compares them everywhere.
+func dominates(g, f Estimate, first bool, all []Estimate) bool {
+	strictly := false
+	for _, n := range dominanceLengths() {
+		ranks := []float64{0, n}
+		for _, e := range all {
+			if t, ok := e.(target); ok {
+				r := math.Floor(t.q * n)
+				ranks = append(ranks, r, math.Nextafter(r, math.Inf(1)))
+			}
+		}
+		for i := 1; i < 64; i++ {
+			ranks = append(ranks, n*float64(i)/64)
+		}
+
+		for _, r := range ranks {
+			// ƒ is capped at n, beyond it every estimate allows the same
+			dg, df := math.Min(g.Delta(r, n), n), math.Min(f.Delta(r, n), n)
+			if dg > df {
+				return false
+			}
+			if dg < df {
+				strictly = true
+			}
+		}
+	}
+	return strictly || first
+}
+
+func dominanceLengths() []float64 {
+	var lengths []float64
+	for n := 1.0; n <= 100; n++ {
+		lengths = append(lengths, n)
+	}
+	for n := 100.0; n <= AnalyzedObservations; n *= math.Pow(10, 1.0/8) {
+		lengths = append(lengths, math.Round(n))
+	}
+	return lengths
+}
@@ -0,0 +1,117 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"testing"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+func TestAnalyzeTargetsDominance(t *testing.T) {
+	cases := []struct {
+		name        string
+		targets     []Estimate
+		dominatedBy []int
+	}{
+		{"tighter tolerance", []Estimate{Known(0.5, 0.01), Known(0.5, 0.005)}, []int{1, -1}},
+		{"tighter unknown", []Estimate{Unknown(0.1), Unknown(0.01)}, []int{1, -1}},
+		{"unknown covers known", []Estimate{Known(0.5, 0.01), Unknown(0.001)}, []int{1, -1}},
+		{"unknown covers a tail", []Estimate{Known(0.99, 0.001), Unknown(0.001)}, []int{1, -1}},
+		{"tail against unknown", []Estimate{Known(0.99, 0.0005), Unknown(0.001)}, []int{-1, -1}},
+		{"disjoint targets", []Estimate{Known(0.5, 0.01), Known(0.99, 0.001)}, []int{-1, -1}},
+		{"duplicates", []Estimate{Known(0.9, 0.01), Known(0.9, 0.01)}, []int{-1, 0}},
+		{"maximum", []Estimate{Known(1, 0.01), Known(0.5, 0.05)}, []int{1, -1}},
+		{"chain", []Estimate{Unknown(0.1), Unknown(0.05), Unknown(0.01)}, []int{1, 2, -1}},
+	}
+
+	for _, c := range cases {
+		report := AnalyzeTargets(c.targets...)
+		minimal := 0
+		for i, a := range report.Targets {
+			if a.DominatedBy != c.dominatedBy[i] {
+				t.Errorf("%s: want target %d dominated by %d, got %d", c.name, i, c.dominatedBy[i], a.DominatedBy)
+			}
+			if a.DominatedBy < 0 {
+				minimal++
+			}
+		}
+		if len(report.Minimal) != minimal {
+			t.Errorf("%s: want %d minimal targets, got %d", c.name, minimal, len(report.Minimal))
+		}
+	}
+}
+
+func TestAnalyzeTargetsMinimalInvariant(t *testing.T) {
+	targets := []Estimate{
+		Known(0.5, 0.05), Known(0.5, 0.01), Known(0.9, 0.01), Known(0.9, 0.005),
+		Known(0.99, 0.001), Known(0.99, 0.005), Known(0, 0.01), Unknown(0.05),
+	}
+	report := AnalyzeTargets(targets...)
+	if len(report.Minimal) >= len(targets) {
+		t.Fatalf("want redundant targets removed, got %d of %d", len(report.Minimal), len(targets))
+	}
+
+	all, minimal := New(targets...), New(report.Minimal...)
+	for _, n := range []float64{1, 7, 10, 99, 1000, 12345, 1e6} {
+		for r := 0.0; r <= n; r += math.Max(1, math.Floor(n/5000)) {
+			if a, b := all.invariant(r, n), minimal.invariant(r, n); a != b {
+				t.Fatalf("n=%g rank %g: invariant %g with all targets, %g with the minimal set", n, r, a, b)
+			}
+		}
+	}
+}
+
+func TestAnalyzeTargetsBound(t *testing.T) {
+	for _, c := range [][]Estimate{
+		{Unknown(0.01)},
+		{Known(0.5, 0.01), Known(0.99, 0.001)},
+		{Known(0.01, 0.001), Known(0.999, 0.0001)},
+	} {
+		est := New(c...)
+		for _, v := range quantiletest.Normal(0, 1).Generate(200000, 1) {
+			est.Add(v)
+		}
+		est.flush()
+		if bound := retained(c, est.observations); float64(len(est.items)) > bound {
+			t.Errorf("%v: %d items exceed the bound of %f", c, len(est.items), bound)
+		}
+	}
+
+	// Unknown keeps every rank up to 1/ε, then 1/(ε·r) per rank
+	report := AnalyzeTargets(Unknown(0.01))
+	if want := (1 + math.Log(0.01*AnalyzedObservations)) / 0.01; math.Abs(report.Items-want) > want/20 {
+		t.Fatalf("want about %f items for Unknown(0.01), got %f", want, report.Items)
+	}
+	if report.Bytes < report.Items*32 {
+		t.Fatalf("want at least 32 bytes per item, got %f for %f items", report.Bytes, report.Items)
+	}
+
+	// the combined bound is at most the sum of the parts
+	report = AnalyzeTargets(Known(0.5, 0.01), Known(0.99, 0.001))
+	if sum := report.Targets[0].Items + report.Targets[1].Items; report.Items > sum {
+		t.Fatalf("combined bound %f exceeds the sum %f", report.Items, sum)
+	}
+}
+
+func TestWithMinimalTargets(t *testing.T) {
+	targets := []Estimate{Known(0.5, 0.01), Known(0.5, 0.005), Known(0.99, 0.001)}
+	minimal := NewWithOptions([]Option{WithMinimalTargets()}, targets...)
+	if len(minimal.invariants) != 2 {
+		t.Fatalf("want 2 invariants, got %d", len(minimal.invariants))
+	}
+
+	all := New(targets...)
+	for _, v := range quantiletest.LogNormal(0, 1).Generate(100000, 1) {
+		all.Add(v)
+		minimal.Add(v)
+	}
+	for q := 0.0; q <= 1; q += 0.01 {
+		if a, b := all.Get(q), minimal.Get(q); a != b {
+			t.Fatalf("q=%f: want %f as with all targets, got %f", q, a, b)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"time"
+)
+
+// windowBuckets is the number of estimators a Windowed rotates through.
+const windowBuckets = 5
+
+// Windowed estimates quantiles of the values added during about the last
+// window, forgetting older ones.  It is not safe for concurrent use.
+//
+// Values are added to the current of five buckets, each covering a fifth of
+// the window.  When the current bucket has covered its fifth, the oldest is
+// discarded and starts again as the current one.  Queries merge the buckets,
+// so they cover between four fifths of the window and all of it.  As with
+// Merge, Known invariants keep their tolerance only while the distribution
+// of the values changes slowly compared to the window.
+type Windowed struct {
+	invariants []Estimate
+	width      time.Duration
+
+	buckets [windowBuckets]*Estimator
+	current int
+	start   time.Time
+
+	// the merge of the buckets, reused by every query
+	merged *Estimator
+
+	now func() time.Time
+}
+
+// NewWindowed allocates an estimator over the last window tolerating the
+// minimum of the invariants like New.
+func NewWindowed(window time.Duration, invariants ...Estimate) *Windowed {
+	w := &Windowed{
+		invariants: invariants,
+		width:      window / windowBuckets,
+		merged:     New(invariants...),
+		now:        time.Now,
+	}
+	if w.width <= 0 {
+		w.width = 1
+	}
+	for i := range w.buckets {
+		w.buckets[i] = New(invariants...)
+	}
+	w.start = w.now()
+	return w
+}
+
+// rotate discards the buckets that are older than the window by now.
+func (w *Windowed) rotate() {
+	elapsed := w.now().Sub(w.start)
+	if elapsed < w.width {
+		return
+	}
+
+	steps := elapsed / w.width
+	w.start = w.start.Add(steps * w.width)
+	if steps > windowBuckets {
+		steps = windowBuckets
+	}
+	for ; steps > 0; steps-- {
+		w.current = (w.current + 1) % windowBuckets
+		w.buckets[w.current].Reset()
+	}
+}
+
+// Add adds value to the current bucket.
+func (w *Windowed) Add(value float64) {
+	w.rotate()
+	w.buckets[w.current].Add(value)
+}
+
+// merge returns the merge of the live buckets.
+func (w *Windowed) merge() *Estimator {
+	w.rotate()
+	w.merged.Reset()
+	for _, b := range w.buckets {
+		// the buckets share the invariants, so the merge cannot fail
+		w.merged.Merge(b)
+	}
+	return w.merged
+}
+
+// Get estimates quantile over the window, or returns NaN if no values were
+// added during it.  It merges the buckets on every call, so to read several
+// quantiles take one Snapshot instead.
+func (w *Windowed) Get(quantile float64) float64 {
+	return w.merge().Get(quantile)
+}
+
+// Snapshot merges the buckets into a read-only summary of the window.
+func (w *Windowed) Snapshot() *Snapshot {
+	return w.merge().Snapshot()
+}
+
+// Count returns the number of values added during the window.
+func (w *Windowed) Count() int64 {
+	w.rotate()
+	var count int64
+	for _, b := range w.buckets {
+		count += b.Count()
+	}
+	return count
+}
+
+// Reset discards the values of every bucket.
+func (w *Windowed) Reset() {
+	for _, b := range w.buckets {
+		b.Reset()
+	}
+	w.merged.Reset()
+	w.current = 0
+	w.start = w.now()
+}
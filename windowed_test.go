@@ -0,0 +1,113 @@
+// Copyright 2013 Sean Treadway, SoundCloud Ltd. All rights reserved.  Use of
+// this source code is governed by a BSD-style license that can be found in the
+// LICENSE file.
+
+package quantile
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/streadway/quantile/quantiletest"
+)
+
+// newFakeWindowed returns a Windowed whose clock the tests advance instead
+// of waiting.
+func newFakeWindowed(window time.Duration, invariants ...Estimate) (*Windowed, *time.Time) {
+	clock := time.Unix(1e9, 0)
+	w := NewWindowed(window, invariants...)
+	w.now = func() time.Time { return clock }
+	w.start = clock
+	return w, &clock
+}
+
+func TestWindowedForgets(t *testing.T) {
+	w, clock := newFakeWindowed(10*time.Minute, Known(0.99, 0.001))
+
+	// a slow minute, then nine normal ones
+	for i := 0; i < 1000; i++ {
+		w.Add(100)
+	}
+	var exact quantiletest.Exact
+	for m := 0; m < 9; m++ {
+		*clock = clock.Add(time.Minute)
+		for _, v := range quantiletest.Normal(1, 0.1).Generate(1000, int64(m)) {
+			w.Add(v)
+			if m >= 1 {
+				exact.Add(v)
+			}
+		}
+	}
+	if got := w.Get(0.99); got != 100 {
+		t.Fatalf("want the slow minute in the window, got p99 %f", got)
+	}
+
+	// the slow minute's bucket is retired, with the next normal one
+	*clock = clock.Add(2 * time.Minute)
+	if got, want := w.Count(), int64(8000); got != want {
+		t.Fatalf("want %d values in the window, got %d", want, got)
+	}
+	quantiletest.AssertWithinRankError(t, &exact, w, 0.99, 0.001)
+}
+
+func TestWindowedEmptyBuckets(t *testing.T) {
+	w, clock := newFakeWindowed(5*time.Second, Unknown(0.01))
+	if got := w.Get(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN before any value, got %f", got)
+	}
+
+	w.Add(1)
+	w.Add(2)
+	w.Add(3)
+
+	// the current bucket is empty right after the rotation
+	*clock = clock.Add(time.Second)
+	if got := w.Get(0.5); got != 2 {
+		t.Fatalf("want the median 2 of the older bucket, got %f", got)
+	}
+	s := w.Snapshot()
+	if got := s.Count(); got != 3 {
+		t.Fatalf("want 3 values in the snapshot, got %d", got)
+	}
+
+	// a gap longer than the window retires every bucket at once
+	*clock = clock.Add(time.Hour)
+	if got := w.Count(); got != 0 {
+		t.Fatalf("want no values after an idle hour, got %d", got)
+	}
+	if got := w.Get(0.5); !math.IsNaN(got) {
+		t.Fatalf("want NaN after an idle hour, got %f", got)
+	}
+	w.Add(4)
+	if got := w.Get(0.5); got != 4 {
+		t.Fatalf("want 4, got %f", got)
+	}
+
+	// the clock going back does not rotate
+	*clock = clock.Add(-time.Minute)
+	if got := w.Count(); got != 1 {
+		t.Fatalf("want 1 value after the clock went back, got %d", got)
+	}
+
+	w.Reset()
+	if got := w.Count(); got != 0 {
+		t.Fatalf("want no values after Reset, got %d", got)
+	}
+}
+
+func TestWindowedRotation(t *testing.T) {
+	w, clock := newFakeWindowed(5*time.Second, Unknown(0.01))
+
+	// a value per bucket, each forgotten five buckets later
+	for i := 0; i < 20; i++ {
+		w.Add(float64(i))
+		if got, want := w.Get(0), float64(i-4); i >= 4 && got != want {
+			t.Fatalf("second %d: want the oldest value %f, got %f", i, want, got)
+		}
+		if got, want := w.Count(), int64(i+1); i < 5 && got != want {
+			t.Fatalf("second %d: want %d values, got %d", i, want, got)
+		}
+		*clock = clock.Add(time.Second + time.Millisecond)
+	}
+}